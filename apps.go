@@ -0,0 +1,431 @@
+// Copyright (c) 2014 Jason Goecke
+// apps.go
+
+package wit
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ImportOptions represents the options of an app import
+type ImportOptions struct {
+	Private bool
+}
+
+// ImportResult represents the app created by an import
+type ImportResult struct {
+	AppID       string `json:"app_id"`
+	AccessToken string `json:"access_token"`
+}
+
+// AppInfo represents the app settings of an exported app
+type AppInfo struct {
+	Name        string `json:"name"`
+	Lang        string `json:"lang,omitempty"`
+	Private     bool   `json:"private,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Sample represents a training sample of an app, an utterance with its
+// intent and the entities marked in it
+type Sample struct {
+	Text     string         `json:"text"`
+	Intent   string         `json:"intent,omitempty"`
+	Entities []SampleEntity `json:"entities,omitempty"`
+	// Traits hold the trait values of the sample, without offsets
+	Traits []SampleEntity `json:"traits,omitempty"`
+}
+
+// SampleEntity represents an entity marked in a sample, with its offsets
+// in the text
+type SampleEntity struct {
+	Entity string `json:"entity"`
+	Role   string `json:"role,omitempty"`
+	Value  string `json:"value"`
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+}
+
+// AppArchive represents the contents of an exported app
+type AppArchive struct {
+	App      AppInfo  `json:"app"`
+	Entities []Entity `json:"entities"`
+	Intents  []Intent `json:"intents"`
+	Samples  []Sample `json:"samples"`
+}
+
+// AppDiff represents the differences between two archives of an app, by
+// entity and intent name and by sample text
+type AppDiff struct {
+	AddedEntities   []string `json:"added_entities,omitempty"`
+	RemovedEntities []string `json:"removed_entities,omitempty"`
+	ChangedEntities []string `json:"changed_entities,omitempty"`
+	AddedIntents    []string `json:"added_intents,omitempty"`
+	RemovedIntents  []string `json:"removed_intents,omitempty"`
+	AddedSamples    []string `json:"added_samples,omitempty"`
+	RemovedSamples  []string `json:"removed_samples,omitempty"`
+	ChangedSamples  []string `json:"changed_samples,omitempty"`
+}
+
+// ExportApp writes the zip archive of the client's app to w. Wit answers
+// with the URL of the archive, which is then downloaded without the
+// access token, nor the client's TLS pins as another host serves it.
+//
+//		file, err := os.Create("app.zip")
+//		err = client.ExportApp(ctx, file)
+func (client *Client) ExportApp(ctx context.Context, w io.Writer) error {
+	result, err := client.processRequestContext(ctx, &HTTPParams{Verb: "GET", Resource: client.APIBase + "/export"})
+	if err != nil {
+		return err
+	}
+	export := &struct {
+		URI string `json:"uri"`
+	}{}
+	if err := json.Unmarshal(result, export); err != nil {
+		return err
+	}
+	if export.URI == "" {
+		return errors.New("no archive url in the export response")
+	}
+
+	req, err := http.NewRequest("GET", export.URI, nil)
+	if err != nil {
+		return err
+	}
+	// The archive is served by another host than the API, whose keys the
+	// pins do not match
+	httpClient := unpinnedClient(client.httpClient())
+	if httpClient != client.httpClient() {
+		defer httpClient.CloseIdleConnections()
+	}
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("downloading the archive: %s", http.StatusText(resp.StatusCode))
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// ImportApp creates an app named name from a zip archive, as written by
+// ExportApp, streaming the archive to Wit
+//
+//		file, err := os.Open("app.zip")
+//		result, err := client.ImportApp(ctx, "weather-staging", file, &wit.ImportOptions{Private: true})
+func (client *Client) ImportApp(ctx context.Context, name string, r io.Reader, opts *ImportOptions) (*ImportResult, error) {
+	if name == "" {
+		return nil, errors.New("an app name is required")
+	}
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+	resource := client.APIBase + "/import?name=" + url.QueryEscape(name) + "&private=" + strconv.FormatBool(opts.Private)
+	resp, err := client.openRequest(ctx, "POST", resource, "application/zip", r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	result := &ImportResult{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ReadAppArchive reads an exported app from a zip file
+//
+//		archive, err := wit.ReadAppArchive("app.zip")
+func ReadAppArchive(filename string) (*AppArchive, error) {
+	reader, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return parseAppArchive(&reader.Reader)
+}
+
+// ParseAppArchive reads an exported app from zip data, e.g. as written by
+// ExportApp to a buffer
+//
+//		var buffer bytes.Buffer
+//		err := client.ExportApp(ctx, &buffer)
+//		archive, err := wit.ParseAppArchive(buffer.Bytes())
+func ParseAppArchive(data []byte) (*AppArchive, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	return parseAppArchive(reader)
+}
+
+// Reads the app settings, entities, intents and samples of an archive.
+// Archives keep their files in a directory named after the app, with the
+// entities and intents one per file and the samples in expressions.json
+// or utterances/*.json depending on the API version they were exported
+// with.
+func parseAppArchive(reader *zip.Reader) (*AppArchive, error) {
+	archive := &AppArchive{}
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() || path.Ext(file.Name) != ".json" {
+			continue
+		}
+		parts := strings.Split(strings.Trim(file.Name, "/"), "/")
+		if len(parts) > 1 && parts[0] != "entities" && parts[0] != "intents" && parts[0] != "utterances" {
+			parts = parts[1:]
+		}
+		data, err := readZipFile(file)
+		if err != nil {
+			return nil, err
+		}
+		data = unwrapArchiveData(data)
+
+		switch {
+		case len(parts) == 1 && parts[0] == "app.json":
+			err = json.Unmarshal(data, &archive.App)
+		case len(parts) == 2 && parts[0] == "entities":
+			var entity *Entity
+			if entity, err = parseArchiveEntity(data); err == nil {
+				archive.Entities = append(archive.Entities, *entity)
+			}
+		case len(parts) == 2 && parts[0] == "intents":
+			intent := Intent{}
+			if err = json.Unmarshal(data, &intent); err == nil {
+				archive.Intents = append(archive.Intents, intent)
+			}
+		case len(parts) == 1 && parts[0] == "expressions.json":
+			var samples []Sample
+			if samples, err = parseArchiveExpressions(data); err == nil {
+				archive.Samples = append(archive.Samples, samples...)
+			}
+		case len(parts) == 2 && parts[0] == "utterances":
+			var samples []Sample
+			if samples, err = parseArchiveUtterances(data); err == nil {
+				archive.Samples = append(archive.Samples, samples...)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file.Name, err)
+		}
+	}
+	sort.Slice(archive.Entities, func(i, j int) bool {
+		return archiveEntityName(&archive.Entities[i]) < archiveEntityName(&archive.Entities[j])
+	})
+	sort.Slice(archive.Intents, func(i, j int) bool { return archive.Intents[i].Name < archive.Intents[j].Name })
+	return archive, nil
+}
+
+func readZipFile(file *zip.File) ([]byte, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// Older archives wrap each file's contents in a data key
+func unwrapArchiveData(data []byte) []byte {
+	wrapper := map[string]json.RawMessage{}
+	if json.Unmarshal(data, &wrapper) != nil || len(wrapper) != 1 {
+		return data
+	}
+	if inner, ok := wrapper["data"]; ok {
+		return inner
+	}
+	return data
+}
+
+// Parses an entity, whose values are keywords with synonyms in newer archives
+func parseArchiveEntity(data []byte) (*Entity, error) {
+	entity := &struct {
+		Entity
+		Keywords []struct {
+			Keyword  string   `json:"keyword"`
+			Synonyms []string `json:"synonyms"`
+		} `json:"keywords"`
+	}{}
+	if err := json.Unmarshal(data, entity); err != nil {
+		return nil, err
+	}
+	for _, keyword := range entity.Keywords {
+		entity.Values = append(entity.Values, EntityValue{Value: keyword.Keyword, Expressions: keyword.Synonyms})
+	}
+	return &entity.Entity, nil
+}
+
+// Parses expressions.json, where the intent is an entity named intent
+func parseArchiveExpressions(data []byte) ([]Sample, error) {
+	expressions := []struct {
+		Text     string `json:"text"`
+		Entities []struct {
+			Entity string          `json:"entity"`
+			Value  json.RawMessage `json:"value"`
+			Start  int             `json:"start"`
+			End    int             `json:"end"`
+		} `json:"entities"`
+	}{}
+	if err := json.Unmarshal(data, &expressions); err != nil {
+		return nil, err
+	}
+	samples := make([]Sample, 0, len(expressions))
+	for _, expression := range expressions {
+		sample := Sample{Text: expression.Text}
+		for _, entity := range expression.Entities {
+			value := archiveValue(entity.Value)
+			if entity.Entity == "intent" {
+				sample.Intent = value
+				continue
+			}
+			sample.Entities = append(sample.Entities, SampleEntity{Entity: entity.Entity, Value: value, Start: entity.Start, End: entity.End})
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+// Parses an utterances file, where entities are named entity:role
+func parseArchiveUtterances(data []byte) ([]Sample, error) {
+	utterances := &struct {
+		Utterances []struct {
+			Text     string `json:"text"`
+			Intent   string `json:"intent"`
+			Entities []struct {
+				Entity string `json:"entity"`
+				Body   string `json:"body"`
+				Start  int    `json:"start"`
+				End    int    `json:"end"`
+			} `json:"entities"`
+			Traits []struct {
+				Trait string          `json:"trait"`
+				Value json.RawMessage `json:"value"`
+			} `json:"traits"`
+		} `json:"utterances"`
+	}{}
+	if err := json.Unmarshal(data, utterances); err != nil {
+		return nil, err
+	}
+	samples := make([]Sample, 0, len(utterances.Utterances))
+	for _, utterance := range utterances.Utterances {
+		sample := Sample{Text: utterance.Text, Intent: utterance.Intent}
+		for _, entity := range utterance.Entities {
+			name, role := entity.Entity, ""
+			if i := strings.Index(name, ":"); i >= 0 {
+				name, role = name[:i], name[i+1:]
+			}
+			sample.Entities = append(sample.Entities, SampleEntity{Entity: name, Role: role, Value: entity.Body, Start: entity.Start, End: entity.End})
+		}
+		for _, trait := range utterance.Traits {
+			sample.Traits = append(sample.Traits, SampleEntity{Entity: trait.Trait, Value: archiveValue(trait.Value)})
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+// Returns a JSON value as a string, unquoting strings. Older archives
+// encode values as JSON strings inside the string.
+func archiveValue(raw json.RawMessage) string {
+	var text string
+	if json.Unmarshal(raw, &text) != nil {
+		return string(raw)
+	}
+	var inner string
+	if strings.HasPrefix(text, `"`) && json.Unmarshal([]byte(text), &inner) == nil {
+		return inner
+	}
+	return text
+}
+
+func archiveEntityName(entity *Entity) string {
+	if entity.Name != "" {
+		return entity.Name
+	}
+	return entity.ID
+}
+
+// DiffAppArchives compares two archives of an app, e.g. before promoting
+// staging to production
+//
+//		diff := wit.DiffAppArchives(production, staging)
+func DiffAppArchives(old *AppArchive, new *AppArchive) *AppDiff {
+	diff := &AppDiff{}
+	oldEntities, newEntities := map[string]string{}, map[string]string{}
+	for i := range old.Entities {
+		oldEntities[archiveEntityName(&old.Entities[i])] = archiveJSON(old.Entities[i])
+	}
+	for i := range new.Entities {
+		newEntities[archiveEntityName(&new.Entities[i])] = archiveJSON(new.Entities[i])
+	}
+	diff.AddedEntities, diff.RemovedEntities, diff.ChangedEntities = diffArchiveKeys(oldEntities, newEntities)
+
+	oldIntents, newIntents := map[string]string{}, map[string]string{}
+	for _, intent := range old.Intents {
+		oldIntents[intent.Name] = ""
+	}
+	for _, intent := range new.Intents {
+		newIntents[intent.Name] = ""
+	}
+	diff.AddedIntents, diff.RemovedIntents, _ = diffArchiveKeys(oldIntents, newIntents)
+
+	oldSamples, newSamples := map[string]string{}, map[string]string{}
+	for _, sample := range old.Samples {
+		oldSamples[sample.Text] = archiveJSON(sample)
+	}
+	for _, sample := range new.Samples {
+		newSamples[sample.Text] = archiveJSON(sample)
+	}
+	diff.AddedSamples, diff.RemovedSamples, diff.ChangedSamples = diffArchiveKeys(oldSamples, newSamples)
+	return diff
+}
+
+// Empty reports whether the archives had no differences
+//
+//		if !diff.Empty() { ... }
+func (diff *AppDiff) Empty() bool {
+	return len(diff.AddedEntities)+len(diff.RemovedEntities)+len(diff.ChangedEntities)+
+		len(diff.AddedIntents)+len(diff.RemovedIntents)+
+		len(diff.AddedSamples)+len(diff.RemovedSamples)+len(diff.ChangedSamples) == 0
+}
+
+func archiveJSON(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// Returns the sorted keys added, removed and whose value changed
+func diffArchiveKeys(old map[string]string, new map[string]string) ([]string, []string, []string) {
+	var added, removed, changed []string
+	for key, value := range new {
+		previous, ok := old[key]
+		if !ok {
+			added = append(added, key)
+		} else if previous != value {
+			changed = append(changed, key)
+		}
+	}
+	for key := range old {
+		if _, ok := new[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2014 Jason Goecke
+// apps_test.go
+
+package wit
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Builds a zip archive from file names and contents
+func testZip(t *testing.T, files map[string]string) []byte {
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for name, contents := range files {
+		file, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write([]byte(contents))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestExportImportApp(t *testing.T) {
+	archive := testZip(t, map[string]string{"weather/app.json": `{"name":"weather"}`})
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/export":
+			if r.Header.Get("Authorization") != "Bearer token" || r.URL.Query().Get("v") == "" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"uri":"` + server.URL + `/archives/weather.zip?signature=abc"}`))
+		case "/archives/weather.zip":
+			if r.Header.Get("Authorization") != "" {
+				http.Error(w, "token leaked to the archive url", http.StatusBadRequest)
+				return
+			}
+			w.Write(archive)
+		case "/import":
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.URL.Query().Get("name") != "weather copy" || r.URL.Query().Get("private") != "true" ||
+				r.Header.Get("Content-Type") != "application/zip" || !bytes.Equal(body, archive) {
+				http.Error(w, "bad import", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"app_id":"a-1","access_token":"new-token"}`))
+		}
+	}))
+	defer server.Close()
+	client := NewClient("token")
+	client.APIBase = server.URL
+
+	var exported bytes.Buffer
+	if err := client.ExportApp(context.Background(), &exported); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(exported.Bytes(), archive) {
+		t.Error("exported archive differs")
+	}
+
+	result, err := client.ImportApp(context.Background(), "weather copy", bytes.NewReader(exported.Bytes()), &ImportOptions{Private: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.AppID != "a-1" || result.AccessToken != "new-token" {
+		t.Errorf("unexpected import result %+v", result)
+	}
+	if _, err := client.ImportApp(context.Background(), "", bytes.NewReader(archive), nil); err == nil {
+		t.Error("expected an import without a name to fail")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.ExportApp(ctx, &exported); err == nil {
+		t.Error("expected a cancelled export to fail")
+	}
+}
+
+func TestExportAppWithPins(t *testing.T) {
+	archive := testZip(t, map[string]string{"weather/app.json": `{"name":"weather"}`})
+	storage := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	defer storage.Close()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"uri":"` + storage.URL + `/weather.zip"}`))
+	}))
+	defer api.Close()
+
+	// Pins matching neither key of the storage, whose root is trusted
+	roots := storage.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	httpClient, err := (&TLSOptions{RootCAs: roots, Pins: []string{testPin}, BackupPins: []string{testPin}}).HTTPClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient("token")
+	client.APIBase = api.URL
+	client.HTTPClient = httpClient
+
+	var exported bytes.Buffer
+	if err := client.ExportApp(context.Background(), &exported); err != nil {
+		t.Fatalf("expected the archive to download without the pins, got %v", err)
+	}
+	if !bytes.Equal(exported.Bytes(), archive) {
+		t.Error("exported archive differs")
+	}
+	if _, err := httpClient.Get(storage.URL); err == nil {
+		t.Error("expected the client itself to keep its pins")
+	}
+}
+
+func TestParseAppArchive(t *testing.T) {
+	old, err := ParseAppArchive(testZip(t, map[string]string{
+		"weather/app.json":             `{"data":{"name":"weather","lang":"en","private":true}}`,
+		"weather/entities/city.json":   `{"data":{"id":"city","doc":"A city","values":[{"value":"Paris","expressions":["Paris","City of Light"]}]}}`,
+		"weather/entities/intent.json": `{"data":{"id":"intent","values":[{"value":"forecast","expressions":[]}]}}`,
+		"weather/expressions.json": `{"data":[
+			{"text":"weather in Paris","entities":[{"entity":"intent","value":"\"forecast\""},{"entity":"city","value":"Paris","start":11,"end":16}]},
+			{"text":"hello","entities":[]}]}`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.App.Name != "weather" || old.App.Lang != "en" || !old.App.Private {
+		t.Errorf("unexpected app %+v", old.App)
+	}
+	if len(old.Entities) != 2 || old.Entities[0].ID != "city" || old.Entities[0].Values[0].Expressions[1] != "City of Light" {
+		t.Errorf("unexpected entities %+v", old.Entities)
+	}
+	sample := old.Samples[0]
+	if len(old.Samples) != 2 || sample.Intent != "forecast" || len(sample.Entities) != 1 || sample.Entities[0] != (SampleEntity{Entity: "city", Value: "Paris", Start: 11, End: 16}) {
+		t.Errorf("unexpected samples %+v", old.Samples)
+	}
+
+	new, err := ParseAppArchive(testZip(t, map[string]string{
+		"weather/app.json":              `{"name":"weather","lang":"en"}`,
+		"weather/entities/city.json":    `{"name":"city","keywords":[{"keyword":"Paris","synonyms":["Paris"]}]}`,
+		"weather/intents/forecast.json": `{"name":"forecast"}`,
+		"weather/intents/greet.json":    `{"name":"greet"}`,
+		"weather/utterances/utterances-1.json": `{"utterances":[
+			{"text":"weather in Paris","intent":"forecast","entities":[{"entity":"city:destination","body":"Paris","start":11,"end":16,"entities":[]}],"traits":[{"trait":"wit$sentiment","value":"neutral"}]},
+			{"text":"hi","intent":"greet","entities":[],"traits":[]}]}`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(new.Intents) != 2 || new.Intents[1].Name != "greet" {
+		t.Errorf("unexpected intents %+v", new.Intents)
+	}
+	sample = new.Samples[0]
+	if sample.Intent != "forecast" || sample.Entities[0].Role != "destination" || sample.Entities[0].Value != "Paris" || sample.Traits[0].Value != "neutral" {
+		t.Errorf("unexpected sample %+v", sample)
+	}
+
+	diff := DiffAppArchives(old, new)
+	if len(diff.RemovedEntities) != 1 || diff.RemovedEntities[0] != "intent" || len(diff.ChangedEntities) != 1 || diff.ChangedEntities[0] != "city" {
+		t.Errorf("unexpected entity diff %+v", diff)
+	}
+	if len(diff.AddedIntents) != 2 || len(diff.AddedSamples) != 1 || diff.AddedSamples[0] != "hi" || diff.RemovedSamples[0] != "hello" || diff.ChangedSamples[0] != "weather in Paris" {
+		t.Errorf("unexpected diff %+v", diff)
+	}
+	if diff.Empty() || !DiffAppArchives(new, new).Empty() {
+		t.Error("unexpected Empty result")
+	}
+
+	if _, err := ParseAppArchive([]byte("not a zip")); err == nil {
+		t.Error("expected invalid data to fail")
+	}
+}
@@ -0,0 +1,369 @@
+// Copyright (c) 2014 Jason Goecke
+// bench.go
+
+package wit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBenchSamples is the number of slowest and failed requests a
+// benchmark report lists
+const DefaultBenchSamples = 10
+
+// BenchRequest represents a text or audio request replayed by a benchmark
+type BenchRequest struct {
+	Text        string `json:"text,omitempty"`
+	Audio       string `json:"audio,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	// MsgID is the msg_id of the request sent, new for each request of the
+	// benchmark
+	MsgID string `json:"-"`
+
+	contents []byte
+}
+
+// BenchSample represents a request of a benchmark, with the msg_id to look
+// it up in Wit's logs
+type BenchSample struct {
+	MsgID   string        `json:"msg_id"`
+	Latency time.Duration `json:"latency"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// LatencySummary represents the distribution of request latencies
+type LatencySummary struct {
+	Min  time.Duration `json:"min"`
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"p50"`
+	P90  time.Duration `json:"p90"`
+	P95  time.Duration `json:"p95"`
+	P99  time.Duration `json:"p99"`
+	P999 time.Duration `json:"p999"`
+	Max  time.Duration `json:"max"`
+}
+
+// BenchReport represents the outcome of a benchmark. Throughput counts
+// successful requests per second, the achieved rate counts every request
+// sent. Errors counts the failed requests by status text or, for network
+// errors, by cause.
+type BenchReport struct {
+	Sent         int            `json:"sent"`
+	Succeeded    int            `json:"succeeded"`
+	Failed       int            `json:"failed"`
+	Errors       map[string]int `json:"errors"`
+	Elapsed      time.Duration  `json:"elapsed"`
+	Throughput   float64        `json:"throughput"`
+	AchievedRate float64        `json:"achieved_rate"`
+	Latency      LatencySummary `json:"latency"`
+	// Slowest are the slowest successful requests, slowest first
+	Slowest []BenchSample `json:"slowest,omitempty"`
+	// Failures are the first requests that failed
+	Failures []BenchSample `json:"failures,omitempty"`
+}
+
+// Bench replays a corpus of requests against the Wit API, or a fake server.
+//
+// With a Rate, requests are scheduled open loop: each request has an
+// intended start time and its latency is measured from that time, so a
+// slow server delays the measurements rather than the schedule and queueing
+// is not hidden (coordinated omission). Concurrency then caps the requests
+// in flight. Without a Rate, Concurrency workers send requests back to back.
+type Bench struct {
+	Client      *Client
+	Corpus      []BenchRequest
+	Rate        float64
+	Concurrency int
+	Duration    time.Duration
+	// Requests stops the benchmark after that many requests when set
+	Requests int
+	// Send replaces the Client when set, and should send request.MsgID
+	Send func(request *BenchRequest) error
+}
+
+// LoadBenchCorpus reads a corpus of requests. Each line is either a JSON
+// request, e.g. {"audio": "hello.wav", "content_type": "audio/wav"}, or
+// plain text to send as a message. Audio files are resolved against the
+// corpus's directory and loaded into memory.
+//
+//		corpus, err := wit.LoadBenchCorpus("./corpus.txt")
+func LoadBenchCorpus(path string) ([]BenchRequest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var corpus []BenchRequest
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		request := BenchRequest{Text: text}
+		if strings.HasPrefix(text, "{") {
+			request = BenchRequest{}
+			if err := json.Unmarshal([]byte(text), &request); err != nil {
+				return nil, fmt.Errorf("corpus line %d: %s", line, err)
+			}
+		}
+		if request.Audio != "" {
+			if !filepath.IsAbs(request.Audio) {
+				request.Audio = filepath.Join(filepath.Dir(path), request.Audio)
+			}
+			if request.contents, err = ioutil.ReadFile(request.Audio); err != nil {
+				return nil, fmt.Errorf("corpus line %d: %s", line, err)
+			}
+			if request.ContentType == "" {
+				request.ContentType = "audio/wav"
+			}
+		}
+		corpus = append(corpus, request)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(corpus) == 0 {
+		return nil, errors.New("empty corpus")
+	}
+	return corpus, nil
+}
+
+// Run runs the benchmark until the duration elapses, the request limit is
+// reached or the context is done
+//
+//		bench := &wit.Bench{Client: client, Corpus: corpus, Rate: 50, Duration: time.Minute}
+//		report, err := bench.Run(ctx)
+func (bench *Bench) Run(ctx context.Context) (*BenchReport, error) {
+	if len(bench.Corpus) == 0 {
+		return nil, errors.New("empty corpus")
+	}
+	if bench.Duration <= 0 && bench.Requests <= 0 {
+		return nil, errors.New("a duration or a number of requests is required")
+	}
+	send := bench.Send
+	if send == nil {
+		send = bench.send
+	}
+
+	recorder := &benchRecorder{errors: map[string]int{}}
+	start := time.Now()
+	if bench.Rate > 0 {
+		bench.runOpenLoop(ctx, start, send, recorder)
+	} else {
+		bench.runClosedLoop(ctx, start, send, recorder)
+	}
+	return recorder.report(time.Since(start)), nil
+}
+
+// Schedules requests at the target rate, measuring from intended start times
+func (bench *Bench) runOpenLoop(ctx context.Context, start time.Time, send func(*BenchRequest) error, recorder *benchRecorder) {
+	var slots chan struct{}
+	if bench.Concurrency > 0 {
+		slots = make(chan struct{}, bench.Concurrency)
+	}
+	interval := float64(time.Second) / bench.Rate
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	var wg sync.WaitGroup
+	for i := 0; bench.Requests <= 0 || i < bench.Requests; i++ {
+		offset := time.Duration(float64(i) * interval)
+		if bench.Duration > 0 && offset >= bench.Duration {
+			break
+		}
+		intended := start.Add(offset)
+		timer.Reset(time.Until(intended))
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-timer.C:
+		}
+		wg.Add(1)
+		go func(request *BenchRequest, intended time.Time) {
+			defer wg.Done()
+			if slots != nil {
+				slots <- struct{}{}
+				defer func() { <-slots }()
+			}
+			err := send(request)
+			recorder.record(request.MsgID, time.Since(intended), err)
+		}(bench.request(i), intended)
+	}
+	wg.Wait()
+}
+
+// Sends requests back to back from each of the workers
+func (bench *Bench) runClosedLoop(ctx context.Context, start time.Time, send func(*BenchRequest) error, recorder *benchRecorder) {
+	workers := bench.Concurrency
+	if workers <= 0 {
+		workers = 1
+	}
+	var next int64 = -1
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				i := int(atomic.AddInt64(&next, 1))
+				if (bench.Requests > 0 && i >= bench.Requests) ||
+					(bench.Duration > 0 && time.Since(start) >= bench.Duration) {
+					return
+				}
+				request := bench.request(i)
+				sent := time.Now()
+				err := send(request)
+				recorder.record(request.MsgID, time.Since(sent), err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Returns the i-th request of the benchmark, with a new msg_id
+func (bench *Bench) request(i int) *BenchRequest {
+	request := bench.Corpus[i%len(bench.Corpus)]
+	request.MsgID = NewCorrelationID()
+	return &request
+}
+
+// Sends a request through the client
+func (bench *Bench) send(request *BenchRequest) error {
+	if request.Audio != "" {
+		_, err := bench.Client.AudioMessage(&MessageRequest{
+			FileContents: request.contents,
+			ContentType:  request.ContentType,
+			MsgID:        request.MsgID,
+		})
+		return err
+	}
+	_, err := bench.Client.Message(&MessageRequest{Query: request.Text, MsgID: request.MsgID})
+	return err
+}
+
+// benchRecorder collects latencies and errors from concurrent requests
+type benchRecorder struct {
+	mutex     sync.Mutex
+	latencies []time.Duration
+	errors    map[string]int
+	failed    int
+	slowest   []BenchSample
+	failures  []BenchSample
+}
+
+func (recorder *benchRecorder) record(msgID string, latency time.Duration, err error) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	if err != nil {
+		recorder.failed++
+		recorder.errors[benchErrorClass(err)]++
+		if len(recorder.failures) < DefaultBenchSamples {
+			recorder.failures = append(recorder.failures, BenchSample{MsgID: msgID, Latency: latency, Error: err.Error()})
+		}
+		return
+	}
+	recorder.latencies = append(recorder.latencies, latency)
+	// Keeps the slowest requests, slowest first
+	i := sort.Search(len(recorder.slowest), func(i int) bool { return recorder.slowest[i].Latency < latency })
+	if i < DefaultBenchSamples {
+		recorder.slowest = append(recorder.slowest, BenchSample{})
+		copy(recorder.slowest[i+1:], recorder.slowest[i:])
+		recorder.slowest[i] = BenchSample{MsgID: msgID, Latency: latency}
+		if len(recorder.slowest) > DefaultBenchSamples {
+			recorder.slowest = recorder.slowest[:DefaultBenchSamples]
+		}
+	}
+}
+
+// Returns the group of an error in reports. The errors of net/http hold the
+// URL, with a new msg_id for each request, and net errors the local port,
+// so they are grouped by cause instead.
+func benchErrorClass(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	if errors.Is(err, context.Canceled) {
+		return "canceled"
+	}
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err.Error()
+	}
+	if urlErr.Timeout() {
+		return "timeout"
+	}
+	var opErr *net.OpError
+	if errors.As(urlErr.Err, &opErr) {
+		return opErr.Op + " " + opErr.Net + ": " + opErr.Err.Error()
+	}
+	return urlErr.Err.Error()
+}
+
+func (recorder *benchRecorder) report(elapsed time.Duration) *BenchReport {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	report := &BenchReport{
+		Succeeded: len(recorder.latencies),
+		Failed:    recorder.failed,
+		Errors:    recorder.errors,
+		Elapsed:   elapsed,
+		Latency:   summarizeLatencies(recorder.latencies),
+		Slowest:   recorder.slowest,
+		Failures:  recorder.failures,
+	}
+	report.Sent = report.Succeeded + report.Failed
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		report.Throughput = float64(report.Succeeded) / seconds
+		report.AchievedRate = float64(report.Sent) / seconds
+	}
+	return report
+}
+
+// Summarizes latencies using nearest-rank percentiles
+func summarizeLatencies(latencies []time.Duration) LatencySummary {
+	if len(latencies) == 0 {
+		return LatencySummary{}
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var total time.Duration
+	for _, latency := range sorted {
+		total += latency
+	}
+	percentile := func(p float64) time.Duration {
+		rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		return sorted[minInt(rank, len(sorted)-1)]
+	}
+	return LatencySummary{
+		Min:  sorted[0],
+		Mean: total / time.Duration(len(sorted)),
+		P50:  percentile(0.50),
+		P90:  percentile(0.90),
+		P95:  percentile(0.95),
+		P99:  percentile(0.99),
+		P999: percentile(0.999),
+		Max:  sorted[len(sorted)-1],
+	}
+}
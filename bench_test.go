@@ -0,0 +1,166 @@
+// Copyright (c) 2014 Jason Goecke
+// bench_test.go
+
+package wit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLoadBenchCorpus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bench")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	audio := mustAbs(t, "./audio_sample/helloWorld.wav")
+	corpus := "# greetings\nhello world\n\n{\"audio\": \"" + audio + "\"}\n"
+	path := filepath.Join(dir, "corpus.txt")
+	if err := ioutil.WriteFile(path, []byte(corpus), 0644); err != nil {
+		t.Fatal(err)
+	}
+	requests, err := LoadBenchCorpus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 || requests[0].Text != "hello world" || requests[1].ContentType != "audio/wav" ||
+		len(requests[1].contents) == 0 {
+		t.Errorf("corpus not parsed properly %+v", requests)
+	}
+}
+
+func TestBenchAgainstFakeServer(t *testing.T) {
+	fake, err := StartFakeServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+	client := NewClient("fake-token")
+	client.APIBase = fake.URL
+
+	audio, err := ioutil.ReadFile("./audio_sample/helloWorld.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bench := &Bench{
+		Client: client,
+		Corpus: []BenchRequest{
+			{Text: "hello world"},
+			{Audio: "helloWorld.wav", ContentType: "audio/wav", contents: audio},
+		},
+		Rate:     200,
+		Requests: 20,
+	}
+	report, err := bench.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Sent != 20 || report.Failed != 0 || fake.Requests() != 20 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if report.Elapsed < 90*time.Millisecond {
+		t.Errorf("requests were not paced, took %s", report.Elapsed)
+	}
+	if report.Latency.P50 <= 0 || report.Latency.Max < report.Latency.P99 {
+		t.Errorf("unexpected latencies %+v", report.Latency)
+	}
+	if len(report.Slowest) != DefaultBenchSamples || report.Slowest[0].Latency != report.Latency.Max ||
+		report.Slowest[0].MsgID == "" || report.Slowest[0].MsgID == report.Slowest[1].MsgID {
+		t.Errorf("expected the slowest requests with their msg_id %+v", report.Slowest)
+	}
+}
+
+func TestBenchOpenLoopMeasuresQueueing(t *testing.T) {
+	// A server that can only handle one request at a time, 10ms each,
+	// scheduled at 200 requests per second: a closed loop would report 10ms,
+	// the open loop reports the time requests spent waiting too
+	bench := &Bench{
+		Corpus:      []BenchRequest{{Text: "hello"}},
+		Rate:        200,
+		Concurrency: 1,
+		Requests:    20,
+		Send: func(request *BenchRequest) error {
+			time.Sleep(10 * time.Millisecond)
+			return nil
+		},
+	}
+	report, err := bench.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Latency.Max < 50*time.Millisecond {
+		t.Errorf("queueing delay not measured, max latency %s", report.Latency.Max)
+	}
+}
+
+func TestBenchClosedLoopErrors(t *testing.T) {
+	calls := 0
+	bench := &Bench{
+		Corpus:   []BenchRequest{{Text: "hello"}},
+		Requests: 10,
+		Send: func(request *BenchRequest) error {
+			calls++
+			if calls%2 == 0 {
+				return errors.New("Too Many Requests")
+			}
+			return nil
+		},
+	}
+	report, err := bench.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Sent != 10 || report.Failed != 5 || report.Errors["Too Many Requests"] != 5 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if len(report.Failures) != 5 || report.Failures[0].MsgID == "" || report.Failures[0].Error != "Too Many Requests" {
+		t.Errorf("expected the failed requests with their msg_id %+v", report.Failures)
+	}
+}
+
+func TestBenchErrorClass(t *testing.T) {
+	refused := func(id string) error {
+		return &url.Error{Op: "Get", URL: "https://api.wit.ai/message?q=hello&msg_id=" + id, Err: &net.OpError{
+			Op: "dial", Net: "tcp", Addr: &net.TCPAddr{Port: 443},
+			Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED},
+		}}
+	}
+	tests := []struct {
+		err   error
+		class string
+	}{
+		{errors.New("Too Many Requests"), "Too Many Requests"},
+		{refused("m-1"), "dial tcp: connect: connection refused"},
+		{refused("m-2"), "dial tcp: connect: connection refused"},
+		{&url.Error{Op: "Get", URL: "https://api.wit.ai/message?msg_id=m-3", Err: context.DeadlineExceeded}, "timeout"},
+		{&url.Error{Op: "Get", URL: "https://api.wit.ai/message?msg_id=m-4", Err: io.ErrUnexpectedEOF}, "unexpected EOF"},
+		{context.Canceled, "canceled"},
+	}
+	for _, test := range tests {
+		if class := benchErrorClass(test.err); class != test.class {
+			t.Errorf("%v: not equal %q != %q", test.err, test.class, class)
+		}
+	}
+}
+
+func TestSummarizeLatencies(t *testing.T) {
+	var latencies []time.Duration
+	for i := 100; i >= 1; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	summary := summarizeLatencies(latencies)
+	if summary.Min != time.Millisecond || summary.P50 != 50*time.Millisecond || summary.P99 != 99*time.Millisecond ||
+		summary.Max != 100*time.Millisecond || summary.Mean != 50500*time.Microsecond {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+}
@@ -0,0 +1,867 @@
+// Copyright (c) 2014 Jason Goecke
+// builtins.go
+
+package wit
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DatetimeLayout is the layout Wit uses for datetime entity values
+const DatetimeLayout = "2006-01-02T15:04:05.000-07:00"
+
+// NormalizedValue represents the normalized portion of a Wit duration entity
+type NormalizedValue struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+// ParseBuiltins runs the offline English parsers for the number, ordinal,
+// duration and datetime builtin entities. The result is keyed the same way
+// as Outcome.Entities. Where entities overlap only the most specific one is
+// kept, e.g. "in 3 days" is a datetime and not also a duration and a number.
+//
+//		entities, err := wit.ParseBuiltins("book it for next Tuesday", &wit.Context{Timezone: "America/Los_Angeles"})
+func ParseBuiltins(text string, context *Context) (map[string][]MessageEntity, error) {
+	datetimes, err := ParseDatetimes(text, context)
+	if err != nil {
+		return nil, err
+	}
+	entities := map[string][]MessageEntity{}
+	var taken []MessageEntity
+	for _, dimension := range []struct {
+		name     string
+		entities []MessageEntity
+	}{
+		{"datetime", datetimes},
+		{"duration", ParseDurations(text)},
+		{"ordinal", ParseOrdinals(text)},
+		{"number", ParseNumbers(text)},
+	} {
+		for _, entity := range dimension.entities {
+			if overlapsAny(entity, taken) {
+				continue
+			}
+			entities[dimension.name] = append(entities[dimension.name], entity)
+		}
+		taken = append(taken, entities[dimension.name]...)
+	}
+	return entities, nil
+}
+
+// ParseNumbers finds cardinal numbers written in words or digits, e.g.
+// "twenty one", "one hundred and five" or "3,000"
+//
+//		entities := wit.ParseNumbers("I need three tickets")
+func ParseNumbers(text string) []MessageEntity {
+	var entities []MessageEntity
+	tokens := tokenize(text)
+	for i := 0; i < len(tokens); {
+		if _, next, ok := parseOrdinalAt(text, tokens, i); ok {
+			i = next
+			continue
+		}
+		value, next, ok := parseNumberAt(text, tokens, i)
+		if !ok {
+			i++
+			continue
+		}
+		entities = append(entities, newBuiltinEntity(text, tokens[i].start, tokens[next-1].end, value))
+		i = next
+	}
+	return entities
+}
+
+// ParseOrdinals finds ordinal numbers written in words or digits, e.g.
+// "second", "twenty-first" or "3rd"
+//
+//		entities := wit.ParseOrdinals("the second one")
+func ParseOrdinals(text string) []MessageEntity {
+	var entities []MessageEntity
+	tokens := tokenize(text)
+	for i := 0; i < len(tokens); {
+		value, next, ok := parseOrdinalAt(text, tokens, i)
+		if !ok {
+			i++
+			continue
+		}
+		entities = append(entities, newBuiltinEntity(text, tokens[i].start, tokens[next-1].end, value))
+		i = next
+	}
+	return entities
+}
+
+// ParseDurations finds durations such as "an hour and a half", "3 days" or
+// "2 hours and 30 minutes". The value is expressed in the smallest unit that
+// keeps it whole, and the normalized value is always in seconds.
+//
+//		entities := wit.ParseDurations("remind me in an hour and a half")
+func ParseDurations(text string) []MessageEntity {
+	var entities []MessageEntity
+	tokens := tokenize(text)
+	for i := 0; i < len(tokens); {
+		parts, next, ok := parseDurationAt(text, tokens, i)
+		if !ok {
+			i++
+			continue
+		}
+		entity := newBuiltinEntity(text, tokens[i].start, tokens[next-1].end, nil)
+		value, unit := durationDisplay(parts)
+		var v interface{} = value
+		entity.Value = &v
+		entity.Unit = &unit
+		entity.Normalized = &NormalizedValue{Value: durationSeconds(parts), Unit: "second"}
+		entities = append(entities, entity)
+		i = next
+	}
+	return entities
+}
+
+// ParseDatetimes finds relative and absolute dates and times, e.g. "next
+// Tuesday", "in 3 days", "Dec 5th" or "tomorrow at 3pm". Expressions are
+// resolved against the context's reference time and timezone, which default
+// to now and the local timezone.
+//
+//		entities, err := wit.ParseDatetimes("in 3 days", &wit.Context{
+//			ReferenceTime: "2015-12-01T10:00:00-08:00",
+//			Timezone:      "America/Los_Angeles",
+//		})
+func ParseDatetimes(text string, context *Context) ([]MessageEntity, error) {
+	ref, err := referenceTime(context)
+	if err != nil {
+		return nil, err
+	}
+
+	dates := findDates(text, ref)
+	times := findTimes(text)
+	var spans []datetimeSpan
+	usedTimes := map[int]bool{}
+	for _, date := range dates {
+		for n, tod := range times {
+			if usedTimes[n] || date.grain != "day" {
+				continue
+			}
+			if (tod.start >= date.end && dateTimeGlue.MatchString(text[date.end:tod.start])) ||
+				(tod.end <= date.start && timeDateGlue.MatchString(text[tod.end:date.start])) {
+				date.value = atTimeOfDay(date.value, tod)
+				date.grain = tod.grain
+				date.start = minInt(date.start, tod.start)
+				date.end = maxInt(date.end, tod.end)
+				usedTimes[n] = true
+				break
+			}
+		}
+		spans = append(spans, date)
+	}
+	for n, tod := range times {
+		if usedTimes[n] {
+			continue
+		}
+		value := atTimeOfDay(ref, tod)
+		if value.Before(ref) {
+			value = atTimeOfDay(ref.AddDate(0, 0, 1), tod)
+		}
+		spans = append(spans, datetimeSpan{tod.start, tod.end, value, tod.grain})
+	}
+
+	var entities []MessageEntity
+	for _, span := range longestSpans(spans) {
+		value := span.value.Format(DatetimeLayout)
+		var v interface{} = value
+		var values []interface{}
+		values = append(values, map[string]interface{}{"type": "value", "value": value, "grain": span.grain})
+		grain := span.grain
+		entity := newBuiltinEntity(text, span.start, span.end, nil)
+		entity.Value = &v
+		entity.Grain = &grain
+		entity.Values = &values
+		entities = append(entities, entity)
+	}
+	return entities, nil
+}
+
+// Returns the reference time for a context, in the context's timezone
+func referenceTime(context *Context) (time.Time, error) {
+	loc := time.Local
+	if context != nil && context.Timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(context.Timezone)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	if context == nil || context.ReferenceTime == "" {
+		return time.Now().In(loc), nil
+	}
+	ref, err := time.Parse(time.RFC3339, context.ReferenceTime)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ref.In(loc), nil
+}
+
+// Creates a MessageEntity shaped like the ones returned for Wit builtins,
+// with start and end as character offsets
+func newBuiltinEntity(text string, start int, end int, value interface{}) MessageEntity {
+	body := text[start:end]
+	entityType := "value"
+	startChar := int64(utf8.RuneCountInString(text[:start]))
+	endChar := startChar + int64(utf8.RuneCountInString(body))
+	entity := MessageEntity{Body: &body, Type: &entityType, Start: &startChar, End: &endChar}
+	if value != nil {
+		entity.Value = &value
+	}
+	return entity
+}
+
+// Reports whether an entity overlaps any of the others
+func overlapsAny(entity MessageEntity, others []MessageEntity) bool {
+	for _, other := range others {
+		if *entity.Start < *other.End && *other.Start < *entity.End {
+			return true
+		}
+	}
+	return false
+}
+
+// token is a word or number within a text, lower-cased, with its byte offsets
+type token struct {
+	text       string
+	start, end int
+}
+
+var tokenRegexp = regexp.MustCompile(`(?i)\d+(?:,\d{3})*(?:\.\d+)?(?:st|nd|rd|th)?|\pL+(?:'\pL+)?`)
+
+// Splits a text into tokens
+func tokenize(text string) []token {
+	var tokens []token
+	for _, loc := range tokenRegexp.FindAllStringIndex(text, -1) {
+		tokens = append(tokens, token{strings.ToLower(text[loc[0]:loc[1]]), loc[0], loc[1]})
+	}
+	return tokens
+}
+
+// Reports whether the token at i directly follows the previous one, separated
+// only by whitespace or a hyphen
+func adjacent(text string, tokens []token, i int) bool {
+	if i <= 0 || i >= len(tokens) {
+		return false
+	}
+	gap := text[tokens[i-1].end:tokens[i].start]
+	return strings.Count(gap, "-") <= 1 && strings.Trim(gap, " \t-") == ""
+}
+
+// Reports whether the token at i is adjacent to the previous one and is word
+func adjacentWord(text string, tokens []token, i int, words ...string) bool {
+	if !adjacent(text, tokens, i) {
+		return false
+	}
+	for _, word := range words {
+		if tokens[i].text == word {
+			return true
+		}
+	}
+	return false
+}
+
+var numberUnits = map[string]float64{
+	"zero": 0, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7, "eight": 8, "nine": 9,
+}
+
+var numberTeens = map[string]float64{
+	"ten": 10, "eleven": 11, "twelve": 12, "thirteen": 13, "fourteen": 14, "fifteen": 15,
+	"sixteen": 16, "seventeen": 17, "eighteen": 18, "nineteen": 19,
+}
+
+var numberTens = map[string]float64{
+	"twenty": 20, "thirty": 30, "forty": 40, "fifty": 50, "sixty": 60, "seventy": 70, "eighty": 80, "ninety": 90,
+}
+
+var numberScales = map[string]float64{
+	"hundred": 100, "dozen": 12, "thousand": 1e3, "million": 1e6, "billion": 1e9,
+}
+
+var ordinalWords = map[string]float64{
+	"first": 1, "second": 2, "third": 3, "fourth": 4, "fifth": 5, "sixth": 6, "seventh": 7, "eighth": 8,
+	"ninth": 9, "tenth": 10, "eleventh": 11, "twelfth": 12, "thirteenth": 13, "fourteenth": 14,
+	"fifteenth": 15, "sixteenth": 16, "seventeenth": 17, "eighteenth": 18, "nineteenth": 19,
+	"twentieth": 20, "thirtieth": 30, "fortieth": 40, "fiftieth": 50, "sixtieth": 60, "seventieth": 70,
+	"eightieth": 80, "ninetieth": 90,
+}
+
+var ordinalScales = map[string]float64{
+	"hundredth": 100, "thousandth": 1e3, "millionth": 1e6, "billionth": 1e9,
+}
+
+// Parses a number written in digits, without an ordinal suffix
+func parseDigits(word string) (float64, bool) {
+	if word == "" || word[0] < '0' || word[0] > '9' {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(strings.Replace(word, ",", "", -1), 64)
+	return value, err == nil
+}
+
+// Parses the longest cardinal number starting at token i, returning its value
+// and the index of the first token after it
+func parseNumberAt(text string, tokens []token, i int) (float64, int, bool) {
+	if value, ok := parseDigits(tokens[i].text); ok {
+		j := i + 1
+		for ; j < len(tokens) && adjacent(text, tokens, j); j++ {
+			scale, ok := numberScales[tokens[j].text]
+			if !ok {
+				break
+			}
+			value *= scale
+		}
+		return value, j, true
+	}
+
+	const (
+		start = iota
+		article
+		unit
+		tens
+		hundred
+		scale
+		and
+	)
+	total, current := 0.0, 0.0
+	last := start
+	end := i
+	for j := i; j < len(tokens); j++ {
+		if j > i && !adjacent(text, tokens, j) {
+			break
+		}
+		word := tokens[j].text
+		if v, ok := numberUnits[word]; ok && (last == start || last == tens || last >= hundred) {
+			if last == tens && v == 0 {
+				break
+			}
+			current += v
+			last = unit
+		} else if v, ok := numberTeens[word]; ok && (last == start || last >= hundred) {
+			current += v
+			last = unit
+		} else if v, ok := numberTens[word]; ok && (last == start || last >= hundred) {
+			current += v
+			last = tens
+		} else if v, ok := numberScales[word]; ok && v < 1000 && last != start && last != and && last != hundred {
+			current = math.Max(current, 1) * v
+			last = hundred
+		} else if v, ok := numberScales[word]; ok && last != start && last != and {
+			total += math.Max(current, 1) * v
+			current = 0
+			last = scale
+		} else if (word == "a" || word == "an") && last == start && j+1 < len(tokens) &&
+			adjacent(text, tokens, j+1) && numberScales[tokens[j+1].text] > 0 {
+			last = article
+			continue
+		} else if word == "and" && (last == hundred || last == scale) && j+1 < len(tokens) &&
+			adjacent(text, tokens, j+1) && isCardinalWord(tokens[j+1].text) {
+			last = and
+			continue
+		} else {
+			break
+		}
+		end = j + 1
+	}
+	if end == i {
+		return 0, i, false
+	}
+	return total + current, end, true
+}
+
+// Reports whether a word is a cardinal number word below one hundred
+func isCardinalWord(word string) bool {
+	_, unit := numberUnits[word]
+	_, teen := numberTeens[word]
+	_, ten := numberTens[word]
+	return unit || teen || ten
+}
+
+// Parses the ordinal number starting at token i, returning its value and the
+// index of the first token after it
+func parseOrdinalAt(text string, tokens []token, i int) (float64, int, bool) {
+	word := tokens[i].text
+	if n := len(word); n > 2 && word[0] >= '0' && word[0] <= '9' {
+		switch word[n-2:] {
+		case "st", "nd", "rd", "th":
+			if value, ok := parseDigits(word[:n-2]); ok && value == math.Trunc(value) {
+				return value, i + 1, true
+			}
+		}
+		return 0, i, false
+	}
+	if value, ok := ordinalWords[word]; ok {
+		return value, i + 1, true
+	}
+
+	value, next, ok := parseNumberAt(text, tokens, i)
+	if !ok || next >= len(tokens) || !adjacent(text, tokens, next) {
+		return 0, i, false
+	}
+	if tokens[next].text == "and" && next+1 < len(tokens) && adjacent(text, tokens, next+1) {
+		next++
+	}
+	if scale, ok := ordinalScales[tokens[next].text]; ok {
+		return value * scale, next + 1, true
+	}
+	if ord, ok := ordinalWords[tokens[next].text]; ok && math.Mod(value, 10) == 0 && (ord < 10 || value >= 100) {
+		return value + ord, next + 1, true
+	}
+	return 0, i, false
+}
+
+// durationPart is a single amount of a unit within a duration, e.g. "3 days"
+type durationPart struct {
+	amount float64
+	unit   string
+}
+
+var durationUnits = map[string]string{
+	"second": "second", "seconds": "second", "sec": "second", "secs": "second",
+	"minute": "minute", "minutes": "minute", "min": "minute", "mins": "minute",
+	"hour": "hour", "hours": "hour", "hr": "hour", "hrs": "hour",
+	"day": "day", "days": "day",
+	"week": "week", "weeks": "week",
+	"month": "month", "months": "month",
+	"year": "year", "years": "year",
+}
+
+// Durations of each unit in seconds, months and years are approximated
+var unitSeconds = map[string]float64{
+	"second": 1, "minute": 60, "hour": 3600, "day": 86400, "week": 604800, "month": 2592000, "year": 31536000,
+}
+
+// The next smaller unit to express fractions of a unit in
+var smallerUnit = map[string]string{
+	"minute": "second", "hour": "minute", "day": "hour", "week": "day", "month": "day", "year": "month",
+}
+
+// Parses the duration starting at token i, returning its parts and the index
+// of the first token after it
+func parseDurationAt(text string, tokens []token, i int) ([]durationPart, int, bool) {
+	var parts []durationPart
+	j := i
+	for {
+		amount, next, ok := parseDurationAmount(text, tokens, j)
+		if !ok || next >= len(tokens) || (next > j && !adjacent(text, tokens, next)) {
+			break
+		}
+		unit, ok := durationUnits[tokens[next].text]
+		if !ok {
+			break
+		}
+		next++
+		if adjacentWord(text, tokens, next, "and") &&
+			adjacentWord(text, tokens, next+1, "a") && adjacentWord(text, tokens, next+2, "half") {
+			amount += 0.5
+			next += 3
+		}
+		parts = append(parts, durationPart{amount, unit})
+		j = next
+		if !adjacentWord(text, tokens, j, "and") {
+			break
+		}
+		j++
+	}
+	if len(parts) == 0 {
+		return nil, i, false
+	}
+	if j > i && tokens[j-1].text == "and" {
+		j--
+	}
+	return parts, j, true
+}
+
+// Parses the amount of a duration part, e.g. "an", "half an" or "one and a half"
+func parseDurationAmount(text string, tokens []token, i int) (float64, int, bool) {
+	if i >= len(tokens) {
+		return 0, i, false
+	}
+	switch tokens[i].text {
+	case "half":
+		if adjacentWord(text, tokens, i+1, "a", "an") {
+			return 0.5, i + 2, true
+		}
+		return 0, i, false
+	case "a", "an":
+		if i+1 < len(tokens) && adjacent(text, tokens, i+1) && numberScales[tokens[i+1].text] == 0 {
+			return 1, i + 1, true
+		}
+	}
+	amount, next, ok := parseNumberAt(text, tokens, i)
+	if !ok {
+		return 0, i, false
+	}
+	if adjacentWord(text, tokens, next, "and") &&
+		adjacentWord(text, tokens, next+1, "a") && adjacentWord(text, tokens, next+2, "half") {
+		amount += 0.5
+		next += 3
+	}
+	return amount, next, true
+}
+
+// Returns the total length of a duration in seconds
+func durationSeconds(parts []durationPart) float64 {
+	total := 0.0
+	for _, part := range parts {
+		total += part.amount * unitSeconds[part.unit]
+	}
+	return total
+}
+
+// Returns the value and unit a duration is best expressed in
+func durationDisplay(parts []durationPart) (float64, string) {
+	if len(parts) == 1 && parts[0].amount == math.Trunc(parts[0].amount) {
+		return parts[0].amount, parts[0].unit
+	}
+	unit := parts[0].unit
+	for _, part := range parts {
+		if unitSeconds[part.unit] < unitSeconds[unit] {
+			unit = part.unit
+		}
+	}
+	total := durationSeconds(parts)
+	value := total / unitSeconds[unit]
+	for value != math.Trunc(value) && smallerUnit[unit] != "" {
+		unit = smallerUnit[unit]
+		value = total / unitSeconds[unit]
+	}
+	return value, unit
+}
+
+// datetimeSpan is a resolved datetime expression within a text
+type datetimeSpan struct {
+	start, end int
+	value      time.Time
+	grain      string
+}
+
+// timeOfDay is a resolved time of day expression within a text
+type timeOfDay struct {
+	start, end   int
+	hour, minute int
+	grain        string
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+}
+
+var months = map[string]time.Month{
+	"jan": time.January, "feb": time.February, "mar": time.March, "apr": time.April, "may": time.May,
+	"jun": time.June, "jul": time.July, "aug": time.August, "sep": time.September, "sept": time.September,
+	"oct": time.October, "nov": time.November, "dec": time.December,
+}
+
+const monthPattern = `(jan(?:uary)?|feb(?:ruary)?|mar(?:ch)?|apr(?:il)?|may|june?|july?|aug(?:ust)?|sept?(?:ember)?|oct(?:ober)?|nov(?:ember)?|dec(?:ember)?)`
+
+var (
+	relativeDayRegexp = regexp.MustCompile(`(?i)\b(now|today|tonight|tomorrow|yesterday|the day after tomorrow|the day before yesterday)\b`)
+	weekdayRegexp     = regexp.MustCompile(`(?i)\b(?:(next|last|this|coming)\s+)?(monday|tuesday|wednesday|thursday|friday|saturday|sunday)\b`)
+	periodRegexp      = regexp.MustCompile(`(?i)\b(next|last|this)\s+(week|month|year)\b`)
+	monthDayRegexp    = regexp.MustCompile(`(?i)\b` + monthPattern + `\.?\s+(\d{1,2})(?:st|nd|rd|th)?\b(?:,?\s+(\d{4})\b)?`)
+	dayMonthRegexp    = regexp.MustCompile(`(?i)\b(?:the\s+)?(\d{1,2})(?:st|nd|rd|th)?\s+(?:of\s+)?` + monthPattern + `\b\.?(?:,?\s+(\d{4})\b)?`)
+	isoDateRegexp     = regexp.MustCompile(`\b(\d{4})-(\d{2})-(\d{2})\b`)
+	slashDateRegexp   = regexp.MustCompile(`\b(\d{1,2})/(\d{1,2})(?:/(\d{4}|\d{2}))?\b`)
+	clockRegexp       = regexp.MustCompile(`(?i)\b(?:at\s+)?(\d{1,2})(?::(\d{2}))?\s*(a\.m\.|p\.m\.|am\b|pm\b)|\b(?:at\s+)?(\d{1,2}):(\d{2})\b|\b(?:at\s+)?(noon|midnight)\b`)
+	relativeInRegexp  = regexp.MustCompile(`(?i)\bin\s+$`)
+	relativeAgoRegexp = regexp.MustCompile(`(?i)^\s+(ago|from now|later)\b`)
+	dateTimeGlue      = regexp.MustCompile(`(?i)^\s*(?:,\s*)?$`)
+	timeDateGlue      = regexp.MustCompile(`(?i)^\s*(?:on\s+)?$`)
+)
+
+// Finds the date expressions in a text, resolved against ref
+func findDates(text string, ref time.Time) []datetimeSpan {
+	var spans []datetimeSpan
+	today := truncateTo(ref, "day")
+
+	for _, m := range relativeDayRegexp.FindAllStringSubmatchIndex(text, -1) {
+		span := datetimeSpan{start: m[0], end: m[1], value: today, grain: "day"}
+		switch strings.ToLower(text[m[2]:m[3]]) {
+		case "now":
+			span.value, span.grain = truncateTo(ref, "second"), "second"
+		case "tonight":
+			span.value, span.grain = today.Add(18*time.Hour), "hour"
+		case "tomorrow":
+			span.value = today.AddDate(0, 0, 1)
+		case "yesterday":
+			span.value = today.AddDate(0, 0, -1)
+		case "the day after tomorrow":
+			span.value = today.AddDate(0, 0, 2)
+		case "the day before yesterday":
+			span.value = today.AddDate(0, 0, -2)
+		}
+		spans = append(spans, span)
+	}
+
+	for _, m := range weekdayRegexp.FindAllStringSubmatchIndex(text, -1) {
+		weekday := weekdays[strings.ToLower(text[m[4]:m[5]])]
+		days := (int(weekday) - int(ref.Weekday()) + 7) % 7
+		if m[2] >= 0 {
+			switch strings.ToLower(text[m[2]:m[3]]) {
+			case "next", "coming":
+				if days == 0 {
+					days = 7
+				}
+			case "last":
+				days -= 7
+				if days == 0 {
+					days = -7
+				}
+			}
+		}
+		spans = append(spans, datetimeSpan{m[0], m[1], today.AddDate(0, 0, days), "day"})
+	}
+
+	for _, m := range periodRegexp.FindAllStringSubmatchIndex(text, -1) {
+		offset := 0
+		switch strings.ToLower(text[m[2]:m[3]]) {
+		case "next":
+			offset = 1
+		case "last":
+			offset = -1
+		}
+		grain := strings.ToLower(text[m[4]:m[5]])
+		value := truncateTo(ref, grain)
+		switch grain {
+		case "week":
+			value = value.AddDate(0, 0, 7*offset)
+		case "month":
+			value = value.AddDate(0, offset, 0)
+		case "year":
+			value = value.AddDate(offset, 0, 0)
+		}
+		spans = append(spans, datetimeSpan{m[0], m[1], value, grain})
+	}
+
+	for _, m := range monthDayRegexp.FindAllStringSubmatchIndex(text, -1) {
+		if span, ok := calendarDate(text, m, m[2:4], m[4:6], m[6:8], ref); ok {
+			spans = append(spans, span)
+		}
+	}
+	for _, m := range dayMonthRegexp.FindAllStringSubmatchIndex(text, -1) {
+		if span, ok := calendarDate(text, m, m[4:6], m[2:4], m[6:8], ref); ok {
+			spans = append(spans, span)
+		}
+	}
+	for _, m := range isoDateRegexp.FindAllStringSubmatchIndex(text, -1) {
+		year, _ := strconv.Atoi(text[m[2]:m[3]])
+		month, _ := strconv.Atoi(text[m[4]:m[5]])
+		day, _ := strconv.Atoi(text[m[6]:m[7]])
+		if value, ok := validDate(year, time.Month(month), day, ref.Location()); ok {
+			spans = append(spans, datetimeSpan{m[0], m[1], value, "day"})
+		}
+	}
+	for _, m := range slashDateRegexp.FindAllStringSubmatchIndex(text, -1) {
+		month, _ := strconv.Atoi(text[m[2]:m[3]])
+		day, _ := strconv.Atoi(text[m[4]:m[5]])
+		year := -1
+		if m[6] >= 0 {
+			year, _ = strconv.Atoi(text[m[6]:m[7]])
+			if year < 100 {
+				year += 2000
+			}
+		}
+		if span, ok := resolveCalendarDate(m[0], m[1], year, time.Month(month), day, ref); ok {
+			spans = append(spans, span)
+		}
+	}
+
+	tokens := tokenize(text)
+	for i := 0; i < len(tokens); {
+		parts, next, ok := parseDurationAt(text, tokens, i)
+		if !ok {
+			i++
+			continue
+		}
+		start, end := tokens[i].start, tokens[next-1].end
+		if m := relativeInRegexp.FindStringIndex(text[:start]); m != nil {
+			value, grain := shiftTime(ref, parts, 1)
+			spans = append(spans, datetimeSpan{m[0], end, value, grain})
+		} else if m := relativeAgoRegexp.FindStringSubmatchIndex(text[end:]); m != nil {
+			sign := 1
+			if strings.ToLower(text[end+m[2]:end+m[3]]) == "ago" {
+				sign = -1
+			}
+			value, grain := shiftTime(ref, parts, sign)
+			spans = append(spans, datetimeSpan{start, end + m[1], value, grain})
+		}
+		i = next
+	}
+	return spans
+}
+
+// Resolves a month name, day and optional year matched by a regexp
+func calendarDate(text string, m []int, monthIdx []int, dayIdx []int, yearIdx []int, ref time.Time) (datetimeSpan, bool) {
+	name := strings.ToLower(text[monthIdx[0]:monthIdx[1]])
+	if len(name) > 4 && strings.HasPrefix(name, "sept") {
+		name = "sept"
+	} else if len(name) > 3 {
+		name = name[:3]
+	}
+	day, _ := strconv.Atoi(text[dayIdx[0]:dayIdx[1]])
+	year := -1
+	if yearIdx[0] >= 0 {
+		year, _ = strconv.Atoi(text[yearIdx[0]:yearIdx[1]])
+	}
+	return resolveCalendarDate(m[0], m[1], year, months[name], day, ref)
+}
+
+// Resolves a calendar date, picking the next occurrence when the year is missing
+func resolveCalendarDate(start int, end int, year int, month time.Month, day int, ref time.Time) (datetimeSpan, bool) {
+	explicit := year >= 0
+	if !explicit {
+		year = ref.Year()
+	}
+	value, ok := validDate(year, month, day, ref.Location())
+	if !ok {
+		return datetimeSpan{}, false
+	}
+	if !explicit && value.Before(truncateTo(ref, "day")) {
+		value, ok = validDate(year+1, month, day, ref.Location())
+	}
+	return datetimeSpan{start, end, value, "day"}, ok
+}
+
+// Returns the date, reporting false when it does not exist, e.g. Feb 30th
+func validDate(year int, month time.Month, day int, loc *time.Location) (time.Time, bool) {
+	value := time.Date(year, month, day, 0, 0, 0, 0, loc)
+	return value, value.Month() == month && value.Day() == day
+}
+
+// Finds the time of day expressions in a text
+func findTimes(text string) []timeOfDay {
+	var times []timeOfDay
+	for _, m := range clockRegexp.FindAllStringSubmatchIndex(text, -1) {
+		tod := timeOfDay{start: m[0], end: m[1], grain: "hour"}
+		switch {
+		case m[2] >= 0:
+			tod.hour, _ = strconv.Atoi(text[m[2]:m[3]])
+			if m[4] >= 0 {
+				tod.minute, _ = strconv.Atoi(text[m[4]:m[5]])
+				tod.grain = "minute"
+			}
+			if tod.hour < 1 || tod.hour > 12 {
+				continue
+			}
+			pm := strings.HasPrefix(strings.ToLower(text[m[6]:m[7]]), "p")
+			if tod.hour == 12 {
+				tod.hour = 0
+			}
+			if pm {
+				tod.hour += 12
+			}
+		case m[8] >= 0:
+			tod.hour, _ = strconv.Atoi(text[m[8]:m[9]])
+			tod.minute, _ = strconv.Atoi(text[m[10]:m[11]])
+			tod.grain = "minute"
+		default:
+			if strings.ToLower(text[m[12]:m[13]]) == "noon" {
+				tod.hour = 12
+			}
+		}
+		if tod.hour > 23 || tod.minute > 59 {
+			continue
+		}
+		times = append(times, tod)
+	}
+	return times
+}
+
+// Returns the day of t at the given time of day
+func atTimeOfDay(t time.Time, tod timeOfDay) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, tod.hour, tod.minute, 0, 0, t.Location())
+}
+
+// Shifts t by a duration, forwards or backwards, returning the shifted time
+// truncated to the grain it is meaningful at
+func shiftTime(t time.Time, parts []durationPart, sign int) (time.Time, string) {
+	grain := "day"
+	for _, part := range parts {
+		whole, fraction := math.Modf(part.amount)
+		n := sign * int(whole)
+		switch part.unit {
+		case "year":
+			t = t.AddDate(n, 0, 0)
+		case "month":
+			t = t.AddDate(0, n, 0)
+		case "week":
+			t = t.AddDate(0, 0, 7*n)
+		case "day":
+			t = t.AddDate(0, 0, n)
+		default:
+			t = t.Add(time.Duration(float64(sign)*whole*unitSeconds[part.unit]) * time.Second)
+			grain = "second"
+		}
+		t = t.Add(time.Duration(float64(sign)*fraction*unitSeconds[part.unit]) * time.Second)
+	}
+	return truncateTo(t, grain), grain
+}
+
+// Truncates t to the start of the grain it falls in, weeks start on Monday
+func truncateTo(t time.Time, grain string) time.Time {
+	y, m, d := t.Date()
+	loc := t.Location()
+	switch grain {
+	case "year":
+		return time.Date(y, 1, 1, 0, 0, 0, 0, loc)
+	case "month":
+		return time.Date(y, m, 1, 0, 0, 0, 0, loc)
+	case "week":
+		return time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, loc)
+	case "day":
+		return time.Date(y, m, d, 0, 0, 0, 0, loc)
+	case "hour":
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, loc)
+	case "minute":
+		return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, loc)
+	}
+	return time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), 0, loc)
+}
+
+// Keeps the longest of any overlapping spans, ordered by position
+func longestSpans(spans []datetimeSpan) []datetimeSpan {
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].end-spans[i].start > spans[j].end-spans[j].start
+	})
+	var kept []datetimeSpan
+	for _, span := range spans {
+		overlaps := false
+		for _, k := range kept {
+			if span.start < k.end && k.start < span.end {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			kept = append(kept, span)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].start < kept[j].start })
+	return kept
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
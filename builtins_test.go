@@ -0,0 +1,159 @@
+// Copyright (c) 2014 Jason Goecke
+// builtins_test.go
+
+package wit
+
+import (
+	"testing"
+)
+
+var builtinContext = &Context{
+	ReferenceTime: "2015-12-01T10:00:00-08:00",
+	Timezone:      "America/Los_Angeles",
+}
+
+func TestParseNumbers(t *testing.T) {
+	tests := map[string]float64{
+		"I need three tickets":        3,
+		"twenty-one guns":             21,
+		"one hundred and five people": 105,
+		"about 3,000 of them":         3000,
+		"a dozen eggs":                12,
+		"two thousand three hundred":  2300,
+		"it costs 4.5":                4.5,
+	}
+	for text, expected := range tests {
+		entities := ParseNumbers(text)
+		if len(entities) != 1 {
+			t.Errorf("%q: expected 1 number, got %d", text, len(entities))
+			continue
+		}
+		if value := (*entities[0].Value).(float64); value != expected {
+			t.Errorf("%q: not equal %v != %v", text, expected, value)
+		}
+	}
+
+	entities := ParseNumbers("one two three")
+	if len(entities) != 3 {
+		t.Errorf("expected 3 separate numbers, got %d", len(entities))
+	}
+}
+
+func TestParseOrdinals(t *testing.T) {
+	tests := map[string]float64{
+		"the second one":            2,
+		"on the 21st":               21,
+		"twenty-first century":      21,
+		"the one hundred and first": 101,
+		"3rd time":                  3,
+	}
+	for text, expected := range tests {
+		entities := ParseOrdinals(text)
+		if len(entities) != 1 {
+			t.Errorf("%q: expected 1 ordinal, got %d", text, len(entities))
+			continue
+		}
+		if value := (*entities[0].Value).(float64); value != expected {
+			t.Errorf("%q: not equal %v != %v", text, expected, value)
+		}
+	}
+	if len(ParseNumbers("the twenty-first")) != 0 {
+		t.Error("ordinals should not also be parsed as numbers")
+	}
+}
+
+func TestParseDurations(t *testing.T) {
+	tests := []struct {
+		text       string
+		value      float64
+		unit       string
+		normalized float64
+		body       string
+	}{
+		{"remind me in an hour and a half", 90, "minute", 5400, "an hour and a half"},
+		{"wait 3 days", 3, "day", 259200, "3 days"},
+		{"half an hour", 30, "minute", 1800, "half an hour"},
+		{"one and a half hours", 90, "minute", 5400, "one and a half hours"},
+		{"2 hours and 30 minutes please", 150, "minute", 9000, "2 hours and 30 minutes"},
+	}
+	for _, test := range tests {
+		entities := ParseDurations(test.text)
+		if len(entities) != 1 {
+			t.Errorf("%q: expected 1 duration, got %d", test.text, len(entities))
+			continue
+		}
+		entity := entities[0]
+		if (*entity.Value).(float64) != test.value || *entity.Unit != test.unit {
+			t.Errorf("%q: not equal %v %s != %v %s", test.text, test.value, test.unit, *entity.Value, *entity.Unit)
+		}
+		if entity.Normalized.Value != test.normalized || entity.Normalized.Unit != "second" {
+			t.Errorf("%q: normalized not equal %v != %v", test.text, test.normalized, entity.Normalized.Value)
+		}
+		if *entity.Body != test.body {
+			t.Errorf("%q: body not equal %q != %q", test.text, test.body, *entity.Body)
+		}
+	}
+}
+
+func TestParseDatetimes(t *testing.T) {
+	// The reference time is Tuesday, December 1st 2015 at 10am
+	tests := []struct {
+		text  string
+		value string
+		grain string
+	}{
+		{"next Tuesday", "2015-12-08T00:00:00.000-08:00", "day"},
+		{"on Friday", "2015-12-04T00:00:00.000-08:00", "day"},
+		{"last monday", "2015-11-30T00:00:00.000-08:00", "day"},
+		{"in 3 days", "2015-12-04T00:00:00.000-08:00", "day"},
+		{"in two hours", "2015-12-01T12:00:00.000-08:00", "second"},
+		{"2 weeks ago", "2015-11-17T00:00:00.000-08:00", "day"},
+		{"Dec 5th", "2015-12-05T00:00:00.000-08:00", "day"},
+		{"the 3rd of January", "2016-01-03T00:00:00.000-08:00", "day"},
+		{"November 20, 2015", "2015-11-20T00:00:00.000-08:00", "day"},
+		{"2016-02-29", "2016-02-29T00:00:00.000-08:00", "day"},
+		{"tomorrow at 3pm", "2015-12-02T15:00:00.000-08:00", "hour"},
+		{"at 9:30 on Friday", "2015-12-04T09:30:00.000-08:00", "minute"},
+		{"at 8am", "2015-12-02T08:00:00.000-08:00", "hour"},
+		{"next week", "2015-12-07T00:00:00.000-08:00", "week"},
+		{"this month", "2015-12-01T00:00:00.000-08:00", "month"},
+	}
+	for _, test := range tests {
+		entities, err := ParseDatetimes(test.text, builtinContext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entities) != 1 {
+			t.Errorf("%q: expected 1 datetime, got %d", test.text, len(entities))
+			continue
+		}
+		entity := entities[0]
+		if (*entity.Value).(string) != test.value || *entity.Grain != test.grain {
+			t.Errorf("%q: not equal %s %s != %s %s", test.text, test.value, test.grain, *entity.Value, *entity.Grain)
+		}
+		if len(*entity.Values) != 1 {
+			t.Errorf("%q: expected the value to be repeated in values", test.text)
+		}
+	}
+
+	if _, err := ParseDatetimes("today", &Context{Timezone: "Nowhere/Special"}); err == nil {
+		t.Error("expected an error for an unknown timezone")
+	}
+}
+
+func TestParseBuiltins(t *testing.T) {
+	entities, err := ParseBuiltins("book 2 seats for the 3rd show in 3 days, for an hour", builtinContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities["datetime"]) != 1 || len(entities["duration"]) != 1 ||
+		len(entities["ordinal"]) != 1 || len(entities["number"]) != 1 {
+		t.Fatalf("unexpected entities %v", entities)
+	}
+	if *entities["datetime"][0].Body != "in 3 days" {
+		t.Errorf("not equal %q != %q", "in 3 days", *entities["datetime"][0].Body)
+	}
+	if *entities["number"][0].Start != 5 || *entities["number"][0].End != 6 {
+		t.Error("number offsets not set properly")
+	}
+}
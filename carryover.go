@@ -0,0 +1,357 @@
+// Copyright (c) 2014 Jason Goecke
+// carryover.go
+
+package wit
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// SessionFrameKey is the session data key holding the conversation's frame
+const SessionFrameKey = "frame"
+
+// DefaultCarryOverTurns is the number of turns an entity is carried over
+// without being mentioned again
+const DefaultCarryOverTurns = 3
+
+// How new values of an entity combine with the carried ones
+const (
+	// CarryReplace replaces the carried values, the default
+	CarryReplace = "replace"
+	// CarryAppend adds to the carried values, e.g. for pizza toppings
+	CarryAppend = "append"
+	// CarryNone never carries the entity over to the next turn
+	CarryNone = "none"
+)
+
+// Names of the location and datetime entities, by API version
+var (
+	LocationEntities = []string{"location", "wit$location", "wit$location:location"}
+	DatetimeEntities = []string{"datetime", "wit$datetime", "wit$datetime:datetime"}
+)
+
+// DefaultReferences maps the words referring to an earlier entity to the
+// names it may have
+var DefaultReferences = map[string][]string{
+	"there":          LocationEntities,
+	"that place":     LocationEntities,
+	"the same place": LocationEntities,
+	"then":           DatetimeEntities,
+	"that day":       DatetimeEntities,
+	"that time":      DatetimeEntities,
+	"the same day":   DatetimeEntities,
+	"the same time":  DatetimeEntities,
+}
+
+// DefaultPronouns are the words referring to the last entity mentioned
+var DefaultPronouns = []string{"it", "that", "that one", "this one", "them"}
+
+// CarryOverRule represents how an entity is carried over. Zero fields use
+// the CarryOver's settings.
+type CarryOverRule struct {
+	// Mode is CarryReplace, CarryAppend or CarryNone
+	Mode string `json:"mode,omitempty"`
+	// Turns is the number of turns the entity is carried without being
+	// mentioned again
+	Turns int `json:"turns,omitempty"`
+	// TTL drops the entity once it was last mentioned longer ago
+	TTL time.Duration `json:"ttl,omitempty"`
+}
+
+// CarryOver merges the entities of a conversation's turns into a frame
+// kept in the session, so "book a flight to Paris", "actually make it
+// Tuesday" then "and for two people" build up one request. Messages
+// without an intent, or one below MinConfidence, continue the frame's
+// intent. The zero value carries entities for DefaultCarryOverTurns turns
+// and resolves DefaultReferences and DefaultPronouns.
+type CarryOver struct {
+	// Turns is the number of turns entities are carried without being
+	// mentioned again, DefaultCarryOverTurns when 0
+	Turns int
+	// TTL drops entities last mentioned longer ago when set
+	TTL time.Duration
+	// Rules overrides the settings of entities by name
+	Rules map[string]CarryOverRule
+	// ResetOnIntent drops the carried entities when the intent changes
+	ResetOnIntent bool
+	// MinConfidence is the confidence below which an intent is ignored
+	MinConfidence float32
+	// References and Pronouns replace DefaultReferences and DefaultPronouns
+	// when set
+	References map[string][]string
+	Pronouns   []string
+}
+
+// Frame represents what a conversation is about so far: the intent and
+// the entities of its turns
+type Frame struct {
+	Intent string                `json:"intent,omitempty"`
+	Slots  map[string]*FrameSlot `json:"slots"`
+	Turn   int                   `json:"turn"`
+	// Focus is the last entity mentioned, which pronouns refer to
+	Focus string `json:"focus,omitempty"`
+	// References are the references of the last turn resolved to
+	// carried entities
+	References []FrameReference `json:"references,omitempty"`
+}
+
+// FrameSlot represents the values of an entity in a frame
+type FrameSlot struct {
+	Values    []MessageEntity `json:"values"`
+	Turn      int             `json:"turn"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	// Carried is true when the values come from earlier turns
+	Carried bool `json:"carried"`
+}
+
+// FrameReference represents a word of the text referring to an entity of
+// an earlier turn
+type FrameReference struct {
+	Text   string `json:"text"`
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+	Entity string `json:"entity"`
+}
+
+// Key of the frame in a context
+type frameKey struct{}
+
+// WithFrame returns a context carrying a frame
+//
+//		ctx = wit.WithFrame(ctx, frame)
+func WithFrame(ctx context.Context, frame *Frame) context.Context {
+	return context.WithValue(ctx, frameKey{}, frame)
+}
+
+// CurrentFrame returns the frame of a context, set by pipelines with a
+// CarryOver for their handlers
+//
+//		frame, ok := wit.CurrentFrame(ctx)
+//		city, ok := frame.Value("location")
+func CurrentFrame(ctx context.Context) (*Frame, bool) {
+	frame, ok := ctx.Value(frameKey{}).(*Frame)
+	return frame, ok && frame != nil
+}
+
+// SessionFrame returns a copy of the session's frame, or an empty frame
+//
+//		frame := wit.SessionFrame(session)
+func SessionFrame(session *Session) *Frame {
+	frame := &Frame{}
+	if value, ok := session.Get(SessionFrameKey); ok {
+		// Frames read from files are maps, frames in memory are shared
+		// with the store, both are decoded into a new frame
+		if data, err := json.Marshal(value); err == nil {
+			json.Unmarshal(data, frame)
+		}
+	}
+	if frame.Slots == nil {
+		frame.Slots = map[string]*FrameSlot{}
+	}
+	return frame
+}
+
+// Value returns the latest value of an entity
+//
+//		date, ok := frame.Value("datetime")
+func (frame *Frame) Value(entity string) (MessageEntity, bool) {
+	slot, ok := frame.Slots[entity]
+	if !ok || len(slot.Values) == 0 {
+		return MessageEntity{}, false
+	}
+	return slot.Values[len(slot.Values)-1], true
+}
+
+// Values returns the values of an entity
+//
+//		toppings := frame.Values("topping")
+func (frame *Frame) Values(entity string) []MessageEntity {
+	if slot, ok := frame.Slots[entity]; ok {
+		return slot.Values
+	}
+	return nil
+}
+
+// Apply merges a message into the session's frame, saves the frame into
+// the session and returns it. The message may be nil, its turn then only
+// ages the frame.
+//
+//		frame := carryOver.Apply(session, message)
+func (carryOver *CarryOver) Apply(session *Session, message *Message) *Frame {
+	frame := SessionFrame(session)
+	frame.Turn++
+	frame.References = nil
+	now := time.Now()
+
+	var outcome *Outcome
+	if message != nil && len(message.Outcomes) > 0 {
+		outcome = &message.Outcomes[0]
+	}
+	if outcome != nil && outcome.Intent != "" && outcome.Confidence >= carryOver.MinConfidence {
+		if carryOver.ResetOnIntent && frame.Intent != "" && frame.Intent != outcome.Intent {
+			frame.Slots = map[string]*FrameSlot{}
+			frame.Focus = ""
+		}
+		frame.Intent = outcome.Intent
+	}
+
+	for name, slot := range frame.Slots {
+		rule := carryOver.rule(name)
+		if rule.Mode == CarryNone || frame.Turn-slot.Turn > rule.Turns || (rule.TTL > 0 && now.Sub(slot.UpdatedAt) > rule.TTL) {
+			delete(frame.Slots, name)
+			continue
+		}
+		slot.Carried = true
+	}
+	focus := frame.Focus
+	if _, ok := frame.Slots[focus]; !ok {
+		focus = ""
+	}
+
+	mentioned := false
+	if outcome != nil {
+		names := make([]string, 0, len(outcome.Entities))
+		for name := range outcome.Entities {
+			names = append(names, name)
+		}
+		// In the order of the text, so the focus is the last entity said
+		sort.Slice(names, func(i, j int) bool {
+			start, other := entityStart(outcome.Entities[names[i]]), entityStart(outcome.Entities[names[j]])
+			if start != other {
+				return start < other
+			}
+			return names[i] < names[j]
+		})
+		for _, name := range names {
+			values := outcome.Entities[name]
+			if len(values) == 0 {
+				continue
+			}
+			slot, ok := frame.Slots[name]
+			if !ok || carryOver.rule(name).Mode != CarryAppend {
+				slot = &FrameSlot{}
+				frame.Slots[name] = slot
+			}
+			slot.Values = append(slot.Values, values...)
+			slot.Turn = frame.Turn
+			slot.UpdatedAt = now
+			slot.Carried = false
+			frame.Focus = name
+			mentioned = true
+		}
+	}
+
+	if message != nil {
+		text := message.Text
+		if text == "" && outcome != nil {
+			text = outcome.Text
+		}
+		carryOver.resolve(frame, text, focus, mentioned, now)
+	}
+	session.Set(SessionFrameKey, frame)
+	return frame
+}
+
+// Resolves the references of a text to the carried entities, mentioning
+// them again. Pronouns only refer to the previous focus when the turn
+// mentions no entity, so "make it Tuesday" does not resolve "it".
+func (carryOver *CarryOver) resolve(frame *Frame, text string, focus string, mentioned bool, now time.Time) {
+	targets := carryOver.References
+	if targets == nil {
+		targets = DefaultReferences
+	}
+	pronouns := carryOver.Pronouns
+	if pronouns == nil {
+		pronouns = DefaultPronouns
+	}
+	phrases := make([]string, 0, len(targets)+len(pronouns))
+	for phrase := range targets {
+		phrases = append(phrases, phrase)
+	}
+	phrases = append(phrases, pronouns...)
+	// Longer phrases first, so "that day" is not taken for "that"
+	sort.SliceStable(phrases, func(i, j int) bool {
+		if len(phrases[i]) != len(phrases[j]) {
+			return len(phrases[i]) > len(phrases[j])
+		}
+		return phrases[i] < phrases[j]
+	})
+
+	var used [][]int
+	for _, phrase := range phrases {
+		candidates, isReference := targets[phrase]
+		if !isReference {
+			if mentioned {
+				continue
+			}
+			candidates = []string{focus}
+		}
+		entity := ""
+		for _, candidate := range candidates {
+			if slot, ok := frame.Slots[candidate]; ok && candidate != "" {
+				if !slot.Carried {
+					// The turn mentions the entity itself
+					entity = ""
+					break
+				}
+				entity = candidate
+				break
+			}
+		}
+		if entity == "" {
+			continue
+		}
+		pattern := regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(phrase) + `\b`)
+		for _, match := range pattern.FindAllStringIndex(text, -1) {
+			if overlaps(used, match) {
+				continue
+			}
+			used = append(used, match)
+			frame.References = append(frame.References, FrameReference{Text: text[match[0]:match[1]], Start: match[0], End: match[1], Entity: entity})
+			slot := frame.Slots[entity]
+			slot.Turn = frame.Turn
+			slot.UpdatedAt = now
+		}
+	}
+	sort.Slice(frame.References, func(i, j int) bool { return frame.References[i].Start < frame.References[j].Start })
+}
+
+// Returns the rule of an entity, with the defaults filled in
+func (carryOver *CarryOver) rule(entity string) CarryOverRule {
+	rule := carryOver.Rules[entity]
+	if rule.Mode == "" {
+		rule.Mode = CarryReplace
+	}
+	if rule.Turns == 0 {
+		rule.Turns = carryOver.Turns
+	}
+	if rule.Turns == 0 {
+		rule.Turns = DefaultCarryOverTurns
+	}
+	if rule.TTL == 0 {
+		rule.TTL = carryOver.TTL
+	}
+	return rule
+}
+
+// Returns where the first value of an entity starts in the text
+func entityStart(values []MessageEntity) int64 {
+	if len(values) > 0 && values[0].Start != nil {
+		return *values[0].Start
+	}
+	return 0
+}
+
+// Reports whether a match overlaps one of the ranges
+func overlaps(ranges [][]int, match []int) bool {
+	for _, used := range ranges {
+		if match[0] < used[1] && used[0] < match[1] {
+			return true
+		}
+	}
+	return false
+}
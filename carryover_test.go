@@ -0,0 +1,148 @@
+// Copyright (c) 2014 Jason Goecke
+// carryover_test.go
+
+package wit
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// Builds a message of one outcome from entity names and values
+func frameMessage(text string, intent string, entities map[string]string) *Message {
+	outcome := Outcome{Text: text, Intent: intent, Confidence: 0.9, Entities: map[string][]MessageEntity{}}
+	for name, value := range entities {
+		outcome.Entities[name] = []MessageEntity{contactEntity(value)}
+	}
+	return &Message{Text: text, Outcomes: []Outcome{outcome}}
+}
+
+func frameValue(frame *Frame, entity string) interface{} {
+	value, ok := frame.Value(entity)
+	if !ok {
+		return nil
+	}
+	return *value.Value
+}
+
+func TestCarryOver(t *testing.T) {
+	carryOver := &CarryOver{Rules: map[string]CarryOverRule{
+		"topping":  {Mode: CarryAppend},
+		"yes_no":   {Mode: CarryNone},
+		"discount": {Turns: 1},
+	}}
+	session := NewSession("user-42")
+
+	frame := carryOver.Apply(session, frameMessage("book a flight to Paris", "book_flight", map[string]string{"location": "Paris", "discount": "student"}))
+	if frame.Intent != "book_flight" || frameValue(frame, "location") != "Paris" || frame.Slots["location"].Carried {
+		t.Errorf("unexpected first frame %+v", frame)
+	}
+	frame = carryOver.Apply(session, frameMessage("actually make it Tuesday", "", map[string]string{"datetime": "2026-10-20", "yes_no": "yes"}))
+	if frame.Intent != "book_flight" || frameValue(frame, "location") != "Paris" || !frame.Slots["location"].Carried || frameValue(frame, "datetime") != "2026-10-20" {
+		t.Errorf("expected the flight to carry over %+v", frame)
+	}
+	if len(frame.References) != 0 {
+		t.Errorf("expected it not to resolve when the turn has entities %+v", frame.References)
+	}
+	frame = carryOver.Apply(session, frameMessage("and for two people, with cheese", "", map[string]string{"number": "2", "topping": "cheese"}))
+	if frameValue(frame, "datetime") != "2026-10-20" || frameValue(frame, "number") != "2" || frame.Slots["yes_no"] != nil || frame.Slots["discount"] != nil {
+		t.Errorf("unexpected third frame %+v", frame.Slots)
+	}
+	frame = carryOver.Apply(session, frameMessage("what's the weather there then?", "weather", nil))
+	if frame.Intent != "weather" || len(frame.References) != 2 {
+		t.Fatalf("expected there and then to resolve %+v", frame.References)
+	}
+	if frame.References[0] != (FrameReference{Text: "there", Start: 19, End: 24, Entity: "location"}) || frame.References[1].Entity != "datetime" {
+		t.Errorf("unexpected references %+v", frame.References)
+	}
+	frame = carryOver.Apply(session, frameMessage("and ham", "", map[string]string{"topping": "ham"}))
+	if toppings := frame.Values("topping"); len(toppings) != 2 || *toppings[0].Value != "cheese" || *toppings[1].Value != "ham" {
+		t.Errorf("expected toppings to add up %+v", toppings)
+	}
+
+	frame = carryOver.Apply(session, frameMessage("cancel it", "cancel", nil))
+	if len(frame.References) != 1 || frame.References[0].Entity != "topping" {
+		t.Errorf("expected it to refer to the focus %+v", frame.References)
+	}
+
+	for i := 0; i < DefaultCarryOverTurns+1; i++ {
+		frame = carryOver.Apply(session, nil)
+	}
+	if len(frame.Slots) != 0 {
+		t.Errorf("expected entities to decay %+v", frame.Slots)
+	}
+
+	reset := &CarryOver{ResetOnIntent: true, MinConfidence: 0.5}
+	session = NewSession("user-43")
+	reset.Apply(session, frameMessage("to Paris", "book_flight", map[string]string{"location": "Paris"}))
+	unsure := frameMessage("hmm", "greet", nil)
+	unsure.Outcomes[0].Confidence = 0.2
+	if frame = reset.Apply(session, unsure); frame.Intent != "book_flight" || frame.Slots["location"] == nil {
+		t.Errorf("expected a low confidence intent to continue the frame %+v", frame)
+	}
+	if frame = reset.Apply(session, frameMessage("hello", "greet", nil)); frame.Intent != "greet" || len(frame.Slots) != 0 {
+		t.Errorf("expected a new intent to reset the frame %+v", frame)
+	}
+
+	ttl := &CarryOver{TTL: time.Minute}
+	session = NewSession("user-44")
+	ttl.Apply(session, frameMessage("to Paris", "book_flight", map[string]string{"location": "Paris"}))
+	storedSlot(session, "location").UpdatedAt = time.Now().Add(-2 * time.Minute)
+	if frame = ttl.Apply(session, nil); len(frame.Slots) != 0 {
+		t.Errorf("expected entities to expire %+v", frame.Slots)
+	}
+}
+
+// Returns a slot of the frame stored in the session
+func storedSlot(session *Session, entity string) *FrameSlot {
+	return session.Data[SessionFrameKey].(*Frame).Slots[entity]
+}
+
+func TestPipelineCarryOver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frames")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	messages := map[string]*Message{
+		"book a flight to Paris":   frameMessage("book a flight to Paris", "book_flight", map[string]string{"location": "Paris"}),
+		"actually make it Tuesday": frameMessage("actually make it Tuesday", "", map[string]string{"datetime": "Tuesday"}),
+	}
+	var frames []*Frame
+	pipeline := &Pipeline{
+		Understand: func(ctx context.Context, request *MessageRequest) (*Message, error) {
+			return messages[request.Query], nil
+		},
+		Handler: HandlerFunc(func(ctx context.Context, message *Message, session *Session) (*Reply, error) {
+			frame, ok := CurrentFrame(ctx)
+			if !ok {
+				t.Error("expected the handler to get the frame")
+			}
+			frames = append(frames, frame)
+			return &Reply{Text: "ok"}, nil
+		}),
+		Sessions:  NewFileSessionStore(dir, nil),
+		CarryOver: &CarryOver{},
+	}
+	for _, text := range []string{"book a flight to Paris", "actually make it Tuesday"} {
+		if _, err := pipeline.Process(context.Background(), "user-42", text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(frames) != 2 || frameValue(frames[1], "location") != "Paris" || frameValue(frames[1], "datetime") != "Tuesday" || frames[1].Intent != "book_flight" {
+		t.Errorf("expected the frame to survive the file store %+v", frames)
+	}
+
+	session, _ := pipeline.Sessions.Load("user-42")
+	data, _ := json.Marshal(SessionFrame(session))
+	stored := &Frame{}
+	json.Unmarshal(data, stored)
+	if stored.Turn != 2 || stored.Focus != "datetime" {
+		t.Errorf("unexpected stored frame %s", data)
+	}
+}
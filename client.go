@@ -5,8 +5,10 @@ package wit
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -25,6 +27,28 @@ const (
 // Client represents a client for the Wit API (https://wit.ai/docs/api)
 type Client struct {
 	APIBase string
+	// APIKey is the access token for this client, the package APIKey when empty
+	APIKey string
+	// Version is the API version date sent as the v= parameter, APIVersion when empty
+	Version string
+	// AppVersion is the app version tag requests are made against, if any
+	AppVersion string
+	// Timezone and Locale are sent as the message context when a request has none
+	Timezone string
+	Locale   string
+	// RateLimiter limits the rate of requests when set
+	RateLimiter *RateLimiter
+	// HTTPClient is used to make requests, http.DefaultClient when nil
+	HTTPClient *http.Client
+	// Endpoints, when set, serves requests made against APIBase from its
+	// endpoints instead, failing over between them
+	Endpoints *EndpointPool
+	// TraceID, when set, returns the caller's trace id for a context, used
+	// as the msg_id of messages without a correlation id
+	TraceID func(ctx context.Context) string
+	// Logger, when set, logs each message with its msg_id and thread_id,
+	// and warns once about each deprecated endpoint called
+	Logger *log.Logger
 }
 
 // HTTPParams represents the HTTP parameters to pass along to the Wit API
@@ -42,46 +66,46 @@ var APIKey string
 //
 //		client := wit.NewClient("<ACCESS-TOKEN>")
 func NewClient(apiKey string) *Client {
-	client := &Client{APIBase: "https://api.wit.ai"}
+	client := &Client{APIBase: "https://api.wit.ai", APIKey: apiKey}
 	APIKey = apiKey
 	return client
 }
 
 // Provides a common facility for doing a DELETE on a Wit resource
 //
-//		result, err := delete("https://api.wit.ai/entities", "favorite_city")
-func delete(resource string, id string) ([]byte, error) {
+//		result, err := client.delete("https://api.wit.ai/entities", "favorite_city")
+func (client *Client) delete(resource string, id string) ([]byte, error) {
 	httpParams := &HTTPParams{
 		Resource: resource + "/" + id,
 		Verb:     "DELETE",
 	}
-	return processRequest(httpParams)
+	return client.processRequest(httpParams)
 }
 
 // Provides a common facility for doing a GET on a Wit resource
 //
-//		result, err := get("https://api.wit.ai/entities/favorite_city")
-func get(resource string) ([]byte, error) {
+//		result, err := client.get("https://api.wit.ai/entities/favorite_city")
+func (client *Client) get(resource string) ([]byte, error) {
 	httpParams := &HTTPParams{
 		Resource: resource,
 		Verb:     "GET",
 	}
-	return processRequest(httpParams)
+	return client.processRequest(httpParams)
 }
 
 // Provides a common facility for doing a POST on a Wit resource. Takes
 // JSON []byte for the data argument.
 //
-//		result, err := post("https://api.wit.ai/entities", entity)
-func post(resource string, data []byte) ([]byte, error) {
+//		result, err := client.post("https://api.wit.ai/entities", entity)
+func (client *Client) post(resource string, data []byte) ([]byte, error) {
 	httpParams := &HTTPParams{"POST", resource, "application/json", data}
-	return processRequest(httpParams)
+	return client.processRequest(httpParams)
 }
 
 // Provides a common facility for doing a POST with a file on a Wit resource.
 //
-//		result, err := postFile("https://api.wit.ai/messages", message)
-func postFile(resource string, request *MessageRequest) ([]byte, error) {
+//		result, err := client.postFile(ctx, "https://api.wit.ai/messages", message)
+func (client *Client) postFile(ctx context.Context, resource string, request *MessageRequest) ([]byte, error) {
 	if request.File != "" {
 		file, err := os.Open(request.File)
 		if err != nil {
@@ -96,12 +120,12 @@ func postFile(resource string, request *MessageRequest) ([]byte, error) {
 		data := make([]byte, size)
 		file.Read(data)
 		httpParams := &HTTPParams{"POST", resource, request.ContentType, data}
-		return processRequest(httpParams)
+		return client.processRequestContext(ctx, httpParams)
 	}
 
 	if request.FileContents != nil {
 		httpParams := &HTTPParams{"POST", resource, request.ContentType, request.FileContents}
-		return processRequest(httpParams)
+		return client.processRequestContext(ctx, httpParams)
 		// } else {
 		// return nil, errors.New("Must provide a filename or contents")
 	}
@@ -111,33 +135,61 @@ func postFile(resource string, request *MessageRequest) ([]byte, error) {
 
 // Provides a common facility for doing a PUT on a Wit resource.
 //
-//		result, err := put("https://api.wit.ai/entities", entity)
-func put(resource string, data []byte) ([]byte, error) {
+//		result, err := client.put("https://api.wit.ai/entities", entity)
+func (client *Client) put(resource string, data []byte) ([]byte, error) {
 	httpParams := &HTTPParams{"PUT", resource, "application/json", data}
-	return processRequest(httpParams)
+	return client.processRequest(httpParams)
 }
 
 // Processes an HTTP request to the Wit API
-func processRequest(httpParams *HTTPParams) ([]byte, error) {
+func (client *Client) processRequest(httpParams *HTTPParams) ([]byte, error) {
+	return client.processRequestContext(context.Background(), httpParams)
+}
+
+// Processes an HTTP request to the Wit API, until the context is done
+func (client *Client) processRequestContext(ctx context.Context, httpParams *HTTPParams) ([]byte, error) {
+	result, err := client.openRequest(ctx, httpParams.Verb, httpParams.Resource, httpParams.ContentType, bytes.NewReader(httpParams.Data))
+	if err != nil {
+		return nil, err
+	}
+	defer result.Body.Close()
+	return ioutil.ReadAll(result.Body)
+}
+
+// Makes an HTTP request to the Wit API and returns the response, with its
+// body left to read and close, when its status is 200
+//
+//		result, err := client.openRequest(ctx, "GET", client.APIBase+"/export", "", nil)
+func (client *Client) openRequest(ctx context.Context, verb string, resource string, contentType string, body io.Reader) (*http.Response, error) {
+	client.warnDeprecated(verb, resource)
 	regex := regexp.MustCompile(`\?`)
-	if regex.MatchString(httpParams.Resource) {
-		httpParams.Resource += "&" + APIVersion
+	version := APIVersion
+	if client.Version != "" {
+		version = "v=" + client.Version
+	}
+	if regex.MatchString(resource) {
+		resource += "&" + version
 	} else {
-		httpParams.Resource += "?" + APIVersion
+		resource += "?" + version
 	}
-	reader := bytes.NewReader(httpParams.Data)
-	httpClient := &http.Client{}
-	req, err := http.NewRequest(httpParams.Verb, httpParams.Resource, reader)
+	req, err := http.NewRequest(verb, resource, body)
 	if err != nil {
 		return nil, err
 	}
-	setHeaders(req, httpParams.ContentType)
+	req = req.WithContext(ctx)
+	client.setHeaders(req, contentType)
+
+	if client.RateLimiter != nil {
+		if err := client.RateLimiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
 
 	if os.Getenv("GOWIT_DEBUG") == "true" {
 		debug(httputil.DumpRequestOut(req, true))
 	}
 
-	result, err := httpClient.Do(req)
+	result, err := client.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -146,19 +198,39 @@ func processRequest(httpParams *HTTPParams) ([]byte, error) {
 		debug(httputil.DumpResponse(result, true))
 	}
 
-	body, err := ioutil.ReadAll(result.Body)
-	result.Body.Close()
 	if result.StatusCode != 200 {
+		io.Copy(ioutil.Discard, result.Body)
+		result.Body.Close()
 		return nil, errors.New(http.StatusText(result.StatusCode))
 	}
-	return body, nil
+	return result, nil
+}
+
+// Returns the HTTP client requests are made with
+func (client *Client) httpClient() *http.Client {
+	if client.HTTPClient != nil {
+		return client.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// Sends a request, through the endpoint pool when there is one
+func (client *Client) do(req *http.Request) (*http.Response, error) {
+	if client.Endpoints != nil {
+		return client.Endpoints.Do(client.httpClient(), req, client.APIBase)
+	}
+	return client.httpClient().Do(req)
 }
 
 // Sets the custom headers required for the Wit.ai API
 //
-//		setHeaders(req, httpParams.ContentType)
-func setHeaders(req *http.Request, contentType string) {
-	req.Header.Add("Authorization", "Bearer "+APIKey)
+//		client.setHeaders(req, httpParams.ContentType)
+func (client *Client) setHeaders(req *http.Request, contentType string) {
+	apiKey := client.APIKey
+	if apiKey == "" {
+		apiKey = APIKey
+	}
+	req.Header.Add("Authorization", "Bearer "+apiKey)
 	req.Header.Set("Content-Type", contentType)
 	req.Header.Set("Accept", "application/json")
 }
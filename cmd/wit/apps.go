@@ -0,0 +1,106 @@
+// Copyright (c) 2014 Jason Goecke
+// apps.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jsgoecke/go-wit"
+)
+
+// Runs the export command
+//
+//		wit export -profile production -o weather.zip
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := flags.String("o", "", "archive file to write")
+	clientFlags := addClientFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output == "" {
+		return errors.New("-o is required")
+	}
+	client, err := clientFlags.newClient()
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := client.ExportApp(context.Background(), file); err != nil {
+		file.Close()
+		os.Remove(*output)
+		return err
+	}
+	return file.Close()
+}
+
+// Runs the import command
+//
+//		wit import -name weather-staging -private weather.zip
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	name := flags.String("name", "", "name of the app to create")
+	private := flags.Bool("private", false, "make the app private")
+	clientFlags := addClientFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *name == "" || flags.NArg() != 1 {
+		return errors.New("usage: wit import -name <app> [-private] <archive.zip>")
+	}
+	client, err := clientFlags.newClient()
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	result, err := client.ImportApp(context.Background(), *name, file, &wit.ImportOptions{Private: *private})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created app %s\n", result.AppID)
+	return nil
+}
+
+// Runs the app-diff command
+//
+//		wit app-diff production.zip staging.zip
+func runAppDiff(args []string) error {
+	flags := flag.NewFlagSet("app-diff", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errors.New("usage: wit app-diff <old.zip> <new.zip>")
+	}
+	old, err := wit.ReadAppArchive(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	new, err := wit.ReadAppArchive(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	diff := wit.DiffAppArchives(old, new)
+	data, err := json.MarshalIndent(diff, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	if !diff.Empty() {
+		os.Exit(1)
+	}
+	return nil
+}
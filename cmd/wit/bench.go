@@ -0,0 +1,118 @@
+// Copyright (c) 2014 Jason Goecke
+// bench.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"github.com/jsgoecke/go-wit"
+)
+
+// Runs the bench command
+//
+//		wit bench -corpus ./corpus.txt -rate 50 -duration 1m
+//		wit bench -profile staging -corpus ./corpus.txt -rate 50 -duration 1m
+//		wit bench -corpus ./corpus.txt -concurrency 20 -requests 1000 -fake -fake-latency 50ms
+func runBench(args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	corpusPath := flags.String("corpus", "", "corpus file, one text or JSON request per line")
+	rate := flags.Float64("rate", 0, "target requests per second, open loop (0 for closed loop)")
+	concurrency := flags.Int("concurrency", 0, "requests in flight, workers when there is no rate")
+	duration := flags.Duration("duration", 30*time.Second, "how long to run for")
+	requests := flags.Int("requests", 0, "stop after this many requests")
+	clientFlags := addClientFlags(flags)
+	fake := flags.Bool("fake", false, "run against a local fake Wit server to profile the pipeline only")
+	fakeLatency := flags.Duration("fake-latency", 0, "latency added by the fake server")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *corpusPath == "" {
+		return errors.New("-corpus is required")
+	}
+	corpus, err := wit.LoadBenchCorpus(*corpusPath)
+	if err != nil {
+		return err
+	}
+
+	var client *wit.Client
+	if *fake {
+		server, err := wit.StartFakeServer("127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		defer server.Close()
+		server.Latency = *fakeLatency
+		client = wit.NewClient("fake-token")
+		client.APIBase = server.URL
+	} else if client, err = clientFlags.newClient(); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	bench := &wit.Bench{
+		Client:      client,
+		Corpus:      corpus,
+		Rate:        *rate,
+		Concurrency: *concurrency,
+		Requests:    *requests,
+	}
+	if *requests == 0 {
+		bench.Duration = *duration
+	}
+	report, err := bench.Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	printBenchReport(report)
+	return nil
+}
+
+// Prints a human readable benchmark report
+func printBenchReport(report *wit.BenchReport) {
+	fmt.Printf("requests      %d sent, %d succeeded, %d failed in %s\n",
+		report.Sent, report.Succeeded, report.Failed, report.Elapsed.Round(time.Millisecond))
+	fmt.Printf("rate          %.2f/s achieved, %.2f/s successful\n", report.AchievedRate, report.Throughput)
+	latency := report.Latency
+	fmt.Printf("latency       min %s  mean %s  max %s\n", latency.Min, latency.Mean, latency.Max)
+	fmt.Printf("percentiles   p50 %s  p90 %s  p95 %s  p99 %s  p99.9 %s\n",
+		latency.P50, latency.P90, latency.P95, latency.P99, latency.P999)
+	if len(report.Slowest) > 0 {
+		fmt.Println("slowest")
+		for _, sample := range report.Slowest {
+			fmt.Printf("  %10s  msg_id %s\n", sample.Latency.Round(time.Microsecond), sample.MsgID)
+		}
+	}
+	if len(report.Errors) == 0 {
+		return
+	}
+	var messages []string
+	for message := range report.Errors {
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool { return report.Errors[messages[i]] > report.Errors[messages[j]] })
+	fmt.Println("errors")
+	for _, message := range messages {
+		fmt.Printf("  %6d  %s\n", report.Errors[message], message)
+	}
+	fmt.Println("first failures")
+	for _, sample := range report.Failures {
+		fmt.Printf("  msg_id %s  %s\n", sample.MsgID, sample.Error)
+	}
+}
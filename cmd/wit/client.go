@@ -0,0 +1,55 @@
+// Copyright (c) 2014 Jason Goecke
+// client.go
+
+package main
+
+import (
+	"flag"
+
+	"github.com/jsgoecke/go-wit"
+)
+
+// clientFlags are the flags selecting how a command connects to Wit
+type clientFlags struct {
+	config  *string
+	profile *string
+	token   *string
+}
+
+// Registers the client flags on a command's flag set
+func addClientFlags(flags *flag.FlagSet) *clientFlags {
+	return &clientFlags{
+		config:  flags.String("config", "", "configuration file (default $WIT_CONFIG or ~/.config/wit/config.yaml)"),
+		profile: flags.String("profile", "", "configuration profile (default $WIT_PROFILE or the configured default)"),
+		token:   flags.String("token", "", "Wit access token, overrides the profile's"),
+	}
+}
+
+// Creates a client from the flags. A token alone overrides any
+// configuration, with a profile it overrides the profile's token only.
+func (c *clientFlags) newClient() (*wit.Client, error) {
+	if *c.token != "" && *c.config == "" && *c.profile == "" {
+		return wit.NewClient(*c.token), nil
+	}
+	if *c.token == "" && *c.config == "" {
+		return wit.NewClientFromProfile(*c.profile)
+	}
+	path := *c.config
+	if path == "" {
+		path = wit.DefaultConfigPath()
+	}
+	config, err := wit.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := config.Profile(*c.profile)
+	if err != nil {
+		return nil, err
+	}
+	if *c.token != "" {
+		override := *profile
+		override.Token = *c.token
+		profile = &override
+	}
+	return profile.NewClient()
+}
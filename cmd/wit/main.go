@@ -0,0 +1,54 @@
+// Copyright (c) 2014 Jason Goecke
+// main.go
+
+// Command wit provides tooling around the Wit API.
+//
+//		wit bench -corpus ./corpus.txt -rate 50 -duration 1m
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// command is a wit subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"bench", "replay a corpus of requests and report latency and throughput", runBench},
+	{"export", "download the app as a zip archive", runExport},
+	{"import", "create an app from a zip archive", runImport},
+	{"app-diff", "compare the entities, intents and samples of two app archives", runAppDiff},
+	{"plugin-check", "check a handler plugin against the stdin/stdout protocol", runPluginCheck},
+	{"proxy", "serve the Wit API with token mapping, caching, quotas and an audit log", runProxy},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "wit %s: %s\n", cmd.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: wit <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", cmd.name, cmd.summary)
+	}
+}
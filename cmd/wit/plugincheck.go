@@ -0,0 +1,49 @@
+// Copyright (c) 2014 Jason Goecke
+// plugincheck.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/jsgoecke/go-wit"
+)
+
+// Runs the plugin-check command
+//
+//		wit plugin-check -- python3 handlers/billing.py
+func runPluginCheck(args []string) error {
+	flags := flag.NewFlagSet("plugin-check", flag.ContinueOnError)
+	timeout := flags.Duration("timeout", wit.DefaultPluginTimeout, "time the plugin has to answer each request")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("usage: wit plugin-check [-timeout 5s] <command> [args...]")
+	}
+	plugin := wit.NewPlugin(flags.Arg(0), flags.Args()[1:]...)
+	plugin.Timeout = *timeout
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*(*timeout)+10*time.Second)
+	defer cancel()
+	conformance := wit.CheckPlugin(ctx, plugin)
+	for _, check := range conformance.Checks {
+		status := "ok  "
+		if !check.Passed {
+			status = "FAIL"
+		}
+		fmt.Printf("%s  %s", status, check.Name)
+		if check.Error != "" {
+			fmt.Printf(": %s", check.Error)
+		}
+		fmt.Println()
+	}
+	if !conformance.Passed {
+		return errors.New("plugin does not conform to the protocol")
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2014 Jason Goecke
+// proxy.go
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/jsgoecke/go-wit"
+)
+
+// Runs the proxy command
+//
+//		wit proxy -listen :8080 -proxy-config ./proxy.yaml -profile production
+func runProxy(args []string) error {
+	flags := flag.NewFlagSet("proxy", flag.ContinueOnError)
+	listen := flags.String("listen", ":8080", "address to listen on")
+	proxyConfig := flags.String("proxy-config", "", "proxy configuration file (tokens, quotas, cache, audit log)")
+	clientFlags := addClientFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	client, err := clientFlags.newClient()
+	if err != nil {
+		return err
+	}
+	proxy := wit.NewProxy(client, nil)
+	if *proxyConfig != "" {
+		if proxy, err = wit.LoadProxy(*proxyConfig, client); err != nil {
+			return err
+		}
+	} else {
+		proxy.AuditLog = os.Stdout
+	}
+	fmt.Fprintf(os.Stderr, "proxying %s on %s\n", apiBase(client), *listen)
+	return http.ListenAndServe(*listen, proxy)
+}
+
+func apiBase(client *wit.Client) string {
+	if client.APIBase == "" {
+		return "https://api.wit.ai"
+	}
+	return client.APIBase
+}
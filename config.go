@@ -0,0 +1,259 @@
+// Copyright (c) 2014 Jason Goecke
+// config.go
+
+package wit
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Environment variables overriding the configuration
+const (
+	// ConfigEnv names the configuration file to load instead of the default
+	ConfigEnv = "WIT_CONFIG"
+	// ProfileEnv names the profile to use when none is given
+	ProfileEnv = "WIT_PROFILE"
+	// TokenEnv holds an access token, used when there is no configuration
+	TokenEnv = "WIT_ACCESS_TOKEN"
+)
+
+// DefaultProfileName is the profile used when none is given or configured
+const DefaultProfileName = "default"
+
+// Config represents a configuration file holding named profiles, e.g.
+//
+//		default_profile: dev
+//		profiles:
+//		  dev:
+//		    token_env: WIT_DEV_TOKEN
+//		    timezone: America/Los_Angeles
+//		  prod-fr:
+//		    token_file: ~/.config/wit/prod-fr.token
+//		    api_version: "20160516"
+//		    app_version: v12
+//		    locale: fr_FR
+//		    rate_limit:
+//		      requests_per_second: 10
+//		      burst: 20
+type Config struct {
+	DefaultProfile string              `json:"default_profile,omitempty"`
+	Profiles       map[string]*Profile `json:"profiles"`
+}
+
+// Profile represents the settings of a Client for one app and environment.
+// The token is read from Token, else the TokenEnv environment variable,
+// else the TokenFile. Endpoints, when set, are base URLs requests fail
+// over between, see EndpointPool. TLS pins keys and sets root CAs, see
+// TLSOptions.
+type Profile struct {
+	Token      string     `json:"token,omitempty"`
+	TokenEnv   string     `json:"token_env,omitempty"`
+	TokenFile  string     `json:"token_file,omitempty"`
+	BaseURL    string     `json:"base_url,omitempty"`
+	APIVersion string     `json:"api_version,omitempty"`
+	AppVersion string     `json:"app_version,omitempty"`
+	Timezone   string     `json:"timezone,omitempty"`
+	Locale     string     `json:"locale,omitempty"`
+	RateLimit  *RateLimit `json:"rate_limit,omitempty"`
+	Endpoints  []Endpoint `json:"endpoints,omitempty"`
+	// EndpointStrategy is ordered (the default) or weighted
+	EndpointStrategy string      `json:"endpoint_strategy,omitempty"`
+	TLS              *TLSOptions `json:"tls,omitempty"`
+}
+
+// RateLimit represents the rate a profile's requests are limited to
+type RateLimit struct {
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	Burst             int     `json:"burst,omitempty"`
+}
+
+// DefaultConfigPath returns the path of the configuration file: WIT_CONFIG
+// when set, else wit/config.yaml in the user's configuration directory
+// ($XDG_CONFIG_HOME or ~/.config)
+//
+//		path := wit.DefaultConfigPath()
+func DefaultConfigPath() string {
+	if path := os.Getenv(ConfigEnv); path != "" {
+		return path
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return filepath.Join(".config", "wit", "config.yaml")
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "wit", "config.yaml")
+}
+
+// LoadConfig reads a YAML configuration file
+//
+//		config, err := wit.LoadConfig(wit.DefaultConfigPath())
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(expandHome(path))
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
+}
+
+// ParseConfig parses a YAML configuration
+//
+//		config, err := wit.ParseConfig(data)
+func ParseConfig(data []byte) (*Config, error) {
+	config := &Config{}
+	if err := unmarshalYAML(data, config); err != nil {
+		return nil, err
+	}
+	for name, profile := range config.Profiles {
+		if profile == nil {
+			config.Profiles[name] = &Profile{}
+		}
+	}
+	if config.DefaultProfile != "" && config.Profiles[config.DefaultProfile] == nil {
+		return nil, fmt.Errorf("default profile %q is not defined", config.DefaultProfile)
+	}
+	return config, nil
+}
+
+// Profile returns the named profile. Without a name, the profile named by
+// WIT_PROFILE is used, else the configured default profile, else "default".
+//
+//		profile, err := config.Profile("prod-fr")
+func (config *Config) Profile(name string) (*Profile, error) {
+	if name == "" {
+		name = os.Getenv(ProfileEnv)
+	}
+	if name == "" {
+		name = config.DefaultProfile
+	}
+	if name == "" {
+		name = DefaultProfileName
+	}
+	profile, ok := config.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %q not found, available profiles: %s", name, strings.Join(config.ProfileNames(), ", "))
+	}
+	return profile, nil
+}
+
+// ProfileNames returns the names of the configured profiles, sorted
+//
+//		names := config.ProfileNames()
+func (config *Config) ProfileNames() []string {
+	var names []string
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ResolveToken returns the profile's access token
+//
+//		token, err := profile.ResolveToken()
+func (profile *Profile) ResolveToken() (string, error) {
+	if profile.Token != "" {
+		return profile.Token, nil
+	}
+	if profile.TokenEnv != "" {
+		if token := os.Getenv(profile.TokenEnv); token != "" {
+			return token, nil
+		}
+		if profile.TokenFile == "" {
+			return "", fmt.Errorf("environment variable %s is not set", profile.TokenEnv)
+		}
+	}
+	if profile.TokenFile != "" {
+		data, err := ioutil.ReadFile(expandHome(profile.TokenFile))
+		if err != nil {
+			return "", err
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return "", fmt.Errorf("token file %s is empty", profile.TokenFile)
+		}
+		return token, nil
+	}
+	return "", errors.New("profile has no token, token_env or token_file")
+}
+
+// NewClient creates a client configured by the profile
+//
+//		client, err := profile.NewClient()
+func (profile *Profile) NewClient() (*Client, error) {
+	token, err := profile.ResolveToken()
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{
+		APIBase:    "https://api.wit.ai",
+		APIKey:     token,
+		Version:    strings.TrimPrefix(profile.APIVersion, "v="),
+		AppVersion: profile.AppVersion,
+		Timezone:   profile.Timezone,
+		Locale:     profile.Locale,
+	}
+	if profile.BaseURL != "" {
+		client.APIBase = strings.TrimSuffix(profile.BaseURL, "/")
+	}
+	if profile.RateLimit != nil && profile.RateLimit.RequestsPerSecond > 0 {
+		client.RateLimiter = NewRateLimiter(profile.RateLimit.RequestsPerSecond, profile.RateLimit.Burst)
+	}
+	if profile.TLS != nil {
+		if client.HTTPClient, err = profile.TLS.HTTPClient(); err != nil {
+			return nil, err
+		}
+	}
+	if len(profile.Endpoints) > 0 {
+		if client.Endpoints, err = NewEndpointPool(profile.EndpointStrategy, profile.Endpoints...); err != nil {
+			return nil, err
+		}
+		client.Endpoints.HTTPClient = client.HTTPClient
+	}
+	return client, nil
+}
+
+// NewClientFromProfile creates a client from a profile of the default
+// configuration file, see Config.Profile for how the profile is chosen.
+// Without a configuration file and an explicit profile, the client uses
+// the WIT_ACCESS_TOKEN environment variable.
+//
+//		client, err := wit.NewClientFromProfile("staging")
+func NewClientFromProfile(name string) (*Client, error) {
+	path := DefaultConfigPath()
+	config, err := LoadConfig(path)
+	if os.IsNotExist(err) && name == "" && os.Getenv(ProfileEnv) == "" && os.Getenv(ConfigEnv) == "" {
+		if token := os.Getenv(TokenEnv); token != "" {
+			return &Client{APIBase: "https://api.wit.ai", APIKey: token}, nil
+		}
+		return nil, fmt.Errorf("no configuration at %s and %s is not set", path, TokenEnv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	profile, err := config.Profile(name)
+	if err != nil {
+		return nil, err
+	}
+	return profile.NewClient()
+}
+
+// Expands a leading ~ to the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2014 Jason Goecke
+// config_test.go
+
+package wit
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+default_profile: dev
+profiles:
+  dev:
+    token: dev-token
+    timezone: America/Los_Angeles
+    locale: en_US
+  prod-fr:
+    token_env: WIT_TEST_PROD_FR_TOKEN
+    base_url: %s
+    api_version: 20160516
+    app_version: v12
+    locale: fr_FR
+    rate_limit:
+      requests_per_second: 100
+      burst: 5
+  file:
+    token_file: %s
+`
+
+func writeTestConfig(t *testing.T, baseURL string) (string, func()) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	config := strings.Replace(strings.Replace(testConfig, "%s", baseURL, 1), "%s", tokenFile, 1)
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadConfig(t *testing.T) {
+	path, cleanup := writeTestConfig(t, "https://staging.example.com/")
+	defer cleanup()
+	os.Unsetenv(ProfileEnv)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := strings.Join(config.ProfileNames(), ","); names != "dev,file,prod-fr" {
+		t.Errorf("unexpected profiles %s", names)
+	}
+
+	profile, err := config.Profile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := profile.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.APIKey != "dev-token" || client.APIBase != "https://api.wit.ai" || client.Timezone != "America/Los_Angeles" {
+		t.Errorf("default profile not applied %+v", client)
+	}
+
+	os.Setenv(ProfileEnv, "file")
+	defer os.Unsetenv(ProfileEnv)
+	if profile, err = config.Profile(""); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := profile.ResolveToken(); err != nil || token != "file-token" {
+		t.Errorf("token file not read, got %q %v", token, err)
+	}
+
+	if profile, err = config.Profile("prod-fr"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := profile.NewClient(); err == nil {
+		t.Error("expected an error with the token variable unset")
+	}
+	os.Setenv("WIT_TEST_PROD_FR_TOKEN", "prod-token")
+	defer os.Unsetenv("WIT_TEST_PROD_FR_TOKEN")
+	if client, err = profile.NewClient(); err != nil {
+		t.Fatal(err)
+	}
+	if client.APIKey != "prod-token" || client.APIBase != "https://staging.example.com" || client.Version != "20160516" ||
+		client.AppVersion != "v12" || client.RateLimiter == nil {
+		t.Errorf("profile not applied %+v", client)
+	}
+
+	if _, err := config.Profile("missing"); err == nil {
+		t.Error("expected an error for a missing profile")
+	}
+	if _, err := ParseConfig([]byte("default_profile: nope\nprofiles:\n  dev:\n    token: x\n")); err == nil {
+		t.Error("expected an error for an undefined default profile")
+	}
+}
+
+func TestNewClientFromProfile(t *testing.T) {
+	var request *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = r
+		w.Write([]byte(`{"msg_id": "1", "_text": "bonjour", "outcomes": []}`))
+	}))
+	defer server.Close()
+	path, cleanup := writeTestConfig(t, server.URL)
+	defer cleanup()
+	os.Setenv(ConfigEnv, path)
+	defer os.Unsetenv(ConfigEnv)
+	os.Setenv("WIT_TEST_PROD_FR_TOKEN", "prod-token")
+	defer os.Unsetenv("WIT_TEST_PROD_FR_TOKEN")
+
+	client, err := NewClientFromProfile("prod-fr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Message(&MessageRequest{Query: "bonjour"}); err != nil {
+		t.Fatal(err)
+	}
+	query := request.URL.Query()
+	if request.Header.Get("Authorization") != "Bearer prod-token" || query.Get("v") != "20160516" ||
+		query.Get("tag") != "v12" || query.Get("context") != `{"locale":"fr_FR"}` {
+		t.Errorf("request not configured by the profile %s %v", request.URL, request.Header)
+	}
+	if _, err := client.AudioMessage(&MessageRequest{FileContents: []byte("audio"), ContentType: "audio/wav"}); err != nil {
+		t.Fatal(err)
+	}
+	if request.URL.Path != "/speech" || request.URL.Query().Get("context") != `{"locale":"fr_FR"}` {
+		t.Errorf("speech request not configured by the profile %s", request.URL)
+	}
+}
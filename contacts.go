@@ -0,0 +1,365 @@
+// Copyright (c) 2014 Jason Goecke
+// contacts.go
+
+package wit
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ValidationError represents a contact entity that could not be normalized
+type ValidationError struct {
+	Value  string
+	Reason string
+}
+
+func (err *ValidationError) Error() string {
+	return "invalid value " + strconv.Quote(err.Value) + ": " + err.Reason
+}
+
+// NumberingPlan describes the telephone numbering plan of a region
+type NumberingPlan struct {
+	Region              string
+	CallingCode         string
+	NationalPrefix      string
+	InternationalPrefix string
+	MinLength           int
+	MaxLength           int
+}
+
+// NumberingPlans is the bundled table of numbering plans, keyed by ISO 3166
+// region code. National number lengths are the lengths of the national
+// significant number, without the national prefix.
+var NumberingPlans = map[string]NumberingPlan{
+	"AE": {"AE", "971", "0", "00", 8, 9},
+	"AR": {"AR", "54", "0", "00", 10, 11},
+	"AT": {"AT", "43", "0", "00", 4, 13},
+	"AU": {"AU", "61", "0", "0011", 9, 9},
+	"BE": {"BE", "32", "0", "00", 8, 9},
+	"BR": {"BR", "55", "0", "00", 10, 11},
+	"CA": {"CA", "1", "1", "011", 10, 10},
+	"CH": {"CH", "41", "0", "00", 9, 9},
+	"CN": {"CN", "86", "0", "00", 7, 11},
+	"DE": {"DE", "49", "0", "00", 6, 13},
+	"DK": {"DK", "45", "", "00", 8, 8},
+	"ES": {"ES", "34", "", "00", 9, 9},
+	"FI": {"FI", "358", "0", "00", 5, 12},
+	"FR": {"FR", "33", "0", "00", 9, 9},
+	"GB": {"GB", "44", "0", "00", 9, 10},
+	"HK": {"HK", "852", "", "001", 8, 8},
+	"IE": {"IE", "353", "0", "00", 7, 9},
+	"IL": {"IL", "972", "0", "00", 8, 9},
+	"IN": {"IN", "91", "0", "00", 10, 10},
+	"IT": {"IT", "39", "", "00", 6, 11},
+	"JP": {"JP", "81", "0", "010", 9, 10},
+	"KR": {"KR", "82", "0", "001", 8, 10},
+	"MX": {"MX", "52", "", "00", 10, 10},
+	"NL": {"NL", "31", "0", "00", 9, 9},
+	"NO": {"NO", "47", "", "00", 8, 8},
+	"NZ": {"NZ", "64", "0", "00", 8, 10},
+	"PL": {"PL", "48", "", "00", 9, 9},
+	"PT": {"PT", "351", "", "00", 9, 9},
+	"RU": {"RU", "7", "8", "810", 10, 10},
+	"SE": {"SE", "46", "0", "00", 7, 10},
+	"SG": {"SG", "65", "", "000", 8, 8},
+	"US": {"US", "1", "1", "011", 10, 10},
+	"ZA": {"ZA", "27", "0", "00", 9, 9},
+}
+
+// The region used for a calling code shared by several regions
+var primaryRegions = map[string]string{"1": "US", "7": "RU"}
+
+// PhoneNumber represents a normalized phone_number entity
+type PhoneNumber struct {
+	Entity         MessageEntity `json:"entity"`
+	E164           string        `json:"e164,omitempty"`
+	Region         string        `json:"region,omitempty"`
+	CallingCode    string        `json:"calling_code,omitempty"`
+	NationalNumber string        `json:"national_number,omitempty"`
+	Extension      string        `json:"extension,omitempty"`
+	Err            error         `json:"-"`
+}
+
+// Email represents a normalized email entity
+type Email struct {
+	Entity  MessageEntity `json:"entity"`
+	Address string        `json:"address,omitempty"`
+	ASCII   string        `json:"ascii,omitempty"`
+	Local   string        `json:"local,omitempty"`
+	Domain  string        `json:"domain,omitempty"`
+	Err     error         `json:"-"`
+}
+
+// URL represents a normalized url entity
+type URL struct {
+	Entity    MessageEntity `json:"entity"`
+	Canonical string        `json:"canonical,omitempty"`
+	Scheme    string        `json:"scheme,omitempty"`
+	Host      string        `json:"host,omitempty"`
+	Err       error         `json:"-"`
+}
+
+var (
+	phoneExtensionRegexp = regexp.MustCompile(`(?i)\s*(?:ext\.?|extension|x|#)\s*(\d{1,6})\s*$`)
+	phoneCharsRegexp     = regexp.MustCompile(`^[\d\s+()./-]+$`)
+	emailAtextRegexp     = regexp.MustCompile("^[\\pL\\pN!#$%&'*+/=?^_`{|}~-]+$")
+	domainLabelRegexp    = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?$`)
+	urlSchemeRegexp      = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9+.-]*):[^0-9]`)
+)
+
+// NormalizePhoneNumber normalizes a phone_number entity to E.164. Numbers
+// without an international prefix are read in the default region.
+//
+//		phone := wit.NormalizePhoneNumber(entity, "US")
+//		if phone.Err == nil {
+//			log.Println(phone.E164)
+//		}
+func NormalizePhoneNumber(entity MessageEntity, defaultRegion string) *PhoneNumber {
+	phone := &PhoneNumber{Entity: entity}
+	raw := entityText(entity)
+	invalid := func(reason string) *PhoneNumber {
+		phone.Err = &ValidationError{raw, reason}
+		return phone
+	}
+
+	text := strings.TrimSpace(raw)
+	if m := phoneExtensionRegexp.FindStringSubmatchIndex(text); m != nil {
+		phone.Extension = text[m[2]:m[3]]
+		text = text[:m[0]]
+	}
+	if text == "" || !phoneCharsRegexp.MatchString(text) {
+		return invalid("not a phone number")
+	}
+	international := strings.HasPrefix(text, "+")
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, text)
+
+	plan, ok := NumberingPlans[strings.ToUpper(defaultRegion)]
+	if !international {
+		if !ok {
+			return invalid("unknown region " + strconv.Quote(defaultRegion))
+		}
+		if strings.HasPrefix(digits, plan.InternationalPrefix) {
+			international = true
+			digits = digits[len(plan.InternationalPrefix):]
+		}
+	}
+
+	if international {
+		found := false
+		for n := 1; n <= 3 && n <= len(digits); n++ {
+			if region, ok := regionForCallingCode(digits[:n], defaultRegion); ok {
+				plan, found = NumberingPlans[region], true
+				digits = digits[n:]
+				break
+			}
+		}
+		if !found {
+			return invalid("unknown country calling code")
+		}
+	} else if plan.NationalPrefix != "" && strings.HasPrefix(digits, plan.NationalPrefix) &&
+		len(digits)-len(plan.NationalPrefix) >= plan.MinLength {
+		digits = digits[len(plan.NationalPrefix):]
+	}
+
+	switch {
+	case len(digits) < plan.MinLength:
+		return invalid("too short for " + plan.Region)
+	case len(digits) > plan.MaxLength || len(plan.CallingCode)+len(digits) > 15:
+		return invalid("too long for " + plan.Region)
+	}
+	phone.Region = plan.Region
+	phone.CallingCode = plan.CallingCode
+	phone.NationalNumber = digits
+	phone.E164 = "+" + plan.CallingCode + digits
+	return phone
+}
+
+// Returns the region for a calling code, preferring the default region when
+// it shares the calling code
+func regionForCallingCode(code string, defaultRegion string) (string, bool) {
+	if plan, ok := NumberingPlans[strings.ToUpper(defaultRegion)]; ok && plan.CallingCode == code {
+		return plan.Region, true
+	}
+	if region, ok := primaryRegions[code]; ok {
+		return region, true
+	}
+	for region, plan := range NumberingPlans {
+		if plan.CallingCode == code {
+			return region, true
+		}
+	}
+	return "", false
+}
+
+// NormalizeEmail validates an email entity and normalizes its case. The
+// domain is lower-cased and also provided in its ASCII (punycode) form, the
+// local part is left as typed since it may be case sensitive.
+//
+//		email := wit.NormalizeEmail(entity)
+func NormalizeEmail(entity MessageEntity) *Email {
+	email := &Email{Entity: entity}
+	raw := entityText(entity)
+	invalid := func(reason string) *Email {
+		email.Err = &ValidationError{raw, reason}
+		return email
+	}
+
+	text := strings.TrimSpace(raw)
+	text = strings.TrimPrefix(text, "mailto:")
+	at := strings.LastIndex(text, "@")
+	if at <= 0 || at == len(text)-1 {
+		return invalid("missing local part or domain")
+	}
+	local, domain := text[:at], strings.TrimSuffix(strings.ToLower(text[at+1:]), ".")
+	if len(local) > 64 {
+		return invalid("local part longer than 64 characters")
+	}
+	for _, atom := range strings.Split(local, ".") {
+		if !emailAtextRegexp.MatchString(atom) {
+			return invalid("invalid local part")
+		}
+	}
+
+	unicodeDomain, err := domainToUnicode(domain)
+	if err != nil {
+		return invalid("invalid domain")
+	}
+	asciiDomain, err := validDomain(unicodeDomain)
+	if err != nil {
+		return invalid(err.Error())
+	}
+	if !strings.Contains(asciiDomain, ".") {
+		return invalid("domain is not fully qualified")
+	}
+	if len(local)+1+len(asciiDomain) > 254 {
+		return invalid("address longer than 254 characters")
+	}
+
+	email.Local = local
+	email.Domain = unicodeDomain
+	email.Address = local + "@" + unicodeDomain
+	if isASCII(local) {
+		email.ASCII = local + "@" + asciiDomain
+	}
+	return email
+}
+
+// NormalizeURL canonicalizes a url entity: the scheme and host are
+// lower-cased, internationalized hosts are punycode encoded, default ports,
+// fragments and dot segments are removed, and a missing scheme defaults to
+// http
+//
+//		link := wit.NormalizeURL(entity)
+func NormalizeURL(entity MessageEntity) *URL {
+	link := &URL{Entity: entity}
+	raw := entityText(entity)
+	invalid := func(reason string) *URL {
+		link.Err = &ValidationError{raw, reason}
+		return link
+	}
+
+	text := strings.TrimSpace(raw)
+	if m := urlSchemeRegexp.FindStringSubmatch(text); m != nil && !strings.Contains(text, "://") {
+		return invalid("unsupported scheme " + strconv.Quote(strings.ToLower(m[1])))
+	}
+	if !strings.Contains(text, "://") {
+		text = "http://" + text
+	}
+	u, err := url.Parse(text)
+	if err != nil {
+		return invalid("malformed url")
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "ftp" {
+		return invalid("unsupported scheme " + strconv.Quote(u.Scheme))
+	}
+
+	host, port := u.Hostname(), u.Port()
+	if host == "" {
+		return invalid("missing host")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+		if ip.To4() == nil {
+			host = "[" + host + "]"
+		}
+	} else {
+		host, err = validDomain(strings.TrimSuffix(host, "."))
+		if err != nil {
+			return invalid(err.Error())
+		}
+		if !strings.Contains(host, ".") && host != "localhost" {
+			return invalid("host is not fully qualified")
+		}
+	}
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") ||
+		(u.Scheme == "ftp" && port == "21") {
+		port = ""
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+
+	if u.Path == "" {
+		u.Path = "/"
+	} else {
+		cleaned := path.Clean(u.Path)
+		if strings.HasSuffix(u.Path, "/") && cleaned != "/" {
+			cleaned += "/"
+		}
+		u.Path = cleaned
+		u.RawPath = ""
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.ForceQuery = false
+
+	link.Scheme = u.Scheme
+	link.Host = u.Host
+	link.Canonical = u.String()
+	return link
+}
+
+// Validates a Unicode or ASCII domain name, returning its ASCII form or the
+// reason it is invalid
+func validDomain(domain string) (string, error) {
+	ascii, err := domainToASCII(domain)
+	if err != nil {
+		return "", errors.New("invalid domain")
+	}
+	if len(ascii) > 253 {
+		return "", errors.New("domain longer than 253 characters")
+	}
+	for _, label := range strings.Split(ascii, ".") {
+		if !domainLabelRegexp.MatchString(label) {
+			return "", errors.New("invalid domain label " + strconv.Quote(label))
+		}
+	}
+	return ascii, nil
+}
+
+// Returns the text of an entity, its value when it is a string and its body
+// otherwise
+func entityText(entity MessageEntity) string {
+	if entity.Value != nil {
+		if value, ok := (*entity.Value).(string); ok {
+			return value
+		}
+	}
+	if entity.Body != nil {
+		return *entity.Body
+	}
+	return ""
+}
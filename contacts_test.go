@@ -0,0 +1,134 @@
+// Copyright (c) 2014 Jason Goecke
+// contacts_test.go
+
+package wit
+
+import (
+	"testing"
+)
+
+func contactEntity(value string) MessageEntity {
+	var v interface{} = value
+	return MessageEntity{Value: &v}
+}
+
+func TestNormalizePhoneNumber(t *testing.T) {
+	tests := []struct {
+		value     string
+		region    string
+		e164      string
+		extension string
+	}{
+		{"(415) 555-2671", "US", "+14155552671", ""},
+		{"1-415-555-2671", "US", "+14155552671", ""},
+		{"+1 415.555.2671", "GB", "+14155552671", ""},
+		{"020 7946 0958", "GB", "+442079460958", ""},
+		{"0033 1 23 45 67 89", "GB", "+33123456789", ""},
+		{"011 33 1 23 45 67 89", "US", "+33123456789", ""},
+		{"+33 (0)1 23 45 67 89", "US", "", ""},
+		{"06 1234 5678", "IT", "+390612345678", ""},
+		{"415-555-2671 ext. 42", "US", "+14155552671", "42"},
+		{"555-2671", "US", "", ""},
+		{"+999 1234567", "US", "", ""},
+		{"call me maybe", "US", "", ""},
+	}
+	for _, test := range tests {
+		phone := NormalizePhoneNumber(contactEntity(test.value), test.region)
+		if phone.E164 != test.e164 || phone.Extension != test.extension {
+			t.Errorf("%q: not equal %q != %q (%v)", test.value, test.e164, phone.E164, phone.Err)
+		}
+		if (test.e164 == "") != (phone.Err != nil) {
+			t.Errorf("%q: unexpected error %v", test.value, phone.Err)
+		}
+		if *phone.Entity.Value != test.value {
+			t.Errorf("%q: original entity not kept", test.value)
+		}
+	}
+
+	phone := NormalizePhoneNumber(contactEntity("020 7946 0958"), "GB")
+	if phone.Region != "GB" || phone.CallingCode != "44" || phone.NationalNumber != "2079460958" {
+		t.Errorf("phone number not parsed properly %+v", phone)
+	}
+	if _, ok := NormalizePhoneNumber(contactEntity("555 2671"), "XX").Err.(*ValidationError); !ok {
+		t.Error("expected a ValidationError for an unknown region")
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		value   string
+		address string
+		ascii   string
+	}{
+		{"Jason@Example.COM", "Jason@example.com", "Jason@example.com"},
+		{"mailto:first.last+tag@example.co.uk", "first.last+tag@example.co.uk", "first.last+tag@example.co.uk"},
+		{"user@Bücher.example", "user@bücher.example", "user@xn--bcher-kva.example"},
+		{"user@xn--bcher-kva.example", "user@bücher.example", "user@xn--bcher-kva.example"},
+		{"josé@example.com", "josé@example.com", ""},
+		{"no-at-sign.example.com", "", ""},
+		{"two..dots@example.com", "", ""},
+		{"user@localhost", "", ""},
+		{"user@-bad-.com", "", ""},
+	}
+	for _, test := range tests {
+		email := NormalizeEmail(contactEntity(test.value))
+		if email.Address != test.address || email.ASCII != test.ascii {
+			t.Errorf("%q: not equal %q %q != %q %q (%v)", test.value, test.address, test.ascii, email.Address, email.ASCII, email.Err)
+		}
+		if (test.address == "") != (email.Err != nil) {
+			t.Errorf("%q: unexpected error %v", test.value, email.Err)
+		}
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		value     string
+		canonical string
+	}{
+		{"HTTP://Example.COM:80/a/./b/../c?q=1#top", "http://example.com/a/c?q=1"},
+		{"example.com", "http://example.com/"},
+		{"https://example.com:8443/docs/", "https://example.com:8443/docs/"},
+		{"https://bücher.example/", "https://xn--bcher-kva.example/"},
+		{"http://127.0.0.1:8080", "http://127.0.0.1:8080/"},
+		{"http://localhost:3000/x", "http://localhost:3000/x"},
+		{"mailto:someone@example.com", ""},
+		{"http://", ""},
+		{"not a url", ""},
+	}
+	for _, test := range tests {
+		link := NormalizeURL(contactEntity(test.value))
+		if link.Canonical != test.canonical {
+			t.Errorf("%q: not equal %q != %q (%v)", test.value, test.canonical, link.Canonical, link.Err)
+		}
+		if (test.canonical == "") != (link.Err != nil) {
+			t.Errorf("%q: unexpected error %v", test.value, link.Err)
+		}
+	}
+}
+
+func TestPunycode(t *testing.T) {
+	// Samples from https://tools.ietf.org/html/rfc3492#section-7.1
+	tests := map[string]string{
+		"ليهمابتكلموشعربي؟":      "egbpdaj6bu4bxfgehfvwxn",
+		"他们为什么不说中文":              "ihqwcrb4cv8a8dqg056pqjye",
+		"Pročprostěnemluvíčesky": "Proprostnemluvesky-uyb24dma41a",
+		"bücher":                 "bcher-kva",
+	}
+	for label, encoded := range tests {
+		result, err := punycodeEncode(label)
+		if err != nil || result != encoded {
+			t.Errorf("%q: not equal %q != %q (%v)", label, encoded, result, err)
+		}
+		decoded, err := punycodeDecode(encoded)
+		if err != nil || decoded != label {
+			t.Errorf("%q: not equal %q != %q (%v)", encoded, label, decoded, err)
+		}
+	}
+	// Digits overflowing i, w and n, as in section 6.2 of the RFC
+	for _, encoded := range []string{"99999999999", "a-9999999", "999999999999999994999s"} {
+		if decoded, err := punycodeDecode(encoded); err == nil {
+			t.Errorf("%q: expected an error, got %q", encoded, decoded)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2014 Jason Goecke
+// correlation.go
+
+package wit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// SessionThreadKey is the session data key holding the thread id grouping
+// the messages of a conversation
+const SessionThreadKey = "thread_id"
+
+// Keys of the correlation ids in a context
+type correlationKey struct{}
+type threadKey struct{}
+
+// NewCorrelationID returns a random UUID (version 4)
+//
+//		id := wit.NewCorrelationID()
+func NewCorrelationID() string {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[:]); err != nil {
+		panic(err)
+	}
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+}
+
+// WithCorrelationID returns a context carrying the id sent as the msg_id of
+// the Wit calls made with it
+//
+//		ctx = wit.WithCorrelationID(ctx, r.Header.Get("X-Request-ID"))
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns the correlation id of a context
+//
+//		id, ok := wit.CorrelationID(ctx)
+func CorrelationID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(correlationKey{}).(string)
+	return id, ok && id != ""
+}
+
+// WithThreadID returns a context carrying the id sent as the thread_id of
+// the Wit calls made with it
+//
+//		ctx = wit.WithThreadID(ctx, session.ThreadID())
+func WithThreadID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, threadKey{}, id)
+}
+
+// ThreadID returns the thread id of a context
+//
+//		id, ok := wit.ThreadID(ctx)
+func ThreadID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(threadKey{}).(string)
+	return id, ok && id != ""
+}
+
+// ThreadID returns the thread id of the session, creating one the first
+// time. Save the session to keep it across messages.
+//
+//		ctx = wit.WithThreadID(ctx, session.ThreadID())
+func (session *Session) ThreadID() string {
+	if id, ok := session.Data[SessionThreadKey].(string); ok && id != "" {
+		return id
+	}
+	id := NewCorrelationID()
+	session.Set(SessionThreadKey, id)
+	return id
+}
+
+// ParseTraceparent returns the trace id of a W3C traceparent header, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01, or an empty
+// string when the header is not valid
+//
+//		id := wit.ParseTraceparent(r.Header.Get("traceparent"))
+func ParseTraceparent(traceparent string) string {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || parts[0] == "ff" {
+		return ""
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil || parts[1] == strings.Repeat("0", 32) {
+		return ""
+	}
+	return strings.ToLower(parts[1])
+}
+
+// RequestCorrelationID returns the correlation id of an incoming HTTP
+// request: its X-Request-ID or X-Correlation-ID header, else the trace id
+// of its traceparent header, else an empty string
+//
+//		ctx := wit.WithCorrelationID(r.Context(), wit.RequestCorrelationID(r))
+func RequestCorrelationID(r *http.Request) string {
+	for _, header := range []string{"X-Request-ID", "X-Correlation-ID"} {
+		if id := strings.TrimSpace(r.Header.Get(header)); id != "" {
+			return id
+		}
+	}
+	return ParseTraceparent(r.Header.Get("traceparent"))
+}
+
+// Returns the msg_id of a call: the request's, else the context's, else
+// the caller's trace id, else a new UUID
+func (client *Client) correlationID(ctx context.Context, request *MessageRequest) string {
+	if request.MsgID != "" {
+		return request.MsgID
+	}
+	if id, ok := CorrelationID(ctx); ok {
+		return id
+	}
+	if client.TraceID != nil {
+		if id := client.TraceID(ctx); id != "" {
+			return id
+		}
+	}
+	return NewCorrelationID()
+}
+
+// Returns the thread_id of a call, the request's or else the context's
+func threadID(ctx context.Context, request *MessageRequest) string {
+	if request.ThreadID != "" {
+		return request.ThreadID
+	}
+	id, _ := ThreadID(ctx)
+	return id
+}
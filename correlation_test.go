@@ -0,0 +1,116 @@
+// Copyright (c) 2014 Jason Goecke
+// correlation_test.go
+
+package wit
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNewCorrelationID(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	first, second := NewCorrelationID(), NewCorrelationID()
+	if !uuid.MatchString(first) || first == second {
+		t.Errorf("unexpected ids %s %s", first, second)
+	}
+}
+
+func TestRequestCorrelationID(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("traceparent", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01")
+	if id := RequestCorrelationID(r); id != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected the trace id, got %q", id)
+	}
+	r.Header.Set("X-Request-ID", "req-1")
+	if id := RequestCorrelationID(r); id != "req-1" {
+		t.Errorf("expected the request id, got %q", id)
+	}
+	for _, header := range []string{"", "00-abc-def-01", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"} {
+		if id := ParseTraceparent(header); id != "" {
+			t.Errorf("expected %q to be invalid, got %q", header, id)
+		}
+	}
+}
+
+func TestMessageCorrelation(t *testing.T) {
+	fake := &FakeServer{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	var logs bytes.Buffer
+	client := NewClient("token")
+	client.APIBase = server.URL
+	client.Logger = log.New(&logs, "", 0)
+
+	message, err := client.MessageContext(WithThreadID(WithCorrelationID(context.Background(), "req-1"), "thread-1"), &MessageRequest{Query: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.MsgID != "req-1" || message.ThreadID != "thread-1" {
+		t.Errorf("ids not sent %+v", message)
+	}
+	if !strings.Contains(logs.String(), "wit message msg_id=req-1 thread_id=thread-1") || strings.Contains(logs.String(), "hello") {
+		t.Errorf("unexpected log %q", logs.String())
+	}
+
+	message, _ = client.Message(&MessageRequest{Query: "hello", MsgID: "explicit"})
+	if message.MsgID != "explicit" {
+		t.Errorf("expected the request's msg_id, got %s", message.MsgID)
+	}
+	client.TraceID = func(ctx context.Context) string { return "trace-1" }
+	if message, _ = client.Message(&MessageRequest{Query: "hello"}); message.MsgID != "trace-1" {
+		t.Errorf("expected the trace id, got %s", message.MsgID)
+	}
+	client.TraceID = nil
+	if message, _ = client.Message(&MessageRequest{Query: "hello"}); len(message.MsgID) != 36 {
+		t.Errorf("expected a UUID, got %s", message.MsgID)
+	}
+
+	message, err = client.AudioMessageContext(WithCorrelationID(context.Background(), "req-2"), &MessageRequest{FileContents: []byte("audio"), ContentType: "audio/wav", ThreadID: "thread-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.MsgID != "req-2" || message.ThreadID != "thread-2" {
+		t.Errorf("ids not sent with audio %+v", message)
+	}
+
+	invalid := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>bad gateway</html>"))
+	}))
+	defer invalid.Close()
+	client.APIBase = invalid.URL
+	if message, err := client.MessageContext(context.Background(), &MessageRequest{Query: "hello"}); err == nil || message != nil {
+		t.Errorf("expected an invalid body to fail, got %+v %v", message, err)
+	}
+}
+
+func TestPipelineThreads(t *testing.T) {
+	fake := &FakeServer{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client := NewClient("token")
+	client.APIBase = server.URL
+	pipeline := &Pipeline{Client: client, Sessions: NewMemorySessionStore(0)}
+
+	first, err := pipeline.Process(context.Background(), "user-42", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pipeline.Process(WithCorrelationID(context.Background(), "req-3"), "user-42", "again")
+	if err != nil {
+		t.Fatal(err)
+	}
+	threadID, _ := first.Metadata[MetadataThreadID].(string)
+	if threadID == "" || second.Metadata[MetadataThreadID] != threadID || second.Message.ThreadID != threadID {
+		t.Errorf("expected the session's messages to share a thread %v %v", first.Metadata, second.Metadata)
+	}
+	if second.Metadata[MetadataMsgID] != "req-3" || first.Metadata[MetadataMsgID] == "" {
+		t.Errorf("unexpected msg_ids %v %v", first.Metadata, second.Metadata)
+	}
+}
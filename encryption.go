@@ -0,0 +1,438 @@
+// Copyright (c) 2014 Jason Goecke
+// encryption.go
+
+package wit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// EncryptionKeyEnv holds the encryption keys when they are not read from a file
+const EncryptionKeyEnv = "WIT_ENCRYPTION_KEY"
+
+// EncryptionKeySize is the size of the keys, AES-256
+const EncryptionKeySize = 32
+
+// Encrypted data starts with this magic, followed by a version byte
+var encryptionMagic = []byte("WITENC")
+
+const encryptionVersion = 1
+
+// Errors returned when decrypting
+var (
+	ErrNotEncrypted = errors.New("data is not encrypted")
+	ErrUnknownKey   = errors.New("data is encrypted with an unknown key")
+	ErrDecryption   = errors.New("data cannot be decrypted, it is corrupted or was tampered with")
+)
+
+// Keyring holds the keys used to encrypt files at rest. Data is encrypted
+// with a random data key using AES-GCM, and the data key is wrapped with
+// the keyring's primary key (envelope encryption). Older keys remain to
+// decrypt data until it is rewrapped with the new primary key.
+type Keyring struct {
+	mutex   sync.RWMutex
+	keys    map[string][]byte
+	primary string
+}
+
+// NewKeyring creates an empty keyring
+//
+//		keyring := wit.NewKeyring()
+//		err := keyring.AddKey("2016-01", key)
+func NewKeyring() *Keyring {
+	return &Keyring{keys: map[string][]byte{}}
+}
+
+// GenerateEncryptionKey returns a new random key
+//
+//		key, err := wit.GenerateEncryptionKey()
+func GenerateEncryptionKey() ([]byte, error) {
+	key := make([]byte, EncryptionKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ParseKeyring parses keys written one per line, or separated by commas, as
+// id:base64-key. The first key is the primary key. A single key may be
+// given without an id, its id is then "default".
+//
+//		keyring, err := wit.ParseKeyring("2016-02:q83vEjRWeJ...,2016-01:3q2+7w...")
+func ParseKeyring(text string) (*Keyring, error) {
+	keyring := NewKeyring()
+	scanner := bufio.NewScanner(strings.NewReader(strings.Replace(text, ",", "\n", -1)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, encoded := "default", line
+		if i := strings.Index(line, ":"); i >= 0 {
+			id, encoded = strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %s", id, err)
+		}
+		if err := keyring.AddKey(id, key); err != nil {
+			return nil, err
+		}
+	}
+	if len(keyring.keys) == 0 {
+		return nil, errors.New("no encryption keys")
+	}
+	return keyring, nil
+}
+
+// LoadKeyring reads keys from a file, see ParseKeyring for the format
+//
+//		keyring, err := wit.LoadKeyring("/etc/wit/keys")
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := ioutil.ReadFile(expandHome(path))
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeyring(string(data))
+}
+
+// KeyringFromEnv reads keys from the WIT_ENCRYPTION_KEY environment
+// variable, see ParseKeyring for the format
+//
+//		keyring, err := wit.KeyringFromEnv()
+func KeyringFromEnv() (*Keyring, error) {
+	text := os.Getenv(EncryptionKeyEnv)
+	if text == "" {
+		return nil, fmt.Errorf("%s is not set", EncryptionKeyEnv)
+	}
+	return ParseKeyring(text)
+}
+
+// AddKey adds a key, which becomes the primary key if it is the first one
+//
+//		err := keyring.AddKey("2016-01", key)
+func (keyring *Keyring) AddKey(id string, key []byte) error {
+	if id == "" || len(id) > 255 {
+		return errors.New("key ids must be 1 to 255 bytes long")
+	}
+	if len(key) != EncryptionKeySize {
+		return fmt.Errorf("key %s is %d bytes long, keys must be %d bytes long", id, len(key), EncryptionKeySize)
+	}
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+	if keyring.keys == nil {
+		keyring.keys = map[string][]byte{}
+	}
+	if _, ok := keyring.keys[id]; ok {
+		return fmt.Errorf("duplicate key %s", id)
+	}
+	keyring.keys[id] = append([]byte(nil), key...)
+	if keyring.primary == "" {
+		keyring.primary = id
+	}
+	return nil
+}
+
+// SetPrimary sets the key new data is encrypted with
+//
+//		err := keyring.SetPrimary("2016-02")
+func (keyring *Keyring) SetPrimary(id string) error {
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+	if _, ok := keyring.keys[id]; !ok {
+		return fmt.Errorf("unknown key %s", id)
+	}
+	keyring.primary = id
+	return nil
+}
+
+// RemoveKey removes a key once no data is encrypted with it anymore, the
+// primary key cannot be removed
+//
+//		err := keyring.RemoveKey("2016-01")
+func (keyring *Keyring) RemoveKey(id string) error {
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+	if id == keyring.primary {
+		return errors.New("the primary key cannot be removed")
+	}
+	delete(keyring.keys, id)
+	return nil
+}
+
+// Primary returns the id of the primary key
+//
+//		id := keyring.Primary()
+func (keyring *Keyring) Primary() string {
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+	return keyring.primary
+}
+
+// Encrypt encrypts data with a new data key wrapped by the primary key
+//
+//		ciphertext, err := keyring.Encrypt(data)
+func (keyring *Keyring) Encrypt(plaintext []byte) ([]byte, error) {
+	dataKey, err := GenerateEncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	header, err := keyring.wrap(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(header, nonce...)
+	return aead.Seal(out, nonce, plaintext, encryptionAAD()), nil
+}
+
+// Decrypt decrypts data encrypted with any of the keyring's keys
+//
+//		data, err := keyring.Decrypt(ciphertext)
+func (keyring *Keyring) Decrypt(ciphertext []byte) ([]byte, error) {
+	dataKey, rest, _, err := keyring.unwrap(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < aead.NonceSize() {
+		return nil, ErrDecryption
+	}
+	plaintext, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], encryptionAAD())
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	return plaintext, nil
+}
+
+// Rewrap wraps the data key of encrypted data with the primary key, without
+// decrypting the data itself. It reports false when the data already uses
+// the primary key.
+//
+//		rewrapped, changed, err := keyring.Rewrap(ciphertext)
+func (keyring *Keyring) Rewrap(ciphertext []byte) ([]byte, bool, error) {
+	dataKey, rest, id, err := keyring.unwrap(ciphertext)
+	if err != nil {
+		return nil, false, err
+	}
+	if id == keyring.Primary() {
+		return ciphertext, false, nil
+	}
+	header, err := keyring.wrap(dataKey)
+	if err != nil {
+		return nil, false, err
+	}
+	return append(header, rest...), true, nil
+}
+
+// IsEncrypted reports whether data was encrypted by a keyring
+//
+//		if wit.IsEncrypted(data) { ... }
+func IsEncrypted(data []byte) bool {
+	return len(data) > len(encryptionMagic) && bytes.HasPrefix(data, encryptionMagic)
+}
+
+// Wraps a data key with the primary key into the header of encrypted data:
+// magic, version, key id length, key id, nonce and wrapped data key
+func (keyring *Keyring) wrap(dataKey []byte) ([]byte, error) {
+	keyring.mutex.RLock()
+	id, key := keyring.primary, keyring.keys[keyring.primary]
+	keyring.mutex.RUnlock()
+	if key == nil {
+		return nil, errors.New("keyring has no keys")
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header := append([]byte(nil), encryptionMagic...)
+	header = append(header, encryptionVersion, byte(len(id)))
+	header = append(header, id...)
+	header = append(header, nonce...)
+	return aead.Seal(header, nonce, dataKey, []byte(id)), nil
+}
+
+// Unwraps the data key of encrypted data, returning it with the rest of the
+// data and the id of the key that wrapped it
+func (keyring *Keyring) unwrap(ciphertext []byte) ([]byte, []byte, string, error) {
+	if !IsEncrypted(ciphertext) {
+		return nil, nil, "", ErrNotEncrypted
+	}
+	rest := ciphertext[len(encryptionMagic):]
+	if len(rest) < 2 || rest[0] != encryptionVersion {
+		return nil, nil, "", ErrDecryption
+	}
+	idLength := int(rest[1])
+	rest = rest[2:]
+	if len(rest) < idLength {
+		return nil, nil, "", ErrDecryption
+	}
+	id := string(rest[:idLength])
+	rest = rest[idLength:]
+
+	keyring.mutex.RLock()
+	key := keyring.keys[id]
+	keyring.mutex.RUnlock()
+	if key == nil {
+		return nil, nil, id, ErrUnknownKey
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, nil, id, err
+	}
+	wrappedSize := aead.NonceSize() + EncryptionKeySize + aead.Overhead()
+	if len(rest) < wrappedSize {
+		return nil, nil, id, ErrDecryption
+	}
+	dataKey, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():wrappedSize], []byte(id))
+	if err != nil {
+		return nil, nil, id, ErrDecryption
+	}
+	return dataKey, rest[wrappedSize:], id, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// The data is bound to the format, the data key is what changes on rewrap
+func encryptionAAD() []byte {
+	return append(append([]byte(nil), encryptionMagic...), encryptionVersion)
+}
+
+// WriteEncryptedFile writes data to a file atomically, encrypted when the
+// keyring is not nil
+//
+//		err := wit.WriteEncryptedFile("./sessions/42.session", data, keyring, 0600)
+func WriteEncryptedFile(path string, data []byte, keyring *Keyring, perm os.FileMode) error {
+	if keyring != nil {
+		var err error
+		if data, err = keyring.Encrypt(data); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomically(path, data, perm)
+}
+
+// ReadEncryptedFile reads a file written by WriteEncryptedFile. With a
+// keyring, plaintext files are refused with ErrNotEncrypted, so a file
+// planted in the directory is not taken for one the library wrote.
+//
+//		data, err := wit.ReadEncryptedFile("./sessions/42.session", keyring)
+func ReadEncryptedFile(path string, keyring *Keyring) ([]byte, error) {
+	return readEncryptedFile(path, keyring, false)
+}
+
+// Reads a file written by WriteEncryptedFile, reading plaintext files as
+// is when allowPlaintext is true
+func readEncryptedFile(path string, keyring *Keyring, allowPlaintext bool) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !IsEncrypted(data) {
+		if keyring != nil && !allowPlaintext {
+			return nil, fmt.Errorf("%s: %s", path, ErrNotEncrypted)
+		}
+		return data, nil
+	}
+	if keyring == nil {
+		return nil, fmt.Errorf("%s is encrypted and there is no keyring", path)
+	}
+	return keyring.Decrypt(data)
+}
+
+// RewrapFiles rewraps the encrypted files of a directory tree with the
+// primary key, encrypting plaintext files too when encryptPlaintext is
+// set, and returns the number of files rewritten. Once it returns, older
+// keys can be removed from the keyring.
+//
+//		keyring.SetPrimary("2016-02")
+//		count, err := wit.RewrapFiles("./sessions", keyring, false)
+func RewrapFiles(dir string, keyring *Keyring, encryptPlaintext bool) (int, error) {
+	count := 0
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var changed bool
+		if IsEncrypted(data) {
+			if data, changed, err = keyring.Rewrap(data); err != nil {
+				return fmt.Errorf("%s: %s", path, err)
+			}
+		} else if encryptPlaintext {
+			if data, err = keyring.Encrypt(data); err != nil {
+				return err
+			}
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+		count++
+		return writeFileAtomically(path, data, info.Mode().Perm())
+	})
+	return count, err
+}
+
+// Writes a file through a temporary file renamed over it
+func writeFileAtomically(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2014 Jason Goecke
+// encryption_test.go
+
+package wit
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKeyring(t *testing.T, ids ...string) *Keyring {
+	keyring := NewKeyring()
+	for _, id := range ids {
+		key, err := GenerateEncryptionKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := keyring.AddKey(id, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return keyring
+}
+
+func TestKeyringEncryptDecrypt(t *testing.T) {
+	keyring := testKeyring(t, "k1")
+	plaintext := []byte("book a table for two at Nopa tonight")
+	ciphertext, err := keyring.Encrypt(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(ciphertext) || bytes.Contains(ciphertext, []byte("Nopa")) {
+		t.Error("ciphertext holds plaintext")
+	}
+	if decrypted, err := keyring.Decrypt(ciphertext); err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("round trip failed %q %v", decrypted, err)
+	}
+
+	tampered := append([]byte(nil), ciphertext...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := keyring.Decrypt(tampered); err != ErrDecryption {
+		t.Errorf("expected ErrDecryption for tampered data, got %v", err)
+	}
+	if _, err := testKeyring(t, "k2").Decrypt(ciphertext); err != ErrUnknownKey {
+		t.Errorf("expected ErrUnknownKey, got %v", err)
+	}
+	if _, err := keyring.Decrypt(plaintext); err != ErrNotEncrypted {
+		t.Errorf("expected ErrNotEncrypted, got %v", err)
+	}
+	if err := keyring.AddKey("short", []byte("too short")); err == nil {
+		t.Error("expected an error for a short key")
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	keyring := testKeyring(t, "old")
+	ciphertext, _ := keyring.Encrypt([]byte("secret"))
+	key, _ := GenerateEncryptionKey()
+	keyring.AddKey("new", key)
+	if err := keyring.SetPrimary("new"); err != nil {
+		t.Fatal(err)
+	}
+	rewrapped, changed, err := keyring.Rewrap(ciphertext)
+	if err != nil || !changed {
+		t.Fatalf("expected the data to be rewrapped %v", err)
+	}
+	if _, changed, _ := keyring.Rewrap(rewrapped); changed {
+		t.Error("data already using the primary key should not change")
+	}
+	if err := keyring.RemoveKey("new"); err == nil {
+		t.Error("expected an error removing the primary key")
+	}
+	keyring.RemoveKey("old")
+	if decrypted, err := keyring.Decrypt(rewrapped); err != nil || string(decrypted) != "secret" {
+		t.Errorf("rewrapped data not decrypted %q %v", decrypted, err)
+	}
+	if _, err := keyring.Decrypt(ciphertext); err != ErrUnknownKey {
+		t.Errorf("expected data wrapped by a removed key to fail, got %v", err)
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	k1, _ := GenerateEncryptionKey()
+	k2, _ := GenerateEncryptionKey()
+	text := "k2:" + base64.StdEncoding.EncodeToString(k2) + ", k1:" + base64.StdEncoding.EncodeToString(k1)
+	os.Setenv(EncryptionKeyEnv, text)
+	defer os.Unsetenv(EncryptionKeyEnv)
+	keyring, err := KeyringFromEnv()
+	if err != nil || keyring.Primary() != "k2" || len(keyring.keys) != 2 {
+		t.Errorf("keyring not parsed %v", err)
+	}
+	if keyring, err = ParseKeyring(base64.StdEncoding.EncodeToString(k1)); err != nil || keyring.Primary() != "default" {
+		t.Errorf("single key not parsed %v", err)
+	}
+	if _, err := ParseKeyring("k1:not base64!"); err == nil {
+		t.Error("expected an error for an invalid key")
+	}
+}
+
+func TestEncryptedFilesHoldNoPlaintext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encryption")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyring := testKeyring(t, "k1")
+	store := NewFileSessionStore(filepath.Join(dir, "sessions"), keyring)
+	session := NewSession("+14155550123")
+	session.Set("last_utterance", "my card number is 4111 1111 1111 1111")
+	if err := store.Save(session); err != nil {
+		t.Fatal(err)
+	}
+	plainPath := filepath.Join(dir, "plain.json")
+	if err := WriteEncryptedFile(plainPath, []byte(`{"q": "cancel my order 1234"}`), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	assertNoPlaintext := func() {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			data, _ := ioutil.ReadFile(path)
+			for _, secret := range []string{"4111", "+14155550123", "last_utterance", "cancel my order"} {
+				if bytes.Contains(data, []byte(secret)) || bytes.Contains([]byte(path), []byte(secret)) {
+					t.Errorf("%s holds plaintext %q", path, secret)
+				}
+			}
+			return nil
+		})
+	}
+	if count, err := RewrapFiles(dir, keyring, true); err != nil || count != 1 {
+		t.Errorf("expected the plaintext file to be encrypted, got %d %v", count, err)
+	}
+	assertNoPlaintext()
+
+	key, _ := GenerateEncryptionKey()
+	keyring.AddKey("k2", key)
+	keyring.SetPrimary("k2")
+	if count, err := RewrapFiles(dir, keyring, false); err != nil || count != 2 {
+		t.Errorf("expected both files to be rewrapped, got %d %v", count, err)
+	}
+	keyring.RemoveKey("k1")
+	assertNoPlaintext()
+
+	loaded, err := store.Load("+14155550123")
+	if err != nil || loaded.Data["last_utterance"] != "my card number is 4111 1111 1111 1111" {
+		t.Errorf("session not loaded %+v %v", loaded, err)
+	}
+	if data, err := ReadEncryptedFile(plainPath, keyring); err != nil || string(data) != `{"q": "cancel my order 1234"}` {
+		t.Errorf("file not decrypted %q %v", data, err)
+	}
+	if _, err := ReadEncryptedFile(plainPath, nil); err == nil {
+		t.Error("expected an error reading an encrypted file without a keyring")
+	}
+	store.Delete("+14155550123")
+	if loaded, _ = store.Load("+14155550123"); len(loaded.Data) != 0 {
+		t.Error("session not deleted")
+	}
+}
+
+func TestPlaintextFilesRefused(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encryption")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	session := NewSession("user-42")
+	session.Set("city", "Paris")
+	if err := NewFileSessionStore(dir, nil).Save(session); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewFileSessionStore(dir, testKeyring(t, "k1"))
+	if _, err := store.Load("user-42"); err == nil || !strings.Contains(err.Error(), ErrNotEncrypted.Error()) {
+		t.Errorf("expected the plaintext session to be refused, got %v", err)
+	}
+	store.AllowPlaintext = true
+	if loaded, err := store.Load("user-42"); err != nil || loaded.Data["city"] != "Paris" {
+		t.Errorf("expected the plaintext session while migrating, got %+v %v", loaded, err)
+	}
+	if count, err := RewrapFiles(dir, store.Keyring, true); err != nil || count != 1 {
+		t.Fatalf("expected the session to be encrypted, got %d %v", count, err)
+	}
+	store.AllowPlaintext = false
+	if loaded, err := store.Load("user-42"); err != nil || loaded.Data["city"] != "Paris" {
+		t.Errorf("expected the encrypted session, got %+v %v", loaded, err)
+	}
+}
+
+func TestCopiedSessionFileRefused(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encryption")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := NewFileSessionStore(dir, testKeyring(t, "k1"))
+	for _, id := range []string{"user-42", "user-7"} {
+		session := NewSession(id)
+		session.Set("owner", id)
+		if err := store.Save(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadFile(store.path("user-7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(store.path("user-42"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if session, err := store.Load("user-42"); err == nil {
+		t.Errorf("expected the copied session to be refused, got %+v", session)
+	}
+	if session, err := store.Load("user-7"); err != nil || session.Data["owner"] != "user-7" {
+		t.Errorf("expected the original session, got %+v %v", session, err)
+	}
+}
@@ -0,0 +1,395 @@
+// Copyright (c) 2014 Jason Goecke
+// endpoints.go
+
+package wit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Strategies choosing the endpoint of a request
+const (
+	// EndpointOrdered sends requests to the first available endpoint
+	EndpointOrdered = "ordered"
+	// EndpointWeighted spreads requests over the available endpoints by weight
+	EndpointWeighted = "weighted"
+)
+
+const (
+	// DefaultEndpointMaxFailures is the number of consecutive failures
+	// ejecting an endpoint
+	DefaultEndpointMaxFailures = 3
+	// DefaultEndpointEjection is how long an endpoint stays ejected
+	DefaultEndpointEjection = 30 * time.Second
+)
+
+// Endpoint represents a base URL of the Wit API, such as a regional proxy.
+// Weight is used by the weighted strategy, 1 when zero.
+type Endpoint struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight,omitempty"`
+}
+
+// EndpointStats represents the state and counters of an endpoint
+type EndpointStats struct {
+	URL     string `json:"url"`
+	Healthy bool   `json:"healthy"`
+	// EjectedUntil is set while the endpoint is ejected after failures
+	EjectedUntil time.Time `json:"ejected_until,omitempty"`
+	Served       int64     `json:"served"`
+	Failures     int64     `json:"failures"`
+	Ejections    int64     `json:"ejections"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+// EndpointEvent represents an attempt to make a request on an endpoint
+type EndpointEvent struct {
+	Endpoint string
+	Method   string
+	Path     string
+	// Attempt counts from 1, attempts after the first are failovers
+	Attempt  int
+	Status   int
+	Err      error
+	Duration time.Duration
+}
+
+// EndpointPool spreads a client's requests over several base URLs. An
+// endpoint failing MaxFailures requests in a row, with a network error or
+// a 5xx response, is ejected for EjectionTime; one failing a health check
+// is skipped until a check passes. Idempotent requests (GET, HEAD, PUT,
+// DELETE, OPTIONS) failing on an endpoint are retried on the next one.
+type EndpointPool struct {
+	Strategy     string
+	MaxFailures  int
+	EjectionTime time.Duration
+	// HealthPath is requested by health checks, any response below 500 is
+	// healthy
+	HealthPath string
+	// HTTPClient makes the health checks, http.DefaultClient when nil
+	HTTPClient *http.Client
+	// OnRequest, when set, is called after each attempt
+	OnRequest func(event EndpointEvent)
+
+	mutex     sync.Mutex
+	endpoints []*endpointState
+	random    *rand.Rand
+	stop      chan struct{}
+}
+
+type endpointState struct {
+	Endpoint
+	healthy      bool
+	ejectedUntil time.Time
+	consecutive  int
+	served       int64
+	failures     int64
+	ejections    int64
+	lastError    string
+}
+
+// NewEndpointPool creates a pool of endpoints, tried in order or by weight
+//
+//		pool, err := wit.NewEndpointPool(wit.EndpointOrdered,
+//			wit.Endpoint{URL: "https://wit-eu.example.com"},
+//			wit.Endpoint{URL: "https://wit-us.example.com"})
+//		client.Endpoints = pool
+func NewEndpointPool(strategy string, endpoints ...Endpoint) (*EndpointPool, error) {
+	if strategy == "" {
+		strategy = EndpointOrdered
+	}
+	if strategy != EndpointOrdered && strategy != EndpointWeighted {
+		return nil, fmt.Errorf("unknown endpoint strategy %q", strategy)
+	}
+	if len(endpoints) == 0 {
+		return nil, errors.New("an endpoint pool needs at least one endpoint")
+	}
+	pool := &EndpointPool{
+		Strategy:     strategy,
+		MaxFailures:  DefaultEndpointMaxFailures,
+		EjectionTime: DefaultEndpointEjection,
+		HealthPath:   "/",
+		random:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, endpoint := range endpoints {
+		parsed, err := url.Parse(endpoint.URL)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid endpoint url %q", endpoint.URL)
+		}
+		if endpoint.Weight < 0 {
+			return nil, fmt.Errorf("endpoint %s has a negative weight", endpoint.URL)
+		}
+		if endpoint.Weight == 0 {
+			endpoint.Weight = 1
+		}
+		endpoint.URL = strings.TrimSuffix(endpoint.URL, "/")
+		pool.endpoints = append(pool.endpoints, &endpointState{Endpoint: endpoint, healthy: true})
+	}
+	return pool, nil
+}
+
+// Do sends a request made against base to an endpoint of the pool, failing
+// over to the next endpoints when the request is idempotent
+//
+//		resp, err := pool.Do(http.DefaultClient, req, "https://api.wit.ai")
+func (pool *EndpointPool) Do(httpClient *http.Client, req *http.Request, base string) (*http.Response, error) {
+	rest := strings.TrimPrefix(req.URL.String(), base)
+	if base == "" || len(rest) == len(req.URL.String()) || rest != "" && rest[0] != '/' && rest[0] != '?' {
+		return httpClient.Do(req)
+	}
+	candidates := pool.candidates()
+	// Requests whose body cannot be read again are not retried either
+	if !idempotent(req.Method) || req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		candidates = candidates[:1]
+	}
+
+	var resp *http.Response
+	var err error
+	for i, endpoint := range candidates {
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		attempt := req.Clone(req.Context())
+		if attempt.URL, err = url.Parse(endpoint.URL + rest); err != nil {
+			return nil, err
+		}
+		attempt.Host = ""
+		if req.GetBody != nil {
+			if attempt.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		start := time.Now()
+		resp, err = httpClient.Do(attempt)
+		event := EndpointEvent{Endpoint: endpoint.URL, Method: req.Method, Path: req.URL.Path, Attempt: i + 1, Err: err, Duration: time.Since(start)}
+		if resp != nil {
+			event.Status = resp.StatusCode
+		}
+		if pool.OnRequest != nil {
+			pool.OnRequest(event)
+		}
+		if req.Context().Err() != nil {
+			return resp, err
+		}
+		failed := err != nil || resp.StatusCode >= 500
+		pool.record(endpoint, failed, err, resp)
+		if !failed {
+			return resp, nil
+		}
+	}
+	return resp, err
+}
+
+// Returns the endpoints in the order to try them: the available ones
+// first, by strategy, then the others in case all are down
+func (pool *EndpointPool) candidates() []*endpointState {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	now := time.Now()
+	var available, unavailable []*endpointState
+	for _, endpoint := range pool.endpoints {
+		if endpoint.healthy && !now.Before(endpoint.ejectedUntil) {
+			available = append(available, endpoint)
+		} else {
+			unavailable = append(unavailable, endpoint)
+		}
+	}
+	if pool.Strategy == EndpointWeighted && len(available) > 1 {
+		total := 0
+		for _, endpoint := range available {
+			total += endpoint.Weight
+		}
+		pick := pool.random.Intn(total)
+		first := 0
+		for i, endpoint := range available {
+			if pick < endpoint.Weight {
+				first = i
+				break
+			}
+			pick -= endpoint.Weight
+		}
+		ordered := []*endpointState{available[first]}
+		ordered = append(ordered, available[:first]...)
+		ordered = append(ordered, available[first+1:]...)
+		sort.SliceStable(ordered[1:], func(i, j int) bool { return ordered[i+1].Weight > ordered[j+1].Weight })
+		available = ordered
+	}
+	return append(available, unavailable...)
+}
+
+// Records the outcome of a request on an endpoint, ejecting it after too
+// many failures in a row
+func (pool *EndpointPool) record(endpoint *endpointState, failed bool, err error, resp *http.Response) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if !failed {
+		endpoint.served++
+		endpoint.consecutive = 0
+		return
+	}
+	endpoint.failures++
+	endpoint.consecutive++
+	if err != nil {
+		endpoint.lastError = err.Error()
+	} else {
+		endpoint.lastError = resp.Status
+	}
+	maxFailures := pool.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = DefaultEndpointMaxFailures
+	}
+	if endpoint.consecutive >= maxFailures {
+		ejection := pool.EjectionTime
+		if ejection <= 0 {
+			ejection = DefaultEndpointEjection
+		}
+		endpoint.ejectedUntil = time.Now().Add(ejection)
+		endpoint.ejections++
+		endpoint.consecutive = 0
+	}
+}
+
+// CheckHealth requests the health path of every endpoint, marking them
+// healthy or not
+//
+//		pool.CheckHealth(ctx)
+func (pool *EndpointPool) CheckHealth(ctx context.Context) {
+	httpClient := pool.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	pool.mutex.Lock()
+	endpoints := append([]*endpointState(nil), pool.endpoints...)
+	pool.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, endpoint := range endpoints {
+		wg.Add(1)
+		go func(endpoint *endpointState) {
+			defer wg.Done()
+			var healthy bool
+			var problem string
+			req, err := http.NewRequest("GET", endpoint.URL+pool.HealthPath, nil)
+			if err == nil {
+				var resp *http.Response
+				if resp, err = httpClient.Do(req.WithContext(ctx)); err == nil {
+					io.Copy(ioutil.Discard, resp.Body)
+					resp.Body.Close()
+					healthy = resp.StatusCode < 500
+					problem = resp.Status
+				}
+			}
+			if err != nil {
+				problem = err.Error()
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			pool.mutex.Lock()
+			endpoint.healthy = healthy
+			if !healthy {
+				endpoint.lastError = "health check: " + problem
+			}
+			pool.mutex.Unlock()
+		}(endpoint)
+	}
+	wg.Wait()
+}
+
+// StartHealthChecks checks the endpoints' health every interval until the
+// pool is closed
+//
+//		pool.StartHealthChecks(10 * time.Second)
+//		defer pool.Close()
+func (pool *EndpointPool) StartHealthChecks(interval time.Duration) {
+	pool.mutex.Lock()
+	if pool.stop != nil {
+		pool.mutex.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	pool.stop = stop
+	pool.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			go func() {
+				select {
+				case <-stop:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			pool.CheckHealth(ctx)
+			cancel()
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close stops the health checks
+//
+//		pool.Close()
+func (pool *EndpointPool) Close() {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if pool.stop != nil {
+		close(pool.stop)
+		pool.stop = nil
+	}
+}
+
+// Stats returns the state and counters of the endpoints, in the order
+// they were given
+//
+//		for _, stats := range pool.Stats() {
+//			fmt.Println(stats.URL, stats.Served, stats.Failures)
+//		}
+func (pool *EndpointPool) Stats() []EndpointStats {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	now := time.Now()
+	stats := make([]EndpointStats, len(pool.endpoints))
+	for i, endpoint := range pool.endpoints {
+		stats[i] = EndpointStats{
+			URL:       endpoint.URL,
+			Healthy:   endpoint.healthy,
+			Served:    endpoint.served,
+			Failures:  endpoint.failures,
+			Ejections: endpoint.ejections,
+			LastError: endpoint.lastError,
+		}
+		if now.Before(endpoint.ejectedUntil) {
+			stats[i].EjectedUntil = endpoint.ejectedUntil
+		}
+	}
+	return stats
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2014 Jason Goecke
+// endpoints_test.go
+
+package wit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// Starts an endpoint answering with status, counting its requests
+func testEndpoint(status *int64, hits *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(hits, 1)
+		w.WriteHeader(int(atomic.LoadInt64(status)))
+		w.Write([]byte(`[]`))
+	}))
+}
+
+func TestEndpointFailover(t *testing.T) {
+	downStatus, upStatus := int64(503), int64(200)
+	var downHits, upHits int64
+	down, up := testEndpoint(&downStatus, &downHits), testEndpoint(&upStatus, &upHits)
+	defer down.Close()
+	defer up.Close()
+
+	pool, err := NewEndpointPool(EndpointOrdered, Endpoint{URL: down.URL}, Endpoint{URL: up.URL + "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []EndpointEvent
+	pool.OnRequest = func(event EndpointEvent) { events = append(events, event) }
+	client := NewClient("token")
+	client.Endpoints = pool
+
+	if _, err := client.get(client.APIBase + "/intents"); err != nil {
+		t.Fatalf("expected a failover, got %v", err)
+	}
+	if len(events) != 2 || events[0].Endpoint != down.URL || events[0].Status != 503 || events[1].Endpoint != up.URL || events[1].Attempt != 2 {
+		t.Errorf("unexpected events %+v", events)
+	}
+	if _, err := client.post(client.APIBase+"/entities", []byte(`{}`)); err == nil {
+		t.Error("expected a POST not to fail over")
+	}
+	if upHits != 1 {
+		t.Errorf("expected the POST to stay on the first endpoint, got %d hits on the second", upHits)
+	}
+
+	client.get(client.APIBase + "/intents")
+	stats := pool.Stats()
+	if stats[0].Failures != 3 || stats[0].Ejections != 1 || stats[0].EjectedUntil.IsZero() || stats[1].Served != 2 {
+		t.Errorf("expected the first endpoint to be ejected %+v", stats)
+	}
+	client.get(client.APIBase + "/intents")
+	if downHits != 3 || upHits != 3 {
+		t.Errorf("expected the ejected endpoint to be skipped, got %d and %d hits", downHits, upHits)
+	}
+}
+
+func TestEndpointHealthChecks(t *testing.T) {
+	firstStatus, secondStatus := int64(500), int64(200)
+	var firstHits, secondHits int64
+	first, second := testEndpoint(&firstStatus, &firstHits), testEndpoint(&secondStatus, &secondHits)
+	defer first.Close()
+	defer second.Close()
+
+	pool, err := NewEndpointPool(EndpointOrdered, Endpoint{URL: first.URL}, Endpoint{URL: second.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.CheckHealth(context.Background())
+	if stats := pool.Stats(); stats[0].Healthy || !stats[1].Healthy {
+		t.Fatalf("unexpected health %+v", stats)
+	}
+	client := NewClient("token")
+	client.Endpoints = pool
+	client.post(client.APIBase+"/entities", []byte(`{}`))
+	// Health checks count as hits too
+	if firstHits != 1 || secondHits != 2 {
+		t.Errorf("expected the unhealthy endpoint to be skipped, got %d and %d hits", firstHits, secondHits)
+	}
+
+	atomic.StoreInt64(&firstStatus, 200)
+	pool.CheckHealth(context.Background())
+	client.post(client.APIBase+"/entities", []byte(`{}`))
+	if firstHits != 3 || secondHits != 3 {
+		t.Errorf("expected the endpoint to be back, got %d and %d hits", firstHits, secondHits)
+	}
+}
+
+func TestWeightedEndpoints(t *testing.T) {
+	status := int64(200)
+	var heavyHits, lightHits int64
+	heavy, light := testEndpoint(&status, &heavyHits), testEndpoint(&status, &lightHits)
+	defer heavy.Close()
+	defer light.Close()
+
+	pool, err := NewEndpointPool(EndpointWeighted, Endpoint{URL: light.URL, Weight: 1}, Endpoint{URL: heavy.URL, Weight: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient("token")
+	client.Endpoints = pool
+	for i := 0; i < 200; i++ {
+		if _, err := client.get(client.APIBase + "/intents"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lightHits == 0 || heavyHits < 2*lightHits {
+		t.Errorf("unexpected spread %d heavy, %d light", heavyHits, lightHits)
+	}
+
+	if _, err := NewEndpointPool("random", Endpoint{URL: light.URL}); err == nil {
+		t.Error("expected an unknown strategy to fail")
+	}
+	if _, err := NewEndpointPool(EndpointOrdered, Endpoint{URL: "not a url"}); err == nil {
+		t.Error("expected an invalid url to fail")
+	}
+}
+
+func TestProfileEndpoints(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+profiles:
+  default:
+    token: token
+    endpoint_strategy: weighted
+    endpoints:
+      - url: https://wit-eu.example.com
+        weight: 3
+      - url: https://wit-us.example.com
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, err := config.Profile("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := profile.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := client.Endpoints.Stats()
+	if client.Endpoints.Strategy != EndpointWeighted || len(stats) != 2 || stats[1].URL != "https://wit-us.example.com" {
+		t.Errorf("endpoints not configured %+v", stats)
+	}
+}
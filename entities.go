@@ -4,9 +4,13 @@
 package wit
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/url"
+	"path"
 	"strings"
+	"sync"
 )
 
 // Entity represents an Entity for the Wit API (https://wit.ai/docs/api#toc_15)
@@ -22,6 +26,7 @@ type Entity struct {
 type EntityValue struct {
 	Value       string   `json:"value"`
 	Expressions []string `json:"expressions"`
+	Metadata    string   `json:"metadata,omitempty"`
 }
 
 // Expression respresents the expression
@@ -32,6 +37,53 @@ type Expression struct {
 // Entities represents a slice of entites when returend as an array (https://wit.ai/docs/api#toc_15)
 type Entities []string
 
+// Kinds of entities to list
+const (
+	EntityKindBuiltin = "builtin"
+	EntityKindCustom  = "custom"
+)
+
+// DefaultEntityConcurrency is the number of entities fetched at once by
+// EntitiesDetailed when the options set none
+const DefaultEntityConcurrency = 4
+
+// EntitiesOptions represents the options of EntitiesDetailed. Kind selects
+// builtin or custom entities, all when empty, and Pattern selects entities
+// by name, as a path.Match pattern such as "wit$*" or "*_city".
+type EntitiesOptions struct {
+	Kind        string
+	Pattern     string
+	Concurrency int
+}
+
+// EntityError represents the failure to fetch an entity
+type EntityError struct {
+	ID  string
+	Err error
+}
+
+// Error returns the error message
+func (err *EntityError) Error() string {
+	return err.ID + ": " + err.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (err *EntityError) Unwrap() error {
+	return err.Err
+}
+
+// EntityErrors represents the entities EntitiesDetailed failed to fetch
+type EntityErrors []*EntityError
+
+// Error returns the error message
+func (errs EntityErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return "fetching entities failed: " + strings.Join(messages, "; ")
+}
+
 // CreateEntity creates a new entity (https://wit.ai/docs/api#toc_19)
 //
 //		result, err := client.CreateEntity(entity)
@@ -40,7 +92,7 @@ func (client *Client) CreateEntity(entity *Entity) (*Entity, error) {
 	if err != nil {
 		return nil, err
 	}
-	result, err := post(client.APIBase+"/entities", data)
+	result, err := client.post(client.APIBase+"/entities", data)
 	if err != nil {
 		return nil, err
 	}
@@ -54,7 +106,7 @@ func (client *Client) CreateEntity(entity *Entity) (*Entity, error) {
 //		result, err := client.CreateEntityValue("favorite_city, entityValue)
 func (client *Client) CreateEntityValue(id string, entityValue *EntityValue) (*Entity, error) {
 	data, _ := json.Marshal(entityValue)
-	result, err := post(client.APIBase+"/entities/"+id+"/values", data)
+	result, err := client.post(client.APIBase+"/entities/"+id+"/values", data)
 	if err != nil {
 		return nil, err
 	}
@@ -71,7 +123,7 @@ func (client *Client) CreateEntityValue(id string, entityValue *EntityValue) (*E
 //		result, err := client.CreateEntityValueExp("favorite_city", "Barcelona", "Paella")
 func (client *Client) CreateEntityValueExp(id string, value string, exp string) (*Entity, error) {
 	jsonData, _ := json.Marshal(&Expression{exp})
-	result, err := post(client.APIBase+"/entities/"+id+"/values/"+value+"/expressions", jsonData)
+	result, err := client.post(client.APIBase+"/entities/"+id+"/values/"+value+"/expressions", jsonData)
 	if err != nil {
 		return nil, err
 	}
@@ -88,7 +140,7 @@ func (client *Client) CreateEntityValueExp(id string, value string, exp string)
 //		result, err := client.DeleteEntity("favorite_city")
 func (client *Client) DeleteEntity(id string) error {
 	id = url.QueryEscape(id)
-	_, err := delete(client.APIBase+"/entities", id)
+	_, err := client.delete(client.APIBase+"/entities", id)
 	if err != nil {
 		return err
 	}
@@ -100,7 +152,7 @@ func (client *Client) DeleteEntity(id string) error {
 // 		result, err := client.DeleteEntityValue("favorite_city", "Paris")
 func (client *Client) DeleteEntityValue(id string, value string) ([]byte, error) {
 	id = url.QueryEscape(id)
-	result, err := delete(client.APIBase+"/entities", id+"/values/"+value)
+	result, err := client.delete(client.APIBase+"/entities", id+"/values/"+value)
 	if err != nil {
 		return nil, err
 	}
@@ -113,7 +165,7 @@ func (client *Client) DeleteEntityValue(id string, value string) ([]byte, error)
 func (client *Client) DeleteEntityValueExp(id string, value string, exp string) ([]byte, error) {
 	id = url.QueryEscape(id)
 	exp = strings.Replace(url.QueryEscape(exp), "+", "%20", -1)
-	result, err := delete(client.APIBase+"/entities", id+"/values/"+value+"/expressions/"+exp)
+	result, err := client.delete(client.APIBase+"/entities", id+"/values/"+value+"/expressions/"+exp)
 	if err != nil {
 		return nil, err
 	}
@@ -124,7 +176,7 @@ func (client *Client) DeleteEntityValueExp(id string, value string, exp string)
 //
 //		result, err := client.Entities()
 func (client *Client) Entities() (*Entities, error) {
-	result, err := get(client.APIBase + "/entities")
+	result, err := client.get(client.APIBase + "/entities")
 	if err != nil {
 		return nil, err
 	}
@@ -132,12 +184,100 @@ func (client *Client) Entities() (*Entities, error) {
 	return entities, nil
 }
 
+// EntitiesDetailed lists the configured entities matching the options with
+// their values, fetching up to Concurrency entities at once. Entities that
+// could not be fetched are left out and reported by an EntityErrors error,
+// returned along with the others.
+//
+//		entities, err := client.EntitiesDetailed(ctx, &wit.EntitiesOptions{Kind: wit.EntityKindCustom})
+//		if errs, ok := err.(wit.EntityErrors); ok { ... }
+func (client *Client) EntitiesDetailed(ctx context.Context, opts *EntitiesOptions) ([]*Entity, error) {
+	if opts == nil {
+		opts = &EntitiesOptions{}
+	}
+	if opts.Kind != "" && opts.Kind != EntityKindBuiltin && opts.Kind != EntityKindCustom {
+		return nil, fmt.Errorf("unknown entity kind %q", opts.Kind)
+	}
+	if _, err := path.Match(opts.Pattern, ""); err != nil {
+		return nil, err
+	}
+	result, err := client.processRequestContext(ctx, &HTTPParams{Verb: "GET", Resource: client.APIBase + "/entities"})
+	if err != nil {
+		return nil, err
+	}
+	listed, err := parseEntities(result)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, id := range *listed {
+		if opts.Kind == EntityKindBuiltin && !builtinEntity(id) || opts.Kind == EntityKindCustom && builtinEntity(id) {
+			continue
+		}
+		if matched, _ := path.Match(opts.Pattern, id); opts.Pattern != "" && !matched {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultEntityConcurrency
+	}
+	entities := make([]*Entity, len(ids))
+	errs := make([]error, len(ids))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return nil, ctx.Err()
+		}
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			entities[i], errs[i] = client.entity(ctx, id)
+		}(i, id)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	fetched := make([]*Entity, 0, len(ids))
+	var failed EntityErrors
+	for i, entity := range entities {
+		if errs[i] != nil {
+			failed = append(failed, &EntityError{ID: ids[i], Err: errs[i]})
+			continue
+		}
+		fetched = append(fetched, entity)
+	}
+	if len(failed) > 0 {
+		return fetched, failed
+	}
+	return fetched, nil
+}
+
+// Reports whether an entity is one of Wit's builtin entities
+func builtinEntity(id string) bool {
+	return strings.HasPrefix(id, "wit$")
+}
+
 // Entity lists a single configured entity (https://wit.ai/docs/api#toc_17)
 //
 //		result, err := client.Entity("wit$temperature")
 func (client *Client) Entity(id string) (*Entity, error) {
+	return client.entity(context.Background(), id)
+}
+
+// Fetches an entity until the context is done
+func (client *Client) entity(ctx context.Context, id string) (*Entity, error) {
 	id = url.QueryEscape(id)
-	result, err := get(client.APIBase + "/entities/" + id)
+	result, err := client.processRequestContext(ctx, &HTTPParams{Verb: "GET", Resource: client.APIBase + "/entities/" + id})
 	if err != nil {
 		return nil, err
 	}
@@ -153,20 +293,36 @@ func (client *Client) Entity(id string) (*Entity, error) {
 //		result, err := client.UpdateEntity(entity)
 func (client *Client) UpdateEntity(entity *Entity) ([]byte, error) {
 	data, err := json.Marshal(entity)
-	result, err := put(client.APIBase+"/entities/"+entity.ID, data)
+	result, err := client.put(client.APIBase+"/entities/"+entity.ID, data)
 	if err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
-// Parses the Entities JSON
+// Parses the Entities JSON, a list of ids or, in newer API versions, a
+// list of objects with an id and a name
 func parseEntities(data []byte) (*Entities, error) {
 	entities := &Entities{}
 	err := json.Unmarshal(data, entities)
-	if err != nil {
+	if err == nil {
+		return entities, nil
+	}
+	objects := []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}{}
+	if json.Unmarshal(data, &objects) != nil {
 		return nil, err
 	}
+	*entities = Entities{}
+	for _, object := range objects {
+		if object.Name != "" {
+			*entities = append(*entities, object.Name)
+		} else {
+			*entities = append(*entities, object.ID)
+		}
+	}
 	return entities, nil
 }
 
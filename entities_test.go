@@ -4,9 +4,14 @@
 package wit
 
 import (
+	"context"
 	"math/rand"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -264,3 +269,78 @@ func TestDeleteEntity(t *testing.T) {
 		return
 	}
 }
+
+func TestParseEntityObjects(t *testing.T) {
+	entities, err := parseEntities([]byte(`[{"id":"1","name":"favorite_city"},{"id":"wit$datetime"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*entities) != 2 || (*entities)[0] != "favorite_city" || (*entities)[1] != "wit$datetime" {
+		t.Errorf("unexpected entities %v", *entities)
+	}
+	if _, err := parseEntities([]byte(`{"error":"unauthorized"}`)); err == nil {
+		t.Error("expected an object to fail")
+	}
+}
+
+func TestEntitiesDetailed(t *testing.T) {
+	for _, listing := range []string{
+		`["wit$datetime","wit$location","favorite_city","favorite_food","broken_city"]`,
+		`[{"id":"1","name":"wit$datetime"},{"id":"2","name":"wit$location"},{"id":"3","name":"favorite_city"},{"id":"4","name":"favorite_food"},{"id":"5","name":"broken_city"}]`,
+	} {
+		var inFlight, maxInFlight int64
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/entities" {
+				w.Write([]byte(listing))
+				return
+			}
+			current := atomic.AddInt64(&inFlight, 1)
+			defer atomic.AddInt64(&inFlight, -1)
+			for {
+				max := atomic.LoadInt64(&maxInFlight)
+				if current <= max || atomic.CompareAndSwapInt64(&maxInFlight, max, current) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			id := strings.TrimPrefix(r.URL.Path, "/entities/")
+			if id == "broken_city" {
+				http.Error(w, "boom", http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte(`{"id":"` + id + `","builtin":` + strconv.FormatBool(strings.HasPrefix(id, "wit$")) + `,"values":[{"value":"` + id + `-value"}]}`))
+		}))
+		client := NewClient("token")
+		client.APIBase = server.URL
+
+		entities, err := client.EntitiesDetailed(context.Background(), &EntitiesOptions{Concurrency: 2})
+		errs, ok := err.(EntityErrors)
+		if !ok || len(errs) != 1 || errs[0].ID != "broken_city" {
+			t.Errorf("expected broken_city to fail, got %v", err)
+		}
+		if len(entities) != 4 || entities[2].ID != "favorite_city" || entities[2].Values[0].Value != "favorite_city-value" {
+			t.Errorf("unexpected entities %+v", entities)
+		}
+		if maxInFlight > 2 {
+			t.Errorf("expected at most 2 requests in flight, got %d", maxInFlight)
+		}
+
+		entities, err = client.EntitiesDetailed(context.Background(), &EntitiesOptions{Kind: EntityKindCustom, Pattern: "favorite_*"})
+		if err != nil || len(entities) != 2 || entities[0].ID != "favorite_city" || entities[1].ID != "favorite_food" {
+			t.Errorf("unexpected custom entities %+v, %v", entities, err)
+		}
+		entities, err = client.EntitiesDetailed(context.Background(), &EntitiesOptions{Kind: EntityKindBuiltin})
+		if err != nil || len(entities) != 2 || !entities[0].Builtin {
+			t.Errorf("unexpected builtin entities %+v, %v", entities, err)
+		}
+		server.Close()
+	}
+
+	client := NewClient("token")
+	if _, err := client.EntitiesDetailed(context.Background(), &EntitiesOptions{Kind: "other"}); err == nil {
+		t.Error("expected an unknown kind to fail")
+	}
+	if _, err := client.EntitiesDetailed(context.Background(), &EntitiesOptions{Pattern: "["}); err == nil {
+		t.Error("expected an invalid pattern to fail")
+	}
+}
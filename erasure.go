@@ -0,0 +1,323 @@
+// Copyright (c) 2014 Jason Goecke
+// erasure.go
+
+package wit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionUserKey is the session data key holding the user a session
+// belongs to, so a user's sessions can be erased together
+const SessionUserKey = "user_id"
+
+// Eraser is implemented by stores holding user data. Erase removes, or
+// tombstones, everything about a subject, a user or session identifier,
+// and returns the number of records erased.
+type Eraser interface {
+	Erase(ctx context.Context, subject string) (int, error)
+}
+
+// ErasureVerifier is implemented by erasers that can check they no longer
+// hold data about a subject
+type ErasureVerifier interface {
+	Holds(ctx context.Context, subject string) (bool, error)
+}
+
+// ErasureFailure represents a record a store could not read or erase
+type ErasureFailure struct {
+	Record string `json:"record"`
+	Error  string `json:"error"`
+}
+
+// PartialErasureError is returned by erasers that erased the records they
+// could and failed on others
+type PartialErasureError struct {
+	Failures []ErasureFailure
+}
+
+func (err *PartialErasureError) Error() string {
+	if len(err.Failures) == 1 {
+		return fmt.Sprintf("erasure failed for %s: %s", err.Failures[0].Record, err.Failures[0].Error)
+	}
+	return fmt.Sprintf("erasure failed for %d records, first %s: %s", len(err.Failures), err.Failures[0].Record, err.Failures[0].Error)
+}
+
+// ErasureStoreReport represents the erasure of a subject from one store
+type ErasureStoreReport struct {
+	Store  string `json:"store"`
+	Erased int    `json:"erased"`
+	// Verified reports whether the store was checked to hold nothing more
+	// about the subject after erasure
+	Verified bool   `json:"verified"`
+	Error    string `json:"error,omitempty"`
+	// Failures are the records the store could not read or erase, from a
+	// PartialErasureError
+	Failures []ErasureFailure `json:"failures,omitempty"`
+}
+
+// ErasureReport represents the erasure of a subject across stores. The
+// subject is identified by its SHA-256 hash, so the report can be kept as
+// evidence without keeping the identifier, and it can be signed.
+type ErasureReport struct {
+	SubjectHash string               `json:"subject_hash"`
+	StartedAt   time.Time            `json:"started_at"`
+	CompletedAt time.Time            `json:"completed_at"`
+	Stores      []ErasureStoreReport `json:"stores"`
+	Complete    bool                 `json:"complete"`
+	Signature   string               `json:"signature,omitempty"`
+}
+
+// Erasure erases subjects from every registered store
+type Erasure struct {
+	mutex  sync.Mutex
+	names  []string
+	stores map[string]Eraser
+}
+
+// NewErasure creates an erasure with no stores
+//
+//		erasure := wit.NewErasure()
+//		erasure.Register("sessions", store)
+func NewErasure() *Erasure {
+	return &Erasure{stores: map[string]Eraser{}}
+}
+
+// Register adds a store to erase subjects from
+//
+//		erasure.Register("sessions", sessionStore)
+func (erasure *Erasure) Register(name string, eraser Eraser) {
+	erasure.mutex.Lock()
+	defer erasure.mutex.Unlock()
+	if erasure.stores == nil {
+		erasure.stores = map[string]Eraser{}
+	}
+	if _, ok := erasure.stores[name]; !ok {
+		erasure.names = append(erasure.names, name)
+	}
+	erasure.stores[name] = eraser
+}
+
+// Erase erases the subject from every store, verifying stores that support
+// it. A store failing does not stop the others, the report is then
+// incomplete and an error is returned along with it.
+//
+//		report, err := erasure.Erase(ctx, "user-42")
+//		report.Sign(key)
+func (erasure *Erasure) Erase(ctx context.Context, subject string) (*ErasureReport, error) {
+	if subject == "" {
+		return nil, errors.New("an erasure subject is required")
+	}
+	erasure.mutex.Lock()
+	names := append([]string(nil), erasure.names...)
+	stores := make(map[string]Eraser, len(erasure.stores))
+	for name, store := range erasure.stores {
+		stores[name] = store
+	}
+	erasure.mutex.Unlock()
+
+	report := &ErasureReport{SubjectHash: HashSubject(subject), StartedAt: time.Now().UTC(), Complete: true}
+	var failed []string
+	for _, name := range names {
+		entry := ErasureStoreReport{Store: name}
+		var err error
+		entry.Erased, err = stores[name].Erase(ctx, subject)
+		if err == nil {
+			if verifier, ok := stores[name].(ErasureVerifier); ok {
+				var holds bool
+				if holds, err = verifier.Holds(ctx, subject); err == nil && holds {
+					err = errors.New("data remains after erasure")
+				}
+				entry.Verified = err == nil
+			}
+		}
+		if err != nil {
+			entry.Error = err.Error()
+			var partial *PartialErasureError
+			if errors.As(err, &partial) {
+				entry.Failures = partial.Failures
+			}
+			report.Complete = false
+			failed = append(failed, name)
+		}
+		report.Stores = append(report.Stores, entry)
+	}
+	report.CompletedAt = time.Now().UTC()
+	if len(failed) > 0 {
+		return report, errors.New("erasure failed for " + strings.Join(failed, ", "))
+	}
+	return report, nil
+}
+
+// HashSubject returns the hex SHA-256 hash identifying a subject in reports
+//
+//		hash := wit.HashSubject("user-42")
+func HashSubject(subject string) string {
+	sum := sha256.Sum256([]byte(subject))
+	return hex.EncodeToString(sum[:])
+}
+
+// Sign sets the report's signature, an HMAC-SHA256 of the report
+//
+//		err := report.Sign(key)
+func (report *ErasureReport) Sign(key []byte) error {
+	signature, err := report.signature(key)
+	if err != nil {
+		return err
+	}
+	report.Signature = signature
+	return nil
+}
+
+// VerifySignature reports whether the report was signed with the key and
+// not changed since
+//
+//		ok := report.VerifySignature(key)
+func (report *ErasureReport) VerifySignature(key []byte) bool {
+	signature, err := report.signature(key)
+	if err != nil || report.Signature == "" {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(report.Signature))
+}
+
+func (report *ErasureReport) signature(key []byte) (string, error) {
+	unsigned := *report
+	unsigned.Signature = ""
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Reports whether a session is about the subject, by id or user
+func sessionAbout(session *Session, subject string) bool {
+	if session.ID == subject {
+		return true
+	}
+	user, ok := session.Data[SessionUserKey].(string)
+	return ok && user == subject
+}
+
+// Erase deletes the sessions with the subject as id or user
+//
+//		count, err := store.Erase(ctx, "user-42")
+func (store *MemorySessionStore) Erase(ctx context.Context, subject string) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	count := 0
+	for id, session := range store.sessions {
+		if sessionAbout(session, subject) {
+			delete(store.sessions, id)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Holds reports whether a session with the subject as id or user remains
+//
+//		holds, err := store.Holds(ctx, "user-42")
+func (store *MemorySessionStore) Holds(ctx context.Context, subject string) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, session := range store.sessions {
+		if sessionAbout(session, subject) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Erase deletes the sessions with the subject as id or user, reading every
+// session file to find those of the user. Files that cannot be read or
+// removed are returned in a PartialErasureError, after erasing the others.
+//
+//		count, err := store.Erase(ctx, "user-42")
+func (store *FileSessionStore) Erase(ctx context.Context, subject string) (int, error) {
+	paths, err := store.find(ctx, subject)
+	partial, ok := err.(*PartialErasureError)
+	if err != nil && !ok {
+		return 0, err
+	}
+	if partial == nil {
+		partial = &PartialErasureError{}
+	}
+	count := 0
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			partial.Failures = append(partial.Failures, ErasureFailure{Record: filepath.Base(path), Error: err.Error()})
+			continue
+		}
+		count++
+	}
+	if len(partial.Failures) > 0 {
+		return count, partial
+	}
+	return count, nil
+}
+
+// Holds reports whether a session with the subject as id or user remains
+//
+//		holds, err := store.Holds(ctx, "user-42")
+func (store *FileSessionStore) Holds(ctx context.Context, subject string) (bool, error) {
+	paths, err := store.find(ctx, subject)
+	return len(paths) > 0, err
+}
+
+// Returns the paths of the session files about the subject, and the files
+// that could not be read in a PartialErasureError
+func (store *FileSessionStore) find(ctx context.Context, subject string) ([]string, error) {
+	files, err := ioutil.ReadDir(store.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	partial := &PartialErasureError{}
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".session") {
+			continue
+		}
+		path := filepath.Join(store.Dir, file.Name())
+		data, err := store.read(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		session := &Session{}
+		if err == nil {
+			err = json.Unmarshal(data, session)
+		}
+		if err != nil {
+			partial.Failures = append(partial.Failures, ErasureFailure{Record: file.Name(), Error: err.Error()})
+			continue
+		}
+		if sessionAbout(session, subject) {
+			paths = append(paths, path)
+		}
+	}
+	if len(partial.Failures) > 0 {
+		return paths, partial
+	}
+	return paths, nil
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2014 Jason Goecke
+// erasure_test.go
+
+package wit
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type failingEraser struct{}
+
+func (failingEraser) Erase(ctx context.Context, subject string) (int, error) {
+	return 0, errors.New("store unavailable")
+}
+
+func TestErasure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "erasure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	memory := NewMemorySessionStore(0)
+	files := NewFileSessionStore(dir, testKeyring(t, "k1"))
+	for _, store := range []SessionStore{memory, files} {
+		for _, id := range []string{"user-42", "web-abc", "user-7"} {
+			session := NewSession(id)
+			if id == "web-abc" {
+				session.Set(SessionUserKey, "user-42")
+			}
+			if err := store.Save(session); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	erasure := NewErasure()
+	erasure.Register("memory_sessions", memory)
+	erasure.Register("file_sessions", files)
+	report, err := erasure.Erase(context.Background(), "user-42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Complete || len(report.Stores) != 2 || report.SubjectHash != HashSubject("user-42") {
+		t.Errorf("unexpected report %+v", report)
+	}
+	for _, store := range report.Stores {
+		if store.Erased != 2 || !store.Verified {
+			t.Errorf("expected 2 verified erasures in %+v", store)
+		}
+	}
+	for _, store := range []SessionStore{memory, files} {
+		if session, _ := store.Load("user-7"); session.UpdatedAt.IsZero() {
+			t.Error("another user's session was erased")
+		}
+	}
+
+	key := []byte("report signing key")
+	if err := report.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	if !report.VerifySignature(key) || report.VerifySignature([]byte("other key")) {
+		t.Error("signature not verified properly")
+	}
+	report.Stores[0].Erased = 5
+	if report.VerifySignature(key) {
+		t.Error("a changed report should not verify")
+	}
+
+	erasure.Register("broken", failingEraser{})
+	report, err = erasure.Erase(context.Background(), "user-7")
+	if err == nil || report.Complete || report.Stores[2].Error != "store unavailable" || report.Stores[0].Erased != 1 {
+		t.Errorf("expected an incomplete report %+v %v", report, err)
+	}
+}
+
+func TestFileSessionErasureFailures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "erasure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := NewFileSessionStore(dir, testKeyring(t, "k1"))
+	for _, id := range []string{"user-42", "web-abc"} {
+		session := NewSession(id)
+		session.Set(SessionUserKey, "user-42")
+		if err := store.Save(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "planted.session"), []byte(`{"id": "user-42"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	erasure := NewErasure()
+	erasure.Register("file_sessions", store)
+	report, err := erasure.Erase(context.Background(), "user-42")
+	if err == nil || report.Complete {
+		t.Errorf("expected an incomplete report %+v %v", report, err)
+	}
+	entry := report.Stores[0]
+	if entry.Erased != 2 || len(entry.Failures) != 1 || entry.Failures[0].Record != "planted.session" ||
+		!strings.Contains(entry.Failures[0].Error, ErrNotEncrypted.Error()) {
+		t.Errorf("expected the readable sessions erased and the planted one reported %+v", entry)
+	}
+	if session, _ := store.Load("web-abc"); !session.UpdatedAt.IsZero() {
+		t.Error("session not erased")
+	}
+	key := []byte("report signing key")
+	report.Sign(key)
+	report.Stores[0].Failures = nil
+	if report.VerifySignature(key) {
+		t.Error("the failures should be signed")
+	}
+}
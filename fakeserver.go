@@ -0,0 +1,114 @@
+// Copyright (c) 2014 Jason Goecke
+// fakeserver.go
+
+package wit
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// FakeServer is a local stand-in for the Wit API, answering message and
+// speech requests with canned outcomes. It is meant for tests and for
+// profiling a pipeline without spending Wit quota.
+type FakeServer struct {
+	URL string
+	// Latency is added to every response
+	Latency time.Duration
+	// Intent is the intent of every outcome, "fake" when empty
+	Intent string
+	// Transcript is the text returned for speech requests, "hello world" when empty
+	Transcript string
+
+	listener net.Listener
+	server   *http.Server
+	requests int64
+}
+
+// StartFakeServer starts a fake Wit API listening on addr, use
+// "127.0.0.1:0" for any free port
+//
+//		fake, err := wit.StartFakeServer("127.0.0.1:0")
+//		client := wit.NewClient("fake-token")
+//		client.APIBase = fake.URL
+func StartFakeServer(addr string) (*FakeServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	fake := &FakeServer{URL: "http://" + listener.Addr().String(), listener: listener}
+	fake.server = &http.Server{Handler: fake}
+	go fake.server.Serve(listener)
+	return fake, nil
+}
+
+// Close stops the fake server
+//
+//		fake.Close()
+func (fake *FakeServer) Close() error {
+	return fake.server.Close()
+}
+
+// Requests returns the number of requests the fake server has answered
+//
+//		count := fake.Requests()
+func (fake *FakeServer) Requests() int64 {
+	return atomic.LoadInt64(&fake.requests)
+}
+
+// ServeHTTP answers a request to the fake API
+func (fake *FakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&fake.requests, 1)
+	if fake.Latency > 0 {
+		time.Sleep(fake.Latency)
+	}
+	if r.Header.Get("Authorization") == "" {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var text string
+	switch r.URL.Path {
+	case "/message":
+		text = r.URL.Query().Get("q")
+	case "/speech":
+		io.Copy(ioutil.Discard, r.Body)
+		text = fake.Transcript
+		if text == "" {
+			text = "hello world"
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	intent := fake.Intent
+	if intent == "" {
+		intent = "fake"
+	}
+	// Like Wit, echo the msg_id and thread_id sent
+	msgID := r.URL.Query().Get("msg_id")
+	if msgID == "" {
+		msgID = "fake-" + strconv.FormatInt(atomic.LoadInt64(&fake.requests), 10)
+	}
+	message := &Message{
+		MsgID:    msgID,
+		ThreadID: r.URL.Query().Get("thread_id"),
+		Text:     text,
+		Outcomes: []Outcome{{
+			Text:       text,
+			Intent:     intent,
+			IntentId:   "fake-intent",
+			Entities:   map[string][]MessageEntity{},
+			Confidence: 1,
+		}},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(message)
+}
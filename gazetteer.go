@@ -0,0 +1,323 @@
+// Copyright (c) 2014 Jason Goecke
+// gazetteer.go
+
+package wit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAmbiguityRatio is the population ratio between the two best
+// candidates above which a resolution is flagged as ambiguous
+const DefaultAmbiguityRatio = 0.5
+
+// ErrLocationNotFound is returned when a location entity does not match any
+// place in the gazetteer
+var ErrLocationNotFound = errors.New("location not found")
+
+// Location represents a place in a GeoNames-style gazetteer (http://www.geonames.org/export/)
+type Location struct {
+	GeonameID      int64    `json:"geoname_id"`
+	Name           string   `json:"name"`
+	ASCIIName      string   `json:"ascii_name,omitempty"`
+	AlternateNames []string `json:"alternate_names,omitempty"`
+	Latitude       float64  `json:"latitude"`
+	Longitude      float64  `json:"longitude"`
+	FeatureClass   string   `json:"feature_class"`
+	FeatureCode    string   `json:"feature_code"`
+	CountryCode    string   `json:"country_code"`
+	Admin1Code     string   `json:"admin1_code,omitempty"`
+	Population     int64    `json:"population"`
+	Timezone       string   `json:"timezone,omitempty"`
+}
+
+// LocationResolution represents the resolution of a location entity
+type LocationResolution struct {
+	Entity       MessageEntity `json:"entity"`
+	Location     *Location     `json:"location"`
+	Alternatives []*Location   `json:"alternatives,omitempty"`
+	Ambiguous    bool          `json:"ambiguous"`
+}
+
+// ContextLocation represents the location portion of a message request context
+type ContextLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Gazetteer resolves place names to locations
+type Gazetteer struct {
+	// AmbiguityRatio overrides DefaultAmbiguityRatio when set
+	AmbiguityRatio float64
+
+	names map[string][]*Location
+}
+
+// NewGazetteer creates an empty gazetteer
+//
+//		gazetteer := wit.NewGazetteer()
+func NewGazetteer() *Gazetteer {
+	return &Gazetteer{names: map[string][]*Location{}}
+}
+
+// LoadGazetteer loads a gazetteer from a GeoNames dump file, such as
+// cities15000.txt or allCountries.txt
+//
+//		gazetteer, err := wit.LoadGazetteer("./cities15000.txt")
+func LoadGazetteer(path string) (*Gazetteer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	gazetteer := NewGazetteer()
+	if err := gazetteer.Read(file); err != nil {
+		return nil, err
+	}
+	return gazetteer, nil
+}
+
+// Read adds the locations of a GeoNames tab-separated dump to the gazetteer
+//
+//		err := gazetteer.Read(file)
+func (gazetteer *Gazetteer) Read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		location, err := parseGeonamesRecord(text)
+		if err != nil {
+			return fmt.Errorf("gazetteer line %d: %s", line, err)
+		}
+		gazetteer.Add(location)
+	}
+	return scanner.Err()
+}
+
+// Add adds a location to the gazetteer
+//
+//		gazetteer.Add(&wit.Location{Name: "Paris", CountryCode: "FR", Population: 2138551})
+func (gazetteer *Gazetteer) Add(location *Location) {
+	seen := map[string]bool{}
+	for _, name := range append([]string{location.Name, location.ASCIIName}, location.AlternateNames...) {
+		key := normalizePlaceName(name)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		gazetteer.names[key] = append(gazetteer.names[key], location)
+	}
+}
+
+// Lookup returns the locations matching a place name, best candidates first.
+// A trailing qualifier such as "Paris, TX", "Paris, France" or "Portland
+// OR" narrows the candidates down to a country or first-level
+// administrative division, given by code or by name. Division names, as
+// in "Paris, Texas", need the division's ADM1 record in the gazetteer,
+// which allCountries.txt has and cities15000.txt does not.
+//
+//		locations := gazetteer.Lookup("Paris, France")
+func (gazetteer *Gazetteer) Lookup(name string) []*Location {
+	key := normalizePlaceName(name)
+	if candidates := gazetteer.names[key]; len(candidates) > 0 {
+		return rankLocations(candidates)
+	}
+
+	// Try splitting off a qualifier, at a comma first and then at each word
+	var splits [][2]string
+	if i := strings.LastIndex(name, ","); i > 0 {
+		splits = append(splits, [2]string{name[:i], name[i+1:]})
+	}
+	words := strings.Fields(key)
+	for i := len(words) - 1; i > 0; i-- {
+		splits = append(splits, [2]string{strings.Join(words[:i], " "), strings.Join(words[i:], " ")})
+	}
+	for _, split := range splits {
+		candidates := gazetteer.names[normalizePlaceName(split[0])]
+		if len(candidates) == 0 {
+			continue
+		}
+		qualified := gazetteer.qualify(candidates, split[1])
+		if len(qualified) > 0 {
+			return rankLocations(qualified)
+		}
+	}
+	return nil
+}
+
+// Resolve resolves a location entity from a Message to a place in the
+// gazetteer, using the entity's value or, failing that, its body
+//
+//		resolution, err := gazetteer.Resolve(message.Outcomes[0].Entities["location"][0])
+func (gazetteer *Gazetteer) Resolve(entity MessageEntity) (*LocationResolution, error) {
+	candidates := gazetteer.Lookup(entityText(entity))
+	if len(candidates) == 0 {
+		return nil, ErrLocationNotFound
+	}
+	resolution := &LocationResolution{
+		Entity:       entity,
+		Location:     candidates[0],
+		Alternatives: candidates[1:],
+	}
+	if len(candidates) > 1 {
+		ratio := gazetteer.AmbiguityRatio
+		if ratio == 0 {
+			ratio = DefaultAmbiguityRatio
+		}
+		best, next := candidates[0].Population, candidates[1].Population
+		resolution.Ambiguous = best == 0 || float64(next) >= ratio*float64(best)
+	}
+	return resolution, nil
+}
+
+// ResolveAll resolves every location entity of an outcome, skipping those
+// that cannot be found
+//
+//		resolutions := gazetteer.ResolveAll(&message.Outcomes[0])
+func (gazetteer *Gazetteer) ResolveAll(outcome *Outcome) []*LocationResolution {
+	var resolutions []*LocationResolution
+	for _, entity := range outcome.Entities["location"] {
+		if resolution, err := gazetteer.Resolve(entity); err == nil {
+			resolutions = append(resolutions, resolution)
+		}
+	}
+	return resolutions
+}
+
+// Context returns a message request context for the location, with the
+// reference time expressed in the location's timezone so that datetimes are
+// resolved locally
+//
+//		context, err := resolution.Location.Context(time.Now())
+func (location *Location) Context(referenceTime time.Time) (*Context, error) {
+	context := &Context{
+		Location: &ContextLocation{Latitude: location.Latitude, Longitude: location.Longitude},
+	}
+	if location.Timezone != "" {
+		loc, err := time.LoadLocation(location.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		referenceTime = referenceTime.In(loc)
+		context.Timezone = location.Timezone
+	}
+	context.ReferenceTime = referenceTime.Format(time.RFC3339)
+	return context, nil
+}
+
+// Keeps the candidates matching a qualifier, which may be a country code, a
+// country name or a first-level administrative division code or name
+func (gazetteer *Gazetteer) qualify(candidates []*Location, qualifier string) []*Location {
+	qualifier = normalizePlaceName(qualifier)
+	var qualified []*Location
+	for _, candidate := range candidates {
+		if strings.ToLower(candidate.CountryCode) == qualifier ||
+			strings.ToLower(candidate.Admin1Code) == qualifier {
+			qualified = append(qualified, candidate)
+			continue
+		}
+		for _, area := range gazetteer.names[qualifier] {
+			if area.CountryCode != candidate.CountryCode {
+				continue
+			}
+			if area.isCountry() || (area.isAdmin1() && area.Admin1Code == candidate.Admin1Code) {
+				qualified = append(qualified, candidate)
+				break
+			}
+		}
+	}
+	return qualified
+}
+
+// Reports whether the location is a country
+func (location *Location) isCountry() bool {
+	return location.FeatureClass == "A" && strings.HasPrefix(location.FeatureCode, "PCL")
+}
+
+// Reports whether the location is a first-level administrative division
+func (location *Location) isAdmin1() bool {
+	return location.FeatureClass == "A" && location.FeatureCode == "ADM1" && location.Admin1Code != ""
+}
+
+// Ranks locations by population, preferring countries and populated places
+func rankLocations(locations []*Location) []*Location {
+	ranked := append([]*Location(nil), locations...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if pi, pj := placeRank(ranked[i]), placeRank(ranked[j]); pi != pj {
+			return pi < pj
+		}
+		return ranked[i].Population > ranked[j].Population
+	})
+	return ranked
+}
+
+// Returns the rank of a feature class, lower is better
+func placeRank(location *Location) int {
+	switch {
+	case location.isCountry():
+		return 0
+	case location.FeatureClass == "P":
+		return 1
+	case location.FeatureClass == "A":
+		return 2
+	}
+	return 3
+}
+
+// Normalizes a place name for lookups
+func normalizePlaceName(name string) string {
+	name = strings.ToLower(name)
+	name = strings.NewReplacer(".", " ", ",", " ", "'", "", "’", "").Replace(name)
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// Parses a record of the GeoNames main table
+func parseGeonamesRecord(text string) (*Location, error) {
+	fields := strings.Split(text, "\t")
+	if len(fields) < 18 {
+		return nil, fmt.Errorf("expected at least 18 fields, got %d", len(fields))
+	}
+	id, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	latitude, err := strconv.ParseFloat(fields[4], 64)
+	if err != nil {
+		return nil, err
+	}
+	longitude, err := strconv.ParseFloat(fields[5], 64)
+	if err != nil {
+		return nil, err
+	}
+	population, _ := strconv.ParseInt(fields[14], 10, 64)
+	location := &Location{
+		GeonameID:    id,
+		Name:         fields[1],
+		ASCIIName:    fields[2],
+		Latitude:     latitude,
+		Longitude:    longitude,
+		FeatureClass: fields[6],
+		FeatureCode:  fields[7],
+		CountryCode:  fields[8],
+		Admin1Code:   fields[10],
+		Population:   population,
+		Timezone:     fields[17],
+	}
+	if fields[3] != "" {
+		location.AlternateNames = strings.Split(fields[3], ",")
+	}
+	return location, nil
+}
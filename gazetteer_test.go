@@ -0,0 +1,119 @@
+// Copyright (c) 2014 Jason Goecke
+// gazetteer_test.go
+
+package wit
+
+import (
+	"testing"
+	"time"
+)
+
+func locationEntity(body string) MessageEntity {
+	var value interface{} = body
+	return MessageEntity{Body: &body, Value: &value}
+}
+
+func TestLoadGazetteer(t *testing.T) {
+	gazetteer, err := LoadGazetteer("./testdata/gazetteer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		body        string
+		geonameID   int64
+		countryCode string
+		timezone    string
+		ambiguous   bool
+	}{
+		{"Paris", 2988507, "FR", "Europe/Paris", false},
+		{"Paris, TX", 4717560, "US", "America/Chicago", false},
+		{"paris texas", 0, "", "", false}, // the file has no ADM1 record for Texas
+		{"Paris France", 2988507, "FR", "Europe/Paris", false},
+		{"Paris, United States", 4717560, "US", "America/Chicago", false},
+		{"パリ", 2988507, "FR", "Europe/Paris", false},
+		{"Springfield", 4409896, "US", "America/Chicago", true},
+		{"Springfield IL", 4250542, "US", "America/Chicago", false},
+		{"France", 3017382, "FR", "Europe/Paris", false},
+		{"Tokio", 1850147, "JP", "Asia/Tokyo", false},
+	}
+	for _, test := range tests {
+		resolution, err := gazetteer.Resolve(locationEntity(test.body))
+		if test.geonameID == 0 {
+			if err != ErrLocationNotFound {
+				t.Errorf("%q: expected ErrLocationNotFound, got %v", test.body, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.body, err)
+			continue
+		}
+		location := resolution.Location
+		if location.GeonameID != test.geonameID || location.CountryCode != test.countryCode ||
+			location.Timezone != test.timezone {
+			t.Errorf("%q: resolved to %+v", test.body, location)
+		}
+		if resolution.Ambiguous != test.ambiguous {
+			t.Errorf("%q: expected ambiguous to be %v", test.body, test.ambiguous)
+		}
+	}
+}
+
+func TestGazetteerAdmin1Names(t *testing.T) {
+	gazetteer, err := LoadGazetteer("./testdata/gazetteer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gazetteer.Add(&Location{GeonameID: 4736286, Name: "Texas", FeatureClass: "A", FeatureCode: "ADM1", CountryCode: "US", Admin1Code: "TX", Population: 22875689})
+	gazetteer.Add(&Location{GeonameID: 4896861, Name: "Illinois", FeatureClass: "A", FeatureCode: "ADM1", CountryCode: "US", Admin1Code: "IL", Population: 12895129})
+
+	tests := []struct {
+		body      string
+		geonameID int64
+	}{
+		{"paris texas", 4717560},
+		{"Paris, Illinois", 4402452},
+		{"Springfield, Illinois", 4250542},
+	}
+	for _, test := range tests {
+		resolution, err := gazetteer.Resolve(locationEntity(test.body))
+		if err != nil {
+			t.Errorf("%q: %s", test.body, err)
+			continue
+		}
+		if resolution.Location.GeonameID != test.geonameID || len(resolution.Alternatives) != 0 {
+			t.Errorf("%q: resolved to %+v, alternatives %v", test.body, resolution.Location, resolution.Alternatives)
+		}
+	}
+	if _, err := gazetteer.Resolve(locationEntity("Springfield, Texas")); err != ErrLocationNotFound {
+		t.Errorf("expected ErrLocationNotFound, got %v", err)
+	}
+}
+
+func TestLocationContext(t *testing.T) {
+	gazetteer := NewGazetteer()
+	gazetteer.Add(&Location{Name: "Tokyo", Latitude: 35.6895, Longitude: 139.69171, CountryCode: "JP", Timezone: "Asia/Tokyo"})
+	resolution, err := gazetteer.Resolve(locationEntity("tokyo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	context, err := resolution.Location.Context(time.Date(2015, 12, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if context.Timezone != "Asia/Tokyo" || context.ReferenceTime != "2015-12-01T09:00:00+09:00" {
+		t.Errorf("context not set properly: %+v", context)
+	}
+	if context.Location.Latitude != 35.6895 {
+		t.Error("context location not set properly")
+	}
+
+	entities, err := ParseDatetimes("tomorrow", context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := (*entities[0].Value).(string); value != "2015-12-02T00:00:00.000+09:00" {
+		t.Errorf("not equal %s != %s", "2015-12-02T00:00:00.000+09:00", value)
+	}
+}
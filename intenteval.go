@@ -0,0 +1,138 @@
+// Copyright (c) 2014 Jason Goecke
+// intenteval.go
+
+package wit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LabeledUtterance represents a text with the intent it is expected to match
+type LabeledUtterance struct {
+	Text   string `json:"text"`
+	Intent string `json:"intent"`
+}
+
+// IntentResult represents the classification of a single utterance
+type IntentResult struct {
+	Utterance  LabeledUtterance `json:"utterance"`
+	Predicted  string           `json:"predicted"`
+	Confidence float32          `json:"confidence"`
+	Correct    bool             `json:"correct"`
+	Err        error            `json:"-"`
+}
+
+// IntentStats represents the accuracy over a group of utterances, leaving
+// out those that failed to classify
+type IntentStats struct {
+	Total    int     `json:"total"`
+	Correct  int     `json:"correct"`
+	Accuracy float64 `json:"accuracy"`
+}
+
+// IntentReport represents the evaluation of a set of labeled utterances
+type IntentReport struct {
+	Results   []IntentResult          `json:"results"`
+	Failures  int                     `json:"failures"`
+	Overall   IntentStats             `json:"overall"`
+	PerIntent map[string]*IntentStats `json:"per_intent"`
+}
+
+// IntentEvaluator runs labeled utterances through the message API and
+// scores the top outcome's intent
+type IntentEvaluator struct {
+	Client      *Client
+	Concurrency int
+	// Classify replaces Client.Message when set
+	Classify func(request *MessageRequest) (*Message, error)
+}
+
+// LoadUtterances reads labeled utterances, one JSON object per line
+//
+//		utterances, err := wit.LoadUtterances("./utterances.jsonl")
+func LoadUtterances(path string) ([]LabeledUtterance, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var utterances []LabeledUtterance
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		utterance := LabeledUtterance{}
+		if err := json.Unmarshal([]byte(text), &utterance); err != nil {
+			return nil, fmt.Errorf("utterances line %d: %s", line, err)
+		}
+		utterances = append(utterances, utterance)
+	}
+	return utterances, scanner.Err()
+}
+
+// Evaluate classifies the utterances concurrently and scores them
+//
+//		evaluator := &wit.IntentEvaluator{Client: client, Concurrency: 8}
+//		report := evaluator.Evaluate(utterances)
+//		log.Printf("accuracy %.3f", report.Overall.Accuracy)
+func (evaluator *IntentEvaluator) Evaluate(utterances []LabeledUtterance) *IntentReport {
+	results := evaluator.classifyAll(utterances)
+	report := &IntentReport{Results: results, PerIntent: map[string]*IntentStats{}}
+	for _, result := range results {
+		if result.Err != nil {
+			report.Failures++
+			continue
+		}
+		stats, ok := report.PerIntent[result.Utterance.Intent]
+		if !ok {
+			stats = &IntentStats{}
+			report.PerIntent[result.Utterance.Intent] = stats
+		}
+		stats.add(result.Correct)
+		report.Overall.add(result.Correct)
+	}
+	return report
+}
+
+// Classifies the utterances concurrently, in order
+func (evaluator *IntentEvaluator) classifyAll(utterances []LabeledUtterance) []IntentResult {
+	classify := evaluator.Classify
+	if classify == nil {
+		classify = evaluator.Client.Message
+	}
+	results := make([]IntentResult, len(utterances))
+	forEachConcurrently(len(utterances), evaluator.Concurrency, func(i int) {
+		result := IntentResult{Utterance: utterances[i]}
+		message, err := classify(&MessageRequest{Query: utterances[i].Text})
+		if err != nil {
+			result.Err = err
+		} else if message == nil {
+			result.Err = errors.New("no message returned")
+		} else if len(message.Outcomes) > 0 {
+			result.Predicted = message.Outcomes[0].Intent
+			result.Confidence = message.Outcomes[0].Confidence
+			result.Correct = result.Predicted == utterances[i].Intent
+		}
+		results[i] = result
+	})
+	return results
+}
+
+// Counts an utterance and updates the accuracy
+func (stats *IntentStats) add(correct bool) {
+	stats.Total++
+	if correct {
+		stats.Correct++
+	}
+	stats.Accuracy = float64(stats.Correct) / float64(stats.Total)
+}
@@ -7,19 +7,22 @@ import (
 	"encoding/json"
 )
 
-// Intents represents intents in the Wit API (https://wit.ai/docs/api#toc_13)
-type Intents []struct {
+// Intent represents an intent in the Wit API (https://wit.ai/docs/api#toc_13)
+type Intent struct {
 	ID       string `json:"id"`
 	Name     string `json:"name"`
 	Doc      string `json:"doc"`
 	Metadata string `json:"metadata"`
 }
 
+// Intents represents intents in the Wit API (https://wit.ai/docs/api#toc_13)
+type Intents []Intent
+
 // Intents lists intents configured in the Wit API (https://wit.ai/docs/api#toc_13)
 //
 //		result, err := client.Intents()
 func (client *Client) Intents() (*Intents, error) {
-	result, err := get(client.APIBase + "/intents")
+	result, err := client.get(client.APIBase + "/intents")
 	if err != nil {
 		return nil, err
 	}
@@ -4,14 +4,17 @@
 package wit
 
 import (
+	"context"
 	"encoding/json"
 	"net/url"
 	"strconv"
+	"time"
 )
 
 // Message represents a Wit message (https://wit.ai/docs/api#toc_3)
 type Message struct {
 	MsgID    string    `json:"msg_id"`
+	ThreadID string    `json:"thread_id,omitempty"`
 	Text     string    `json:"_text"`
 	Outcomes []Outcome `json:"outcomes"`
 }
@@ -27,18 +30,19 @@ type Outcome struct {
 
 // MessageEntity represents the entity portion of a Wit message
 type MessageEntity struct {
-	Metadata *string              `json:"metadata,omitempty"`
-	Value    *interface{}         `json:"value,omitempty"`
-	Grain    *string              `json:"grain,omitempty"`
-	Type     *string              `json:"type,omitempty"`
-	Unit     *string              `json:"unit,omitempty"`
-	Body     *string              `json:"body,omitempty"`
-	Entity   *string              `json:"entity,omitempty"`
-	Start    *int64               `json:"start,omitempty"`
-	End      *int64               `json:"end,omitempty"`
-	Values   *[]interface{}       `json:"values,omitempty"`
-	From     *DatetimeIntervalEnd `json:"from,omitempty"`
-	To       *DatetimeIntervalEnd `json:"to,omitempty"`
+	Metadata   *string              `json:"metadata,omitempty"`
+	Value      *interface{}         `json:"value,omitempty"`
+	Grain      *string              `json:"grain,omitempty"`
+	Type       *string              `json:"type,omitempty"`
+	Unit       *string              `json:"unit,omitempty"`
+	Body       *string              `json:"body,omitempty"`
+	Entity     *string              `json:"entity,omitempty"`
+	Start      *int64               `json:"start,omitempty"`
+	End        *int64               `json:"end,omitempty"`
+	Values     *[]interface{}       `json:"values,omitempty"`
+	From       *DatetimeIntervalEnd `json:"from,omitempty"`
+	To         *DatetimeIntervalEnd `json:"to,omitempty"`
+	Normalized *NormalizedValue     `json:"normalized,omitempty"`
 }
 
 // DatetimeValue represents the datetime value portion of a Wit message
@@ -57,6 +61,7 @@ type MessageRequest struct {
 	File         string `json:"file,omitempty"`
 	Query        string `json:"query"`
 	MsgID        string `json:"msg_id,omitempty"`
+	ThreadID     string `json:"thread_id,omitempty"`
 	Context      string `json:"context, omitempty"`
 	ContentType  string `json:"contentType, omitempty"`
 	N            int    `json:"n,omitempty"`
@@ -68,15 +73,17 @@ type MessageRequest struct {
 
 // Context represents the context portion of the message request
 type Context struct {
-	ReferenceTime string `json:"reference_time"`
-	Timezone      string `json:"timezone"`
+	ReferenceTime string           `json:"reference_time"`
+	Timezone      string           `json:"timezone"`
+	Locale        string           `json:"locale,omitempty"`
+	Location      *ContextLocation `json:"location,omitempty"`
 }
 
 // Messages lists an already existing message (https://wit.ai/docs/api#toc_11)
 //
 //		result, err := client.Messages("ba0fcf60-44d3-4499-877e-c8d65c239730")
 func (client *Client) Messages(id string) (*Message, error) {
-	result, err := get(client.APIBase + "/messages/" + id)
+	result, err := client.get(client.APIBase + "/messages/" + id)
 	if err != nil {
 		return nil, err
 	}
@@ -88,22 +95,46 @@ func (client *Client) Messages(id string) (*Message, error) {
 //
 //		result, err := client.Message(request)
 func (client *Client) Message(request *MessageRequest) (*Message, error) {
+	return client.MessageContext(context.Background(), request)
+}
+
+// MessageContext requests processing of a text message until the context
+// is done. The message is sent with a msg_id correlating it with the
+// caller's logs, see correlationID, and the thread_id of the request or
+// context.
+//
+//		ctx = wit.WithCorrelationID(ctx, requestID)
+//		result, err := client.MessageContext(ctx, request)
+//		log.Println(result.MsgID)
+func (client *Client) MessageContext(ctx context.Context, request *MessageRequest) (*Message, error) {
+	msgID, threadID := client.correlationID(ctx, request), threadID(ctx, request)
 	query := url.QueryEscape(request.Query)
 	if request.Context != "" {
 		query += "&context=" + request.Context
+	} else if context := client.defaultContext(); context != "" {
+		query += "&context=" + context
 	}
-	if request.MsgID != "" {
-		query += "&msg_id" + request.MsgID
+	query += "&msg_id=" + url.QueryEscape(msgID)
+	if threadID != "" {
+		query += "&thread_id=" + url.QueryEscape(threadID)
 	}
 	if request.N != 0 {
 		query += "&n=" + strconv.Itoa(request.N)
 	}
-	result, err := get(client.APIBase + "/message?q=" + query)
+	if client.AppVersion != "" {
+		query += "&tag=" + url.QueryEscape(client.AppVersion)
+	}
+	start := time.Now()
+	result, err := client.processRequestContext(ctx, &HTTPParams{Verb: "GET", Resource: client.APIBase + "/message?q=" + query})
+	client.logMessage("message", msgID, threadID, start, err)
 	if err != nil {
 		return nil, err
 	}
-	message, _ := parseMessage(result)
-	return message, nil
+	message, err := parseMessage(result)
+	if err != nil {
+		return nil, err
+	}
+	return correlateMessage(message, msgID, threadID), nil
 }
 
 // AudioMessage requests processing of an audio message (https://wit.ai/docs/api#toc_8)
@@ -114,7 +145,30 @@ func (client *Client) Message(request *MessageRequest) (*Message, error) {
 //		request.ContentType = "audio/wav;rate=8000"
 // 		message, err := client.AudioMessage(request)
 func (client *Client) AudioMessage(request *MessageRequest) (*Message, error) {
-	result, err := postFile(client.APIBase+"/speech", request)
+	return client.AudioMessageContext(context.Background(), request)
+}
+
+// AudioMessageContext requests processing of an audio message until the
+// context is done, with a context, msg_id and thread_id as MessageContext does
+//
+//		result, err := client.AudioMessageContext(ctx, request)
+func (client *Client) AudioMessageContext(ctx context.Context, request *MessageRequest) (*Message, error) {
+	msgID, threadID := client.correlationID(ctx, request), threadID(ctx, request)
+	resource := client.APIBase + "/speech?msg_id=" + url.QueryEscape(msgID)
+	if request.Context != "" {
+		resource += "&context=" + request.Context
+	} else if context := client.defaultContext(); context != "" {
+		resource += "&context=" + context
+	}
+	if threadID != "" {
+		resource += "&thread_id=" + url.QueryEscape(threadID)
+	}
+	if client.AppVersion != "" {
+		resource += "&tag=" + url.QueryEscape(client.AppVersion)
+	}
+	start := time.Now()
+	result, err := client.postFile(ctx, resource, request)
+	client.logMessage("speech", msgID, threadID, start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -122,7 +176,52 @@ func (client *Client) AudioMessage(request *MessageRequest) (*Message, error) {
 	if err != nil {
 		return nil, err
 	}
-	return message, nil
+	return correlateMessage(message, msgID, threadID), nil
+}
+
+// Sets the ids a message was sent with when Wit does not echo them
+func correlateMessage(message *Message, msgID string, threadID string) *Message {
+	if message == nil {
+		return nil
+	}
+	if message.MsgID == "" {
+		message.MsgID = msgID
+	}
+	if message.ThreadID == "" {
+		message.ThreadID = threadID
+	}
+	return message
+}
+
+// Logs a call with its ids to the client's logger, if any
+func (client *Client) logMessage(kind string, msgID string, threadID string, start time.Time, err error) {
+	if client.Logger == nil {
+		return
+	}
+	status := "ok"
+	if err != nil {
+		status = strconv.Quote(err.Error())
+	}
+	client.Logger.Printf("wit %s msg_id=%s thread_id=%s duration=%s status=%s", kind, msgID, threadID, time.Since(start).Round(time.Millisecond), status)
+}
+
+// The client's defaults sent as context, leaving out those not set
+type contextDefaults struct {
+	Timezone string `json:"timezone,omitempty"`
+	Locale   string `json:"locale,omitempty"`
+}
+
+// Returns the client's timezone and locale defaults as an escaped JSON
+// context, or an empty string when there are none
+func (client *Client) defaultContext() string {
+	if client.Timezone == "" && client.Locale == "" {
+		return ""
+	}
+	data, err := json.Marshal(&contextDefaults{Timezone: client.Timezone, Locale: client.Locale})
+	if err != nil {
+		return ""
+	}
+	return url.QueryEscape(string(data))
 }
 
 // Parses the JSON into a Message
@@ -4,6 +4,7 @@
 package wit
 
 import (
+	"encoding/json"
 	"os"
 	"testing"
 	"time"
@@ -83,6 +84,16 @@ func TestWitMessageParsing(t *testing.T) {
 	}
 }
 
+func TestContextJSON(t *testing.T) {
+	data, err := json.Marshal(&Context{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"reference_time":"","timezone":""}` {
+		t.Errorf("unexpected context JSON %s", data)
+	}
+}
+
 func TestWitMessageRequest(t *testing.T) {
 	client := NewClient(os.Getenv("WIT_ACCESS_TOKEN"))
 
@@ -0,0 +1,67 @@
+// Copyright (c) 2014 Jason Goecke
+// metadata.go
+
+package wit
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// ErrNoMetadata is returned when decoding metadata that was never set
+var ErrNoMetadata = errors.New("no metadata present")
+
+// DecodeMetadata decodes a JSON metadata string, as found on intents and
+// entity values, into v
+//
+//		var sku SKU
+//		err := wit.DecodeMetadata(intent.Metadata, &sku)
+func DecodeMetadata(metadata string, v interface{}) error {
+	if metadata == "" {
+		return ErrNoMetadata
+	}
+	return json.Unmarshal([]byte(metadata), v)
+}
+
+// EncodeMetadata encodes v as a JSON metadata string suitable for an intent
+// or an entity value
+//
+//		metadata, err := wit.EncodeMetadata(&SKU{ID: "sku-123", Price: 4.99})
+func EncodeMetadata(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// DecodeMetadata decodes the metadata of a matched entity value into v
+//
+//		var sku SKU
+//		err := message.Outcomes[0].Entities["product"][0].DecodeMetadata(&sku)
+func (entity *MessageEntity) DecodeMetadata(v interface{}) error {
+	if entity.Metadata == nil {
+		return ErrNoMetadata
+	}
+	return DecodeMetadata(*entity.Metadata, v)
+}
+
+// SetMetadata encodes v as JSON and stores it as the entity value's metadata
+//
+//		err := entityValue.SetMetadata(&SKU{ID: "sku-123", Price: 4.99})
+func (entityValue *EntityValue) SetMetadata(v interface{}) error {
+	metadata, err := EncodeMetadata(v)
+	if err != nil {
+		return err
+	}
+	entityValue.Metadata = metadata
+	return nil
+}
+
+// DecodeMetadata decodes the entity value's metadata into v
+//
+//		var sku SKU
+//		err := entityValue.DecodeMetadata(&sku)
+func (entityValue *EntityValue) DecodeMetadata(v interface{}) error {
+	return DecodeMetadata(entityValue.Metadata, v)
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2014 Jason Goecke
+// metadata_test.go
+
+package wit
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type testSKU struct {
+	ID    string  `json:"sku"`
+	Price float64 `json:"price"`
+}
+
+func TestMessageEntityDecodeMetadata(t *testing.T) {
+	data := `
+	{
+	  "msg_id" : "2f41839e-2b54-4de2-aa59-fc016c3e58d1",
+	  "_text" : "I want a latte",
+	  "outcomes" : [ {
+	    "_text" : "I want a latte",
+	    "confidence" : 0.9,
+	    "intent" : "order",
+	    "entities" : {
+	      "product" : [ {
+	        "value" : "latte",
+	        "metadata" : "{\"sku\":\"sku-123\",\"price\":4.5}"
+	      }, {
+	        "value" : "cup"
+	      } ]
+	    }
+	  } ]
+	}`
+
+	message, err := parseMessage([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	products := message.Outcomes[0].Entities["product"]
+	sku := &testSKU{}
+	if err := products[0].DecodeMetadata(sku); err != nil {
+		t.Fatal(err)
+	}
+	if sku.ID != "sku-123" || sku.Price != 4.5 {
+		t.Errorf("metadata did not decode properly: %+v", sku)
+	}
+	if err := products[1].DecodeMetadata(sku); err != ErrNoMetadata {
+		t.Errorf("expected ErrNoMetadata, got %v", err)
+	}
+}
+
+func TestIntentDecodeMetadata(t *testing.T) {
+	intents, err := parseIntents([]byte(`[ { "id" : "1", "name" : "order", "metadata" : "{\"sku\":\"sku-9\"}" } ]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sku := &testSKU{}
+	if err := DecodeMetadata((*intents)[0].Metadata, sku); err != nil {
+		t.Fatal(err)
+	}
+	if sku.ID != "sku-9" {
+		t.Errorf("not equal %s != %s", "sku-9", sku.ID)
+	}
+}
+
+func TestEntityValueMetadataRoundTrip(t *testing.T) {
+	entityValue := &EntityValue{Value: "latte", Expressions: []string{"latte"}}
+	if err := entityValue.SetMetadata(&testSKU{ID: "sku-123", Price: 4.5}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(&Entity{ID: "product", Values: []EntityValue{*entityValue}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity, err := parseEntity(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sku := &testSKU{}
+	if err := entity.Values[0].DecodeMetadata(sku); err != nil {
+		t.Fatal(err)
+	}
+	if sku.ID != "sku-123" || sku.Price != 4.5 {
+		t.Errorf("metadata did not round trip: %+v", sku)
+	}
+}
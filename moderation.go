@@ -0,0 +1,411 @@
+// Copyright (c) 2014 Jason Goecke
+// moderation.go
+
+package wit
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Moderation actions, from the least to the most severe
+const (
+	ModerationNone  = ""
+	ModerationFlag  = "flag"
+	ModerationMask  = "mask"
+	ModerationBlock = "block"
+)
+
+// Directions of moderated text
+const (
+	ModerationInbound  = "inbound"
+	ModerationOutbound = "outbound"
+)
+
+var moderationSeverity = map[string]int{ModerationNone: 0, ModerationFlag: 1, ModerationMask: 2, ModerationBlock: 3}
+
+// Moderator checks inbound user text and outbound replies
+type Moderator interface {
+	Moderate(ctx context.Context, text string, direction string) (*ModerationResult, error)
+}
+
+// ModerationMatch represents a rule matching a span of text, in runes
+type ModerationMatch struct {
+	Rule   string `json:"rule"`
+	Action string `json:"action"`
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+}
+
+// ModerationResult represents the outcome of moderating a text. Action is
+// the most severe action of the matches and Text the text to use, masked
+// when the action is mask.
+type ModerationResult struct {
+	Action  string            `json:"action"`
+	Text    string            `json:"text"`
+	Matches []ModerationMatch `json:"matches,omitempty"`
+}
+
+// ModerationRule represents words or a regular expression to moderate.
+// Words and patterns are matched against normalized text: lower-cased,
+// without accents and with leetspeak replaced (e.g. "$h1t" reads "shit").
+// Language only restricts the rule when the moderator has Languages set,
+// Directions defaults to both directions.
+type ModerationRule struct {
+	Name       string   `json:"name"`
+	Language   string   `json:"language,omitempty"`
+	Words      []string `json:"words,omitempty"`
+	Pattern    string   `json:"pattern,omitempty"`
+	Action     string   `json:"action"`
+	Directions []string `json:"directions,omitempty"`
+
+	words   map[string]bool
+	pattern *regexp.Regexp
+}
+
+// ModerationConfig represents a moderation rules file, e.g.
+//
+//		languages: [en, fr]
+//		rules:
+//		  - name: profanity
+//		    language: en
+//		    words: [damn, crap]
+//		    action: mask
+//		  - name: threats
+//		    pattern: "\\bkill (you|u)\\b"
+//		    action: block
+//		    directions: [inbound]
+type ModerationConfig struct {
+	Languages []string         `json:"languages,omitempty"`
+	Rules     []ModerationRule `json:"rules"`
+}
+
+// WordlistModerator moderates text with wordlist and regular expression rules
+type WordlistModerator struct {
+	// Languages restricts the rules to those of these languages, and rules
+	// without a language, when set
+	Languages []string
+	Rules     []*ModerationRule
+}
+
+// DefaultModerationRules is a small multilingual list of common profanity,
+// masked in both directions, meant as a starting point
+var DefaultModerationRules = []ModerationRule{
+	{Name: "profanity-en", Language: "en", Action: ModerationMask,
+		Words: []string{"fuck", "fucking", "shit", "bitch", "bastard", "asshole", "dick", "cunt", "motherfucker", "bullshit"}},
+	{Name: "profanity-fr", Language: "fr", Action: ModerationMask,
+		Words: []string{"merde", "putain", "connard", "connasse", "salope", "encule", "batard"}},
+	{Name: "profanity-es", Language: "es", Action: ModerationMask,
+		Words: []string{"mierda", "puta", "cabron", "gilipollas", "pendejo", "joder", "coño"}},
+	{Name: "profanity-de", Language: "de", Action: ModerationMask,
+		Words: []string{"scheisse", "scheiße", "arschloch", "fotze", "wichser", "hurensohn"}},
+}
+
+// NewWordlistModerator creates a moderator, compiling the rules
+//
+//		moderator, err := wit.NewWordlistModerator(wit.DefaultModerationRules)
+func NewWordlistModerator(rules []ModerationRule) (*WordlistModerator, error) {
+	moderator := &WordlistModerator{}
+	for i := range rules {
+		rule := rules[i]
+		if moderationSeverity[rule.Action] == 0 {
+			return nil, fmt.Errorf("moderation rule %s: unknown action %q", rule.Name, rule.Action)
+		}
+		if len(rule.Words) == 0 && rule.Pattern == "" {
+			return nil, fmt.Errorf("moderation rule %s has no words or pattern", rule.Name)
+		}
+		rule.words = map[string]bool{}
+		for _, word := range rule.Words {
+			normalized := string(normalizeModerationText(word))
+			rule.words[normalized] = true
+		}
+		if rule.Pattern != "" {
+			var err error
+			if rule.pattern, err = regexp.Compile(rule.Pattern); err != nil {
+				return nil, fmt.Errorf("moderation rule %s: %s", rule.Name, err)
+			}
+		}
+		moderator.Rules = append(moderator.Rules, &rule)
+	}
+	return moderator, nil
+}
+
+// LoadModerator reads a YAML moderation rules file
+//
+//		moderator, err := wit.LoadModerator("./moderation.yaml")
+func LoadModerator(path string) (*WordlistModerator, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &ModerationConfig{}
+	if err := unmarshalYAML(data, config); err != nil {
+		return nil, err
+	}
+	moderator, err := NewWordlistModerator(config.Rules)
+	if err != nil {
+		return nil, err
+	}
+	moderator.Languages = config.Languages
+	return moderator, nil
+}
+
+// Moderate matches the rules against the text
+//
+//		result, err := moderator.Moderate(ctx, "what the f*ck", wit.ModerationInbound)
+func (moderator *WordlistModerator) Moderate(ctx context.Context, text string, direction string) (*ModerationResult, error) {
+	original := []rune(text)
+	normalized := normalizeModerationText(text)
+	words := moderationWords(normalized)
+	result := &ModerationResult{Action: ModerationNone, Text: text}
+	for _, rule := range moderator.Rules {
+		if !moderator.applies(rule, direction) {
+			continue
+		}
+		for _, word := range words {
+			for _, variant := range word.variants {
+				if rule.matchesWord(variant) {
+					result.add(ModerationMatch{Rule: rule.Name, Action: rule.Action, Start: word.start, End: word.end})
+					break
+				}
+			}
+		}
+		if rule.pattern != nil {
+			normalizedText := string(normalized)
+			for _, span := range rule.pattern.FindAllStringIndex(normalizedText, -1) {
+				start := utf8.RuneCountInString(normalizedText[:span[0]])
+				end := start + utf8.RuneCountInString(normalizedText[span[0]:span[1]])
+				if end > start {
+					result.add(ModerationMatch{Rule: rule.Name, Action: rule.Action, Start: start, End: end})
+				}
+			}
+		}
+	}
+	if result.Action == ModerationMask {
+		masked := append([]rune(nil), original...)
+		for _, match := range result.Matches {
+			if match.Action != ModerationMask && match.Action != ModerationBlock {
+				continue
+			}
+			for i := match.Start + 1; i < match.End; i++ {
+				if !unicode.IsSpace(masked[i]) {
+					masked[i] = '*'
+				}
+			}
+		}
+		result.Text = string(masked)
+	}
+	return result, nil
+}
+
+// Reports whether a word is in the rule's list. Asterisks stand for any
+// letter ("f*ck"), as long as they do not make up half of the word.
+func (rule *ModerationRule) matchesWord(word string) bool {
+	if rule.words[word] {
+		return true
+	}
+	stars := strings.Count(word, "*")
+	length := utf8.RuneCountInString(word)
+	if stars == 0 || stars*2 >= length || strings.HasPrefix(word, "*") {
+		return false
+	}
+	for candidate := range rule.words {
+		if utf8.RuneCountInString(candidate) != length {
+			continue
+		}
+		candidateRunes := []rune(candidate)
+		matched := true
+		for i, r := range []rune(word) {
+			if r != '*' && r != candidateRunes[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether a rule applies to the direction and languages
+func (moderator *WordlistModerator) applies(rule *ModerationRule, direction string) bool {
+	if len(rule.Directions) > 0 {
+		found := false
+		for _, d := range rule.Directions {
+			found = found || d == direction
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(moderator.Languages) == 0 || rule.Language == "" {
+		return true
+	}
+	for _, language := range moderator.Languages {
+		if language == rule.Language {
+			return true
+		}
+	}
+	return false
+}
+
+func (result *ModerationResult) add(match ModerationMatch) {
+	result.Matches = append(result.Matches, match)
+	if moderationSeverity[match.Action] > moderationSeverity[result.Action] {
+		result.Action = match.Action
+	}
+}
+
+// Characters commonly substituted for letters
+var leetspeak = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '9': 'g',
+	'@': 'a', '$': 's', '!': 'i', '|': 'l', '+': 't', '€': 'e',
+}
+
+// Accented Latin letters and their base letter
+var accentFolding = map[rune]rune{}
+
+func init() {
+	for base, accented := range map[rune]string{
+		'a': "àáâãäåā", 'c': "çćč", 'e': "èéêëēė", 'i': "ìíîïī", 'n': "ñń", 'o': "òóôõöøō",
+		'u': "ùúûüū", 'y': "ýÿ", 's': "śš", 'z': "źżž", 'l': "ł",
+	} {
+		for _, r := range accented {
+			accentFolding[r] = base
+		}
+	}
+}
+
+// Normalizes text rune by rune, so offsets match the original text
+func normalizeModerationText(text string) []rune {
+	runes := []rune(strings.ToLower(text))
+	if len(runes) != utf8.RuneCountInString(text) {
+		// Lower-casing changed the length, fall back to per rune lower-casing
+		runes = []rune(text)
+		for i, r := range runes {
+			runes[i] = unicode.ToLower(r)
+		}
+	}
+	for i, r := range runes {
+		if folded, ok := accentFolding[r]; ok {
+			runes[i] = folded
+		}
+	}
+	// Leetspeak only counts within words: "4 you" stays as is, and symbols
+	// ending a word are punctuation, as in "wait!"
+	for i := 0; i < len(runes); {
+		if !unicode.IsLetter(runes[i]) && !isLeet(runes[i]) {
+			i++
+			continue
+		}
+		start, letters := i, 0
+		for i < len(runes) && (unicode.IsLetter(runes[i]) || isLeet(runes[i])) {
+			if unicode.IsLetter(runes[i]) {
+				letters++
+			}
+			i++
+		}
+		end := i
+		for end > start && isLeet(runes[end-1]) && !unicode.IsDigit(runes[end-1]) {
+			end--
+		}
+		if letters == 0 {
+			continue
+		}
+		for j := start; j < end; j++ {
+			if replacement, ok := leetspeak[runes[j]]; ok {
+				runes[j] = replacement
+			}
+		}
+	}
+	return runes
+}
+
+func isLeet(r rune) bool {
+	_, ok := leetspeak[r]
+	return ok
+}
+
+// moderationWord is a word of normalized text with its rune offsets and the
+// spellings it is matched with
+type moderationWord struct {
+	start, end int
+	variants   []string
+}
+
+// Splits normalized text into words. Runs of single letters separated by a
+// space or punctuation are joined ("f.u.c.k"), and letters repeated three
+// times or more are also read once and twice ("fuuuck", "asssss").
+func moderationWords(runes []rune) []moderationWord {
+	type span struct{ start, end int }
+	var spans []span
+	for i := 0; i < len(runes); {
+		if !isModerationWordRune(runes[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(runes) && isModerationWordRune(runes[i]) {
+			i++
+		}
+		spans = append(spans, span{start, i})
+	}
+
+	var words []moderationWord
+	add := func(start, end int, text string) {
+		word := moderationWord{start: start, end: end, variants: []string{text}}
+		if once, twice := collapseRepeats(text, 1), collapseRepeats(text, 2); once != text {
+			word.variants = append(word.variants, once, twice)
+		}
+		words = append(words, word)
+	}
+	for i := 0; i < len(spans); i++ {
+		text := string(runes[spans[i].start:spans[i].end])
+		add(spans[i].start, spans[i].end, text)
+		// Join single letters separated by one character
+		j := i
+		joined := text
+		for j+1 < len(spans) && spans[j].end-spans[j].start == 1 && spans[j+1].end-spans[j+1].start == 1 &&
+			spans[j+1].start-spans[j].end == 1 {
+			j++
+			joined += string(runes[spans[j].start:spans[j].end])
+		}
+		if j > i {
+			add(spans[i].start, spans[j].end, joined)
+			i = j
+		}
+	}
+	return words
+}
+
+// Letters, digits and masking asterisks make up words
+func isModerationWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '*'
+}
+
+// Reduces runs of three or more identical runes to n runes
+func collapseRepeats(text string, n int) string {
+	runes := []rune(text)
+	var out []rune
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && runes[j] == runes[i] {
+			j++
+		}
+		count := j - i
+		if count >= 3 {
+			count = n
+		}
+		for k := 0; k < count; k++ {
+			out = append(out, runes[i])
+		}
+		i = j
+	}
+	return string(out)
+}
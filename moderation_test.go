@@ -0,0 +1,91 @@
+// Copyright (c) 2014 Jason Goecke
+// moderation_test.go
+
+package wit
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWordlistModerator(t *testing.T) {
+	moderator, err := NewWordlistModerator(DefaultModerationRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		text   string
+		action string
+		masked string
+	}{
+		{"what a nice day", ModerationNone, "what a nice day"},
+		{"this is SHIT", ModerationMask, "this is S***"},
+		{"$h1t happens", ModerationMask, "$*** happens"},
+		{"f*ck this", ModerationMask, "f*** this"},
+		{"f.u.c.k you", ModerationMask, "f****** you"},
+		{"fuuuuuck", ModerationMask, "f*******"},
+		{"quelle merde!", ModerationMask, "quelle m****!"},
+		{"¡qué cabrón!", ModerationMask, "¡qué c*****!"},
+		{"ich sag scheiße", ModerationMask, "ich sag s******"},
+		{"class assessment at 4 pm", ModerationNone, "class assessment at 4 pm"},
+		{"wait!", ModerationNone, "wait!"},
+	}
+	for _, test := range tests {
+		result, err := moderator.Moderate(context.Background(), test.text, ModerationInbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Action != test.action || result.Text != test.masked {
+			t.Errorf("%q: expected %q %q, got %q %q", test.text, test.action, test.masked, result.Action, result.Text)
+		}
+	}
+
+	moderator.Languages = []string{"en"}
+	if result, _ := moderator.Moderate(context.Background(), "quelle merde", ModerationInbound); result.Action != ModerationNone {
+		t.Error("rules of other languages should not apply")
+	}
+}
+
+func TestLoadModerator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "moderation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "moderation.yaml")
+	config := `
+rules:
+  - name: threats
+    pattern: "\\bkill (you|u)\\b"
+    action: block
+    directions: [inbound]
+  - name: competitors
+    words: [acme]
+    action: flag
+`
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	moderator, err := LoadModerator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, _ := moderator.Moderate(context.Background(), "I will K1ll you", ModerationInbound)
+	if result.Action != ModerationBlock || len(result.Matches) != 1 || result.Matches[0].Start != 7 || result.Matches[0].End != 15 {
+		t.Errorf("expected a block, got %+v", result)
+	}
+	if result, _ = moderator.Moderate(context.Background(), "I will kill you", ModerationOutbound); result.Action != ModerationNone {
+		t.Errorf("inbound rule applied to outbound text %+v", result)
+	}
+	if result, _ = moderator.Moderate(context.Background(), "is ACME better?", ModerationOutbound); result.Action != ModerationFlag ||
+		result.Text != "is ACME better?" {
+		t.Errorf("expected a flag, got %+v", result)
+	}
+
+	if _, err := NewWordlistModerator([]ModerationRule{{Name: "x", Words: []string{"a"}, Action: "delete"}}); err == nil {
+		t.Error("expected an error for an unknown action")
+	}
+}
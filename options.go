@@ -0,0 +1,413 @@
+// Copyright (c) 2014 Jason Goecke
+// options.go
+
+package wit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// SessionOptionsKey is the session data key holding the options last
+// presented to the user
+const SessionOptionsKey = "options"
+
+// DefaultOptionDistance is the largest edit distance between a reply and
+// a label for the reply to select the option
+const DefaultOptionDistance = 2
+
+// How a selection was resolved
+const (
+	// SelectionLabel is a reply naming an option
+	SelectionLabel = "label"
+	// SelectionRelative is a reply such as "the last one" or "the latter"
+	SelectionRelative = "relative"
+	// SelectionOrdinal is a reply such as "the second one"
+	SelectionOrdinal = "ordinal"
+	// SelectionNumber is a reply of the option's number, such as "2"
+	SelectionNumber = "number"
+	// SelectionEntity is a message entity whose value names an option
+	SelectionEntity = "entity"
+	// SelectionFuzzy is a reply close to a label, such as "Madird"
+	SelectionFuzzy = "fuzzy"
+)
+
+// Option represents an item presented to the user. Value is what the bot
+// does with the choice, e.g. an id.
+type Option struct {
+	Label   string      `json:"label"`
+	Value   interface{} `json:"value,omitempty"`
+	Aliases []string    `json:"aliases,omitempty"`
+}
+
+// OptionList represents the options presented to the user. Entity is the
+// name of the Wit entity replies naming an option are tagged with, if any.
+type OptionList struct {
+	Options     []Option  `json:"options"`
+	Entity      string    `json:"entity,omitempty"`
+	PresentedAt time.Time `json:"presented_at"`
+}
+
+// Selection represents the option a reply selects, Index counting from 0
+type Selection struct {
+	Index  int    `json:"index"`
+	Option Option `json:"option"`
+	Method string `json:"method"`
+	// Distance is the edit distance of fuzzy selections
+	Distance int `json:"distance,omitempty"`
+}
+
+// OptionResolver resolves replies to the options presented to the user
+type OptionResolver struct {
+	// MaxDistance caps the edit distance of fuzzy matches, which is at most
+	// a quarter of the label's length, DefaultOptionDistance when 0
+	MaxDistance int
+	// MaxAge ignores options presented longer ago when set
+	MaxAge time.Duration
+}
+
+// PresentOptions stores the options presented to the user in the session
+// and returns them numbered, e.g. "1. Paris 2. Rome 3. Madrid"
+//
+//		text := wit.PresentOptions(session, "location", wit.Option{Label: "Paris"}, wit.Option{Label: "Rome"})
+func PresentOptions(session *Session, entity string, options ...Option) string {
+	session.Set(SessionOptionsKey, &OptionList{Options: options, Entity: entity, PresentedAt: time.Now()})
+	labels := make([]string, len(options))
+	for i, option := range options {
+		labels[i] = fmt.Sprintf("%d. %s", i+1, option.Label)
+	}
+	return strings.Join(labels, " ")
+}
+
+// SessionOptions returns a copy of the options presented in the session,
+// or nil
+//
+//		list := wit.SessionOptions(session)
+func SessionOptions(session *Session) *OptionList {
+	value, ok := session.Get(SessionOptionsKey)
+	if !ok || value == nil {
+		return nil
+	}
+	// Lists read from files are maps, both are decoded into a new list
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	list := &OptionList{}
+	if err := json.Unmarshal(data, list); err != nil || len(list.Options) == 0 {
+		return nil
+	}
+	return list
+}
+
+// ClearOptions removes the options presented in the session, once one is
+// selected
+//
+//		wit.ClearOptions(session)
+func ClearOptions(session *Session) {
+	delete(session.Data, SessionOptionsKey)
+}
+
+// Resolve returns the option of the session a message selects
+//
+//		selection, ok := resolver.Resolve(session, message)
+//		if ok {
+//			wit.ClearOptions(session)
+//		}
+func (resolver *OptionResolver) Resolve(session *Session, message *Message) (*Selection, bool) {
+	list := SessionOptions(session)
+	if list == nil || message == nil {
+		return nil, false
+	}
+	if resolver.MaxAge > 0 && time.Since(list.PresentedAt) > resolver.MaxAge {
+		return nil, false
+	}
+	text := message.Text
+	if text == "" && len(message.Outcomes) > 0 {
+		text = message.Outcomes[0].Text
+	}
+	return resolver.Select(list, text, message)
+}
+
+// Select returns the option a reply selects, using the message's ordinal,
+// number and list entities when there is a message, and parsing the text
+// otherwise. In order: a label named in the reply, a relative reference, an
+// ordinal, a bare number, an entity, then the closest label. Replies naming
+// several options select none.
+//
+//		selection, ok := resolver.Select(list, "the second one", nil)
+func (resolver *OptionResolver) Select(list *OptionList, text string, message *Message) (*Selection, bool) {
+	options := list.Options
+	if len(options) == 0 {
+		return nil, false
+	}
+	tokens := tokenize(text)
+	var entities map[string][]MessageEntity
+	if message != nil && len(message.Outcomes) > 0 {
+		entities = message.Outcomes[0].Entities
+	}
+
+	if index, named := labelMatch(options, tokens); named == 1 {
+		return newSelection(options, index, SelectionLabel, 0), true
+	} else if named > 1 {
+		return nil, false
+	}
+	if index, ok := relativeMatch(text, tokens, len(options)); ok {
+		return newSelection(options, index, SelectionRelative, 0), true
+	}
+
+	ordinals, ok := entities["ordinal"]
+	if !ok {
+		ordinals = ParseOrdinals(text)
+	}
+	if index, ok := positionMatch(ordinals, len(options)); ok {
+		return newSelection(options, index, SelectionOrdinal, 0), true
+	}
+	if bareNumber(text, tokens) {
+		numbers, ok := entities["number"]
+		if !ok {
+			numbers = ParseNumbers(text)
+		}
+		if index, ok := positionMatch(numbers, len(options)); ok {
+			return newSelection(options, index, SelectionNumber, 0), true
+		}
+	}
+
+	if list.Entity != "" {
+		for _, entity := range entities[list.Entity] {
+			if entity.Value == nil {
+				continue
+			}
+			value := tokenize(fmt.Sprint(*entity.Value))
+			if index, named := labelMatch(options, value); named == 1 {
+				return newSelection(options, index, SelectionEntity, 0), true
+			}
+			if index, distance, ok := resolver.fuzzyMatch(options, value); ok {
+				return newSelection(options, index, SelectionEntity, distance), true
+			}
+		}
+	}
+
+	if index, distance, ok := resolver.fuzzyMatch(options, tokens); ok {
+		return newSelection(options, index, SelectionFuzzy, distance), true
+	}
+	return nil, false
+}
+
+func newSelection(options []Option, index int, method string, distance int) *Selection {
+	return &Selection{Index: index, Option: options[index], Method: method, Distance: distance}
+}
+
+// Returns the labels and aliases of an option, tokenized
+func optionNames(option Option) [][]token {
+	names := [][]token{tokenize(option.Label)}
+	for _, alias := range option.Aliases {
+		names = append(names, tokenize(alias))
+	}
+	if value, ok := option.Value.(string); ok {
+		names = append(names, tokenize(value))
+	}
+	return names
+}
+
+// Returns the option named in the tokens and the number of options named.
+// Names within a longer name are ignored, so "Paris Orly" names it and not
+// "Paris".
+func labelMatch(options []Option, tokens []token) (int, int) {
+	type match struct{ index, start, end int }
+	var matches []match
+	for index, option := range options {
+		for _, name := range optionNames(option) {
+			for start := 0; len(name) > 0 && start+len(name) <= len(tokens); start++ {
+				if tokensEqual(tokens[start:start+len(name)], name) {
+					matches = append(matches, match{index, start, start + len(name)})
+				}
+			}
+		}
+	}
+	selected, named := -1, 0
+	for _, m := range matches {
+		contained := false
+		for _, other := range matches {
+			if other.end-other.start > m.end-m.start && other.start <= m.start && m.end <= other.end {
+				contained = true
+				break
+			}
+		}
+		if contained || m.index == selected {
+			continue
+		}
+		if selected < 0 {
+			selected = m.index
+		}
+		named++
+	}
+	return selected, named
+}
+
+func tokensEqual(a []token, b []token) bool {
+	for i := range a {
+		if a[i].text != b[i].text {
+			return false
+		}
+	}
+	return true
+}
+
+// Words selecting an option relative to the list
+var relativeWords = map[string]string{
+	"last": "last", "final": "last", "bottom": "last", "latter": "last",
+	"former": "first", "top": "first",
+	"penultimate": "penultimate",
+	"middle":      "middle",
+}
+
+// Returns the option selected by references such as "the last one", "the
+// second to last" or "the middle one"
+func relativeMatch(text string, tokens []token, count int) (int, bool) {
+	for i, token := range tokens {
+		switch relativeWords[token.text] {
+		case "first":
+			return 0, true
+		case "penultimate":
+			return count - 2, count >= 2
+		case "middle":
+			return count / 2, count%2 == 1
+		case "last":
+			// "second to last" and "second last" count from the end
+			before := i - 1
+			if before >= 1 && tokens[before].text == "to" {
+				before--
+			}
+			if before >= 0 {
+				if value, next, ok := parseOrdinalAt(text, tokens, before); ok && next == before+1 {
+					index := count - int(value)
+					return index, index >= 0 && value == math.Trunc(value)
+				}
+			}
+			return count - 1, true
+		}
+	}
+	return 0, false
+}
+
+// Returns the option at the position of the first entity within the list
+func positionMatch(entities []MessageEntity, count int) (int, bool) {
+	for _, entity := range entities {
+		if entity.Value == nil {
+			continue
+		}
+		value, ok := (*entity.Value).(float64)
+		if ok && value == math.Trunc(value) && value >= 1 && int(value) <= count {
+			return int(value) - 1, true
+		}
+	}
+	return 0, false
+}
+
+// Words allowed around a number selecting an option, e.g. "number 2 please"
+var numberFillers = map[string]bool{
+	"number": true, "no": true, "option": true, "choice": true, "item": true,
+	"the": true, "one": true, "please": true, "pick": true, "i'll": true, "take": true,
+}
+
+// Reports whether a text is only a number, so "for two people" does not
+// select the second option
+func bareNumber(text string, tokens []token) bool {
+	numbers := ParseNumbers(text)
+	if len(numbers) != 1 {
+		return false
+	}
+	start, end := 0, 0
+	if numbers[0].Start != nil {
+		start, end = int(*numbers[0].Start), int(*numbers[0].End)
+	}
+	for _, token := range tokens {
+		position := utf8.RuneCountInString(text[:token.start])
+		if position >= start && position < end {
+			continue
+		}
+		if !numberFillers[token.text] {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the option whose name is closest to the tokens, or to a run of
+// them as long as the name, within the allowed distance. Ties select none.
+func (resolver *OptionResolver) fuzzyMatch(options []Option, tokens []token) (int, int, bool) {
+	maxDistance := resolver.MaxDistance
+	if maxDistance == 0 {
+		maxDistance = DefaultOptionDistance
+	}
+	best, bestDistance, tied := -1, math.MaxInt32, false
+	for index, option := range options {
+		for _, name := range optionNames(option) {
+			label := joinTokens(name)
+			allowed := utf8.RuneCountInString(label) / 4
+			if allowed > maxDistance {
+				allowed = maxDistance
+			}
+			if allowed == 0 {
+				continue
+			}
+			for start := 0; start+len(name) <= len(tokens); start++ {
+				distance := editDistance(joinTokens(tokens[start:start+len(name)]), label)
+				if distance > allowed {
+					continue
+				}
+				if distance < bestDistance {
+					best, bestDistance, tied = index, distance, false
+				} else if distance == bestDistance && index != best {
+					tied = true
+				}
+			}
+		}
+	}
+	if best < 0 || tied {
+		return 0, 0, false
+	}
+	return best, bestDistance, true
+}
+
+func joinTokens(tokens []token) string {
+	words := make([]string, len(tokens))
+	for i, token := range tokens {
+		words[i] = token.text
+	}
+	return strings.Join(words, " ")
+}
+
+// Returns the edit distance between two strings, in runes: the Levenshtein
+// distance with transpositions of adjacent runes counting as one edit, as
+// in "Madird"
+func editDistance(a string, b string) int {
+	source, target := []rune(a), []rune(b)
+	distances := make([][]int, len(source)+1)
+	for i := range distances {
+		distances[i] = make([]int, len(target)+1)
+		distances[i][0] = i
+	}
+	for j := range distances[0] {
+		distances[0][j] = j
+	}
+	for i := 1; i <= len(source); i++ {
+		for j := 1; j <= len(target); j++ {
+			cost := 1
+			if source[i-1] == target[j-1] {
+				cost = 0
+			}
+			distance := minInt(minInt(distances[i-1][j]+1, distances[i][j-1]+1), distances[i-1][j-1]+cost)
+			if i > 1 && j > 1 && source[i-1] == target[j-2] && source[i-2] == target[j-1] {
+				distance = minInt(distance, distances[i-2][j-2]+1)
+			}
+			distances[i][j] = distance
+		}
+	}
+	return distances[len(source)][len(target)]
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2014 Jason Goecke
+// options_test.go
+
+package wit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSelectOption(t *testing.T) {
+	list := &OptionList{Options: []Option{
+		{Label: "Paris", Value: "CDG"},
+		{Label: "Rome", Value: "FCO", Aliases: []string{"Roma"}},
+		{Label: "Madrid", Value: "MAD"},
+		{Label: "Paris Orly", Value: "ORY"},
+	}}
+	resolver := &OptionResolver{}
+	tests := []struct {
+		text   string
+		index  int
+		method string
+	}{
+		{"Rome", 1, SelectionLabel},
+		{"roma please", 1, SelectionLabel},
+		{"Paris Orly", 3, SelectionLabel},
+		{"mad", 2, SelectionLabel},
+		{"the second one", 1, SelectionOrdinal},
+		{"3rd", 2, SelectionOrdinal},
+		{"the last", 3, SelectionRelative},
+		{"second to last", 2, SelectionRelative},
+		{"the second last one", 2, SelectionRelative},
+		{"top one", 0, SelectionRelative},
+		{"2", 1, SelectionNumber},
+		{"number three", 2, SelectionNumber},
+		{"Madird", 2, SelectionFuzzy},
+		{"i'll go with Romee", 1, SelectionFuzzy},
+	}
+	for _, test := range tests {
+		selection, ok := resolver.Select(list, test.text, nil)
+		if !ok {
+			t.Errorf("expected %q to select option %d", test.text, test.index)
+			continue
+		}
+		if selection.Index != test.index || selection.Method != test.method {
+			t.Errorf("expected %q to select option %d by %s, got %+v", test.text, test.index, test.method, selection)
+		}
+	}
+	for _, text := range []string{"Paris or Rome?", "for two people", "the ninth", "London", "Mxdxd"} {
+		if selection, ok := resolver.Select(list, text, nil); ok {
+			t.Errorf("expected %q to select nothing, got %+v", text, selection)
+		}
+	}
+	if _, ok := (&OptionResolver{MaxDistance: 1}).Select(list, "Madird", nil); !ok {
+		t.Error("expected a transposition to count as one edit")
+	}
+}
+
+func TestResolveOptions(t *testing.T) {
+	session := NewSession("user-42")
+	text := PresentOptions(session, "location", Option{Label: "Paris"}, Option{Label: "Rome"}, Option{Label: "Madrid"})
+	if text != "1. Paris 2. Rome 3. Madrid" {
+		t.Errorf("unexpected presentation %q", text)
+	}
+	// Through a file store the list comes back as a map
+	data, _ := json.Marshal(session)
+	stored := &Session{}
+	json.Unmarshal(data, stored)
+
+	resolver := &OptionResolver{}
+	message := &Message{Text: "the one in Spain", Outcomes: []Outcome{{Entities: map[string][]MessageEntity{
+		"location": {contactEntity("madrid")},
+	}}}}
+	selection, ok := resolver.Resolve(stored, message)
+	if !ok || selection.Index != 2 || selection.Method != SelectionEntity {
+		t.Errorf("expected the location entity to select Madrid, got %+v", selection)
+	}
+	var second interface{} = float64(2)
+	if selection, ok = resolver.Resolve(stored, &Message{Text: "number 2"}); !ok || selection.Option.Label != "Rome" {
+		t.Errorf("unexpected selection %+v", selection)
+	}
+	message = &Message{Outcomes: []Outcome{{Text: "that second option", Entities: map[string][]MessageEntity{"ordinal": {{Value: &second}}}}}}
+	if selection, ok = resolver.Resolve(stored, message); !ok || selection.Index != 1 || selection.Method != SelectionOrdinal {
+		t.Errorf("expected the ordinal entity to select Rome, got %+v", selection)
+	}
+
+	ClearOptions(stored)
+	if _, ok := resolver.Resolve(stored, message); ok {
+		t.Error("expected no selection without options")
+	}
+	session.Data[SessionOptionsKey].(*OptionList).PresentedAt = time.Now().Add(-time.Hour)
+	if _, ok := (&OptionResolver{MaxAge: time.Minute}).Resolve(session, message); ok {
+		t.Error("expected stale options to be ignored")
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2014 Jason Goecke
+// payload.go
+
+package wit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PayloadPrefix starts the payloads of quick replies and buttons
+const PayloadPrefix = "wit1."
+
+// MetadataPayload holds the *Payload of a message that was a quick reply
+// or a button tap
+const MetadataPayload = "payload"
+
+// Errors returned when decoding payloads
+var (
+	ErrNotPayload     = errors.New("text is not a payload")
+	ErrInvalidPayload = errors.New("payload is malformed or its signature does not match")
+	ErrPayloadExpired = errors.New("payload expired")
+)
+
+// Payload represents what a quick reply or a button means, decoded without
+// asking Wit. Entities are values by entity name, a list for several
+// values.
+type Payload struct {
+	// Text is the label shown to the user
+	Text     string                 `json:"text,omitempty"`
+	Intent   string                 `json:"intent"`
+	Entities map[string]interface{} `json:"entities,omitempty"`
+	// ExpiresAt is when the payload expires, in Unix seconds, never when 0
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// PayloadCodec signs payloads with the primary key of a keyring and
+// verifies them with any of its keys, so keys can be rotated while older
+// buttons are still on screen
+type PayloadCodec struct {
+	Keyring *Keyring
+	// TTL sets the expiry of payloads that have none when set
+	TTL time.Duration
+	// MaxLength is the longest payload the channel accepts when set,
+	// e.g. 1000 for Messenger postbacks
+	MaxLength int
+}
+
+// NewPayloadCodec creates a payload codec
+//
+//		keyring, err := wit.KeyringFromEnv()
+//		codec := wit.NewPayloadCodec(keyring)
+func NewPayloadCodec(keyring *Keyring) *PayloadCodec {
+	return &PayloadCodec{Keyring: keyring}
+}
+
+// IsPayload reports whether a text looks like a payload, signed or not
+//
+//		if wit.IsPayload(text) { ... }
+func IsPayload(text string) bool {
+	return strings.HasPrefix(text, PayloadPrefix)
+}
+
+// Encode returns the signed payload string to send as a button's payload:
+// the prefix, then the key id, the JSON payload and its HMAC-SHA256, each
+// base64url-encoded and separated by dots
+//
+//		data, err := codec.Encode(&wit.Payload{Text: "Paris", Intent: "book_flight", Entities: map[string]interface{}{"location": "Paris"}})
+func (codec *PayloadCodec) Encode(payload *Payload) (string, error) {
+	if payload.Intent == "" {
+		return "", errors.New("a payload needs an intent")
+	}
+	if codec.TTL > 0 && payload.ExpiresAt == 0 {
+		copied := *payload
+		copied.ExpiresAt = time.Now().Add(codec.TTL).Unix()
+		payload = &copied
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	id, key, err := codec.key("")
+	if err != nil {
+		return "", err
+	}
+	signed := PayloadPrefix + base64.RawURLEncoding.EncodeToString([]byte(id)) + "." + base64.RawURLEncoding.EncodeToString(data)
+	encoded := signed + "." + base64.RawURLEncoding.EncodeToString(payloadMAC(key, signed))
+	if codec.MaxLength > 0 && len(encoded) > codec.MaxLength {
+		return "", fmt.Errorf("payload is %d bytes long, the channel accepts %d", len(encoded), codec.MaxLength)
+	}
+	return encoded, nil
+}
+
+// Decode verifies a payload string and returns its payload
+//
+//		payload, err := codec.Decode(postback.Payload)
+//		if err == wit.ErrPayloadExpired { ... }
+func (codec *PayloadCodec) Decode(text string) (*Payload, error) {
+	if !IsPayload(text) {
+		return nil, ErrNotPayload
+	}
+	parts := strings.Split(text, ".")
+	if len(parts) != 4 {
+		return nil, ErrInvalidPayload
+	}
+	id, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidPayload
+	}
+	_, key, err := codec.key(string(id))
+	if err != nil {
+		return nil, ErrInvalidPayload
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil || !hmac.Equal(signature, payloadMAC(key, strings.Join(parts[:3], "."))) {
+		return nil, ErrInvalidPayload
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidPayload
+	}
+	payload := &Payload{}
+	if err := json.Unmarshal(data, payload); err != nil || payload.Intent == "" {
+		return nil, ErrInvalidPayload
+	}
+	if payload.ExpiresAt != 0 && time.Now().Unix() >= payload.ExpiresAt {
+		return nil, ErrPayloadExpired
+	}
+	return payload, nil
+}
+
+// Returns a key of the keyring by id, the primary key when id is empty
+func (codec *PayloadCodec) key(id string) (string, []byte, error) {
+	if codec.Keyring == nil {
+		return "", nil, errors.New("payload codec has no keyring")
+	}
+	codec.Keyring.mutex.RLock()
+	defer codec.Keyring.mutex.RUnlock()
+	if id == "" {
+		id = codec.Keyring.primary
+	}
+	key := codec.Keyring.keys[id]
+	if key == nil {
+		return "", nil, ErrUnknownKey
+	}
+	return id, key, nil
+}
+
+// Returns the HMAC-SHA256 of a payload, with a key derived from the
+// keyring's key so it is not used both to encrypt and to sign
+func payloadMAC(key []byte, signed string) []byte {
+	derive := hmac.New(sha256.New, key)
+	derive.Write([]byte("wit payload signing"))
+	mac := hmac.New(sha256.New, derive.Sum(nil))
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+// Message returns the message Wit would have returned for the payload: one
+// outcome of the payload's intent and entities, with full confidence
+//
+//		message := payload.Message()
+func (payload *Payload) Message() *Message {
+	outcome := Outcome{Text: payload.Text, Intent: payload.Intent, Confidence: 1, Entities: map[string][]MessageEntity{}}
+	names := make([]string, 0, len(payload.Entities))
+	for name := range payload.Entities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values, ok := payload.Entities[name].([]interface{})
+		if !ok {
+			values = []interface{}{payload.Entities[name]}
+		}
+		for _, value := range values {
+			value := value
+			outcome.Entities[name] = append(outcome.Entities[name], MessageEntity{Value: &value})
+		}
+	}
+	return &Message{Text: payload.Text, Outcomes: []Outcome{outcome}}
+}
+
+// Returns the message of a payload, with the ids the pipeline would have
+// sent to Wit
+func payloadMessage(ctx context.Context, payload *Payload, threadID string) *Message {
+	message := payload.Message()
+	message.ThreadID = threadID
+	if id, ok := CorrelationID(ctx); ok {
+		message.MsgID = id
+	} else {
+		message.MsgID = NewCorrelationID()
+	}
+	return message
+}
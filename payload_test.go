@@ -0,0 +1,125 @@
+// Copyright (c) 2014 Jason Goecke
+// payload_test.go
+
+package wit
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns the JSON of an encoded payload
+func payloadJSON(t *testing.T, encoded string) string {
+	data, err := base64.RawURLEncoding.DecodeString(strings.Split(encoded, ".")[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestPayloadCodec(t *testing.T) {
+	keyring := testKeyring(t, "2016-01", "2016-02")
+	codec := NewPayloadCodec(keyring)
+	encoded, err := codec.Encode(&Payload{Text: "Paris", Intent: "book_flight", Entities: map[string]interface{}{"location": "Paris", "topping": []interface{}{"cheese", "ham"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsPayload(encoded) || IsPayload("Paris") {
+		t.Error("unexpected IsPayload result")
+	}
+
+	keyring.SetPrimary("2016-02")
+	payload, err := codec.Decode(encoded)
+	if err != nil {
+		t.Fatalf("expected payloads of older keys to verify: %s", err)
+	}
+	message := payload.Message()
+	outcome := message.Outcomes[0]
+	if message.Text != "Paris" || outcome.Intent != "book_flight" || outcome.Confidence != 1 || *outcome.Entities["location"][0].Value != "Paris" {
+		t.Errorf("unexpected message %+v", message)
+	}
+	if toppings := outcome.Entities["topping"]; len(toppings) != 2 || *toppings[1].Value != "ham" {
+		t.Errorf("expected a value per list item %+v", toppings)
+	}
+
+	parts := strings.Split(encoded, ".")
+	tampered, _ := NewPayloadCodec(testKeyring(t, "2016-01")).Encode(&Payload{Intent: "refund"})
+	forged := strings.Join(append(parts[:3:3], strings.Split(tampered, ".")[3]), ".")
+	for _, text := range []string{forged, encoded[:len(encoded)-2], "wit1.garbage", tampered} {
+		if _, err := codec.Decode(text); err != ErrInvalidPayload {
+			t.Errorf("expected %q to be invalid, got %v", text, err)
+		}
+	}
+	if _, err := codec.Decode("Paris"); err != ErrNotPayload {
+		t.Errorf("expected ErrNotPayload, got %v", err)
+	}
+
+	if bare, _ := codec.Encode(&Payload{Intent: "confirm"}); strings.Contains(payloadJSON(t, bare), "expires_at") {
+		t.Errorf("expected no expiry without TTL, got %s", payloadJSON(t, bare))
+	}
+	codec.TTL = time.Second
+	short, _ := codec.Encode(&Payload{Intent: "confirm", ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	if _, err := codec.Decode(short); err != ErrPayloadExpired {
+		t.Errorf("expected ErrPayloadExpired, got %v", err)
+	}
+	expiring, _ := codec.Encode(&Payload{Intent: "confirm"})
+	if payload, err := codec.Decode(expiring); err != nil || payload.ExpiresAt <= time.Now().Unix() {
+		t.Errorf("expected the TTL to set the expiry, got %+v %v", payload, err)
+	}
+	codec.MaxLength = 64
+	if _, err := codec.Encode(&Payload{Intent: "book_flight", Entities: map[string]interface{}{"location": "Paris"}}); err == nil {
+		t.Error("expected a payload longer than MaxLength to fail")
+	}
+	if _, err := codec.Encode(&Payload{Text: "no intent"}); err == nil {
+		t.Error("expected a payload without intent to fail")
+	}
+}
+
+func TestPipelinePayloads(t *testing.T) {
+	codec := NewPayloadCodec(testKeyring(t, "k1"))
+	moderator, err := NewWordlistModerator(DefaultModerationRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	understood := 0
+	var handled []*Message
+	pipeline := &Pipeline{
+		Understand: func(ctx context.Context, request *MessageRequest) (*Message, error) {
+			understood++
+			return &Message{Text: request.Query, Outcomes: []Outcome{{Intent: "book_flight"}}}, nil
+		},
+		Moderator: moderator,
+		Handler: HandlerFunc(func(ctx context.Context, message *Message, session *Session) (*Reply, error) {
+			handled = append(handled, message)
+			return &Reply{Text: "booked"}, nil
+		}),
+		Sessions: NewMemorySessionStore(0),
+		Payloads: codec,
+	}
+
+	encoded, _ := codec.Encode(&Payload{Text: "Rome", Intent: "book_flight", Entities: map[string]interface{}{"location": "Rome"}})
+	ctx := WithCorrelationID(context.Background(), "req-1")
+	result, err := pipeline.Process(ctx, "user-42", encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if understood != 0 || result.Text != "Rome" || result.Metadata[MetadataPayload] == nil || result.Metadata[MetadataMsgID] != "req-1" {
+		t.Errorf("expected the payload not to reach Wit %+v", result)
+	}
+	if len(handled) != 1 || handled[0].Outcomes[0].Intent != "book_flight" || handled[0].ThreadID == "" {
+		t.Errorf("unexpected handled message %+v", handled)
+	}
+
+	if _, err := pipeline.Process(context.Background(), "user-42", "to Rome"); err != nil {
+		t.Fatal(err)
+	}
+	if understood != 1 || len(handled) != 2 || handled[1].Outcomes[0].Intent != handled[0].Outcomes[0].Intent {
+		t.Error("expected free text to reach the same handler")
+	}
+	if _, err := pipeline.Process(context.Background(), "user-42", encoded+"x"); err != ErrInvalidPayload {
+		t.Errorf("expected ErrInvalidPayload, got %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2014 Jason Goecke
+// pipeline.go
+
+package wit
+
+import (
+	"context"
+	"errors"
+)
+
+// Keys of the pipeline's result metadata
+const (
+	// MetadataModeration holds the []ModerationRecord of a message
+	MetadataModeration = "moderation"
+	// MetadataMsgID and MetadataThreadID hold the ids the message was sent
+	// to Wit with
+	MetadataMsgID    = "msg_id"
+	MetadataThreadID = "thread_id"
+)
+
+// DefaultBlockedReply is the reply to blocked messages when none is set
+const DefaultBlockedReply = "Sorry, I can't help with that."
+
+// ModerationRecord represents a moderation action taken by the pipeline
+type ModerationRecord struct {
+	Direction string            `json:"direction"`
+	Action    string            `json:"action"`
+	Rules     []string          `json:"rules"`
+	Matches   []ModerationMatch `json:"matches,omitempty"`
+}
+
+// PipelineResult represents a message processed by a pipeline. Metadata
+// records what the stages did, e.g. moderation actions or throttling.
+type PipelineResult struct {
+	Text      string                 `json:"text"`
+	Message   *Message               `json:"message,omitempty"`
+	Reply     *Reply                 `json:"reply,omitempty"`
+	Session   *Session               `json:"-"`
+	Blocked   bool                   `json:"blocked"`
+	Throttled bool                   `json:"throttled"`
+	Frame     *Frame                 `json:"frame,omitempty"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// Pipeline processes user text: throttling of the user, moderation of the
+// input, understanding by Wit, handling, and moderation of the reply.
+// Sessions are loaded and saved around each message when a store is set,
+// session ids identify users to the throttler. Messages of a session share
+// the session's thread id.
+type Pipeline struct {
+	Client *Client
+	// Channel selects the throttle policy
+	Channel   string
+	Throttler *Throttler
+	// Understand replaces Client.Message when set
+	Understand func(ctx context.Context, request *MessageRequest) (*Message, error)
+	Moderator  Moderator
+	Handler    Handler
+	Sessions   SessionStore
+	// Payloads, when set, decodes the payloads of quick replies and
+	// buttons into messages without moderating them or calling Wit
+	Payloads *PayloadCodec
+	// CarryOver, with Sessions, merges the turns of a session into a frame
+	// handlers get with CurrentFrame
+	CarryOver *CarryOver
+	// BlockedReply replaces blocked input and replies, DefaultBlockedReply when empty
+	BlockedReply string
+	// ThrottledReply answers throttled messages, DefaultThrottledReply when empty
+	ThrottledReply string
+}
+
+// Process runs the text of a session through the pipeline. Throttled and
+// blocked input never reaches Wit, masked input reaches it masked. Signed
+// payloads are handled as the message they encode, and payloads failing
+// verification return ErrInvalidPayload or ErrPayloadExpired.
+//
+//		pipeline := &wit.Pipeline{Client: client, Moderator: moderator, Handler: router, Sessions: store}
+//		result, err := pipeline.Process(ctx, "user-42", "what's the weather in Paris?")
+func (pipeline *Pipeline) Process(ctx context.Context, sessionID string, text string) (*PipelineResult, error) {
+	result := &PipelineResult{Text: text, Metadata: map[string]interface{}{}}
+	if pipeline.Throttler != nil {
+		decision, err := pipeline.Throttler.Check(ctx, pipeline.Channel, sessionID, text)
+		if err != nil {
+			return nil, err
+		}
+		if !decision.Allowed {
+			result.Throttled = true
+			result.Metadata[MetadataThrottle] = decision
+			result.Reply = &Reply{Text: pipeline.ThrottledReply}
+			if result.Reply.Text == "" {
+				result.Reply.Text = DefaultThrottledReply
+			}
+			return result, nil
+		}
+	}
+	if pipeline.Sessions != nil {
+		session, err := pipeline.Sessions.Load(sessionID)
+		if err != nil {
+			return nil, err
+		}
+		result.Session = session
+	}
+
+	var payload *Payload
+	if pipeline.Payloads != nil && IsPayload(text) {
+		decoded, err := pipeline.Payloads.Decode(text)
+		if err != nil {
+			return nil, err
+		}
+		payload = decoded
+		result.Text = payload.Text
+		result.Metadata[MetadataPayload] = payload
+	} else {
+		moderated, err := pipeline.moderate(ctx, result, text, ModerationInbound)
+		if err != nil {
+			return nil, err
+		}
+		if moderated == nil {
+			result.Reply = &Reply{Text: pipeline.blockedReply()}
+			return result, nil
+		}
+		result.Text = *moderated
+	}
+
+	var err error
+	request := &MessageRequest{Query: result.Text}
+	if result.Session != nil {
+		request.ThreadID = result.Session.ThreadID()
+		ctx = WithThreadID(ctx, request.ThreadID)
+	}
+	if payload != nil {
+		result.Message = payloadMessage(ctx, payload, request.ThreadID)
+	} else if result.Message, err = pipeline.understand(ctx, request); err != nil {
+		return nil, err
+	}
+	if result.Message == nil {
+		// Handlers get a message without intent rather than nil
+		result.Message = &Message{Text: result.Text, ThreadID: request.ThreadID}
+	}
+	if result.Message.MsgID != "" {
+		result.Metadata[MetadataMsgID] = result.Message.MsgID
+	}
+	if request.ThreadID != "" {
+		result.Metadata[MetadataThreadID] = request.ThreadID
+	}
+	if pipeline.CarryOver != nil && result.Session != nil {
+		result.Frame = pipeline.CarryOver.Apply(result.Session, result.Message)
+		ctx = WithFrame(ctx, result.Frame)
+	}
+	if pipeline.Handler != nil {
+		if result.Reply, err = pipeline.Handler.Handle(ctx, result.Message, result.Session); err != nil {
+			return nil, err
+		}
+	}
+	if result.Reply != nil && result.Reply.Text != "" {
+		reply, err := pipeline.moderate(ctx, result, result.Reply.Text, ModerationOutbound)
+		if err != nil {
+			return nil, err
+		}
+		if reply == nil {
+			result.Reply.Text = pipeline.blockedReply()
+		} else {
+			result.Reply.Text = *reply
+		}
+	}
+
+	if pipeline.Sessions != nil {
+		if err := pipeline.Sessions.Save(result.Session); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// Moderates text, recording the action, and returns the text to use or nil
+// when it is blocked
+func (pipeline *Pipeline) moderate(ctx context.Context, result *PipelineResult, text string, direction string) (*string, error) {
+	if pipeline.Moderator == nil {
+		return &text, nil
+	}
+	moderation, err := pipeline.Moderator.Moderate(ctx, text, direction)
+	if err != nil {
+		return nil, err
+	}
+	if moderation.Action == ModerationNone {
+		return &text, nil
+	}
+	record := ModerationRecord{Direction: direction, Action: moderation.Action, Matches: moderation.Matches}
+	seen := map[string]bool{}
+	for _, match := range moderation.Matches {
+		if !seen[match.Rule] {
+			seen[match.Rule] = true
+			record.Rules = append(record.Rules, match.Rule)
+		}
+	}
+	records, _ := result.Metadata[MetadataModeration].([]ModerationRecord)
+	result.Metadata[MetadataModeration] = append(records, record)
+
+	switch moderation.Action {
+	case ModerationBlock:
+		if direction == ModerationInbound {
+			result.Blocked = true
+		}
+		return nil, nil
+	case ModerationMask:
+		return &moderation.Text, nil
+	}
+	return &text, nil
+}
+
+func (pipeline *Pipeline) understand(ctx context.Context, request *MessageRequest) (*Message, error) {
+	if pipeline.Understand != nil {
+		return pipeline.Understand(ctx, request)
+	}
+	if pipeline.Client == nil {
+		return nil, errors.New("pipeline has no client")
+	}
+	return pipeline.Client.MessageContext(ctx, request)
+}
+
+func (pipeline *Pipeline) blockedReply() string {
+	if pipeline.BlockedReply != "" {
+		return pipeline.BlockedReply
+	}
+	return DefaultBlockedReply
+}
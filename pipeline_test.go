@@ -0,0 +1,80 @@
+// Copyright (c) 2014 Jason Goecke
+// pipeline_test.go
+
+package wit
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPipelineModeration(t *testing.T) {
+	moderator, err := NewWordlistModerator(append([]ModerationRule{
+		{Name: "threats", Pattern: `\bkill\b`, Action: ModerationBlock, Directions: []string{ModerationInbound}},
+	}, DefaultModerationRules...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var understood []string
+	pipeline := &Pipeline{
+		Understand: func(ctx context.Context, request *MessageRequest) (*Message, error) {
+			understood = append(understood, request.Query)
+			return &Message{Text: request.Query, Outcomes: []Outcome{{Intent: "chat"}}}, nil
+		},
+		Moderator: moderator,
+		Handler: HandlerFunc(func(ctx context.Context, message *Message, session *Session) (*Reply, error) {
+			session.Set("last", message.Text)
+			return &Reply{Text: "well shit, " + message.Text}, nil
+		}),
+		Sessions: NewMemorySessionStore(0),
+	}
+
+	result, err := pipeline.Process(context.Background(), "user-42", "I will kill them")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Blocked || result.Reply.Text != DefaultBlockedReply || len(understood) != 0 {
+		t.Errorf("blocked input should not reach Wit %+v %v", result, understood)
+	}
+	records := result.Metadata[MetadataModeration].([]ModerationRecord)
+	if len(records) != 1 || records[0].Action != ModerationBlock || records[0].Rules[0] != "threats" {
+		t.Errorf("block not recorded %+v", records)
+	}
+
+	if result, err = pipeline.Process(context.Background(), "user-42", "this is bullshit"); err != nil {
+		t.Fatal(err)
+	}
+	if understood[0] != "this is b*******" || result.Reply.Text != "well s***, this is b*******" {
+		t.Errorf("expected masked input and reply, got %v %q", understood, result.Reply.Text)
+	}
+	records = result.Metadata[MetadataModeration].([]ModerationRecord)
+	if len(records) != 2 || records[0].Direction != ModerationInbound || records[1].Direction != ModerationOutbound {
+		t.Errorf("masks not recorded %+v", records)
+	}
+	session, _ := pipeline.Sessions.Load("user-42")
+	if session.Data["last"] != "this is b*******" {
+		t.Errorf("session not saved %+v", session.Data)
+	}
+}
+
+func TestPipelineWithoutMessage(t *testing.T) {
+	router := NewRouter()
+	router.Fallback = HandlerFunc(func(ctx context.Context, message *Message, session *Session) (*Reply, error) {
+		return &Reply{Text: "sorry, " + message.Text}, nil
+	})
+	pipeline := &Pipeline{
+		Understand: func(ctx context.Context, request *MessageRequest) (*Message, error) {
+			return nil, nil
+		},
+		Handler:   router,
+		Sessions:  NewMemorySessionStore(0),
+		CarryOver: &CarryOver{},
+	}
+	result, err := pipeline.Process(context.Background(), "user-42", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Reply.Text != "sorry, hello" || result.Message.ThreadID == "" {
+		t.Errorf("expected a message without intent, got %+v", result)
+	}
+}
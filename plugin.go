@@ -0,0 +1,344 @@
+// Copyright (c) 2014 Jason Goecke
+// plugin.go
+
+package wit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultPluginTimeout is the time a plugin has to answer a request when
+// no timeout is set
+const DefaultPluginTimeout = 5 * time.Second
+
+// MaxPluginLineSize is the size of the longest response line a plugin may write
+const MaxPluginLineSize = 4 << 20
+
+// ErrPluginClosed is returned when handling a message with a closed plugin
+var ErrPluginClosed = errors.New("plugin is closed")
+
+// PluginRequest represents a request written to a plugin's stdin, as a
+// single line of JSON. Entities holds the value of the first entity of
+// each name of the best outcome.
+type PluginRequest struct {
+	ID       string                 `json:"id"`
+	Intent   string                 `json:"intent"`
+	Message  *Message               `json:"message"`
+	Session  *Session               `json:"session,omitempty"`
+	Entities map[string]interface{} `json:"entities"`
+}
+
+// PluginResponse represents the response a plugin writes to its stdout, as
+// a single line of JSON with the id of the request. Session holds the
+// values to store into the session, a null value deletes the key.
+type PluginResponse struct {
+	ID      string                 `json:"id"`
+	Text    string                 `json:"text,omitempty"`
+	Session map[string]interface{} `json:"session,omitempty"`
+	Actions []Action               `json:"actions,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// PluginError represents an error reported by a plugin in its response
+type PluginError struct {
+	Plugin  string
+	Message string
+}
+
+func (err *PluginError) Error() string {
+	return fmt.Sprintf("plugin %s: %s", err.Plugin, err.Message)
+}
+
+// Plugin is a Handler running an executable, exchanging newline-delimited
+// JSON over its stdin and stdout: a PluginRequest goes in and a
+// PluginResponse comes out for each message. Plugins may write logs to
+// stderr. A pool of up to PoolSize processes is started on demand and a
+// process is killed when it does not answer in time, then restarted for
+// the next request, as is a process that exits.
+type Plugin struct {
+	Command string
+	Args    []string
+	// Env is added to the environment of the processes
+	Env []string
+	Dir string
+	// PoolSize is the number of processes, 1 when not set
+	PoolSize int
+	// Timeout is the time a process has to answer, DefaultPluginTimeout when not set
+	Timeout time.Duration
+	// Stderr receives the processes' stderr, os.Stderr when nil
+	Stderr io.Writer
+
+	once  sync.Once
+	slots chan *pluginProcess
+	// done is closed by Close, so requests waiting for a process return
+	done     chan struct{}
+	closed   int32
+	restarts int64
+	requests int64
+}
+
+// pluginProcess is a running plugin executable
+type pluginProcess struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	lines   chan []byte
+	exited  chan struct{}
+	killed  chan struct{}
+	killing sync.Once
+}
+
+// NewPlugin creates a plugin running an executable
+//
+//		plugin := wit.NewPlugin("python3", "handlers/billing.py")
+//		plugin.PoolSize = 4
+//		router.Register("check_balance", plugin)
+func NewPlugin(command string, args ...string) *Plugin {
+	return &Plugin{Command: command, Args: args}
+}
+
+func (plugin *Plugin) init() {
+	plugin.once.Do(func() {
+		size := plugin.PoolSize
+		if size <= 0 {
+			size = 1
+		}
+		plugin.slots = make(chan *pluginProcess, size)
+		plugin.done = make(chan struct{})
+		for i := 0; i < size; i++ {
+			plugin.slots <- nil
+		}
+	})
+}
+
+// Handle sends the message and session to a plugin process and returns its
+// reply, storing the session updates into the session
+//
+//		reply, err := plugin.Handle(ctx, message, session)
+func (plugin *Plugin) Handle(ctx context.Context, message *Message, session *Session) (*Reply, error) {
+	request := &PluginRequest{
+		ID:       strconv.FormatInt(atomic.AddInt64(&plugin.requests, 1), 10),
+		Message:  message,
+		Session:  session,
+		Entities: map[string]interface{}{},
+	}
+	if len(message.Outcomes) > 0 {
+		request.Intent = message.Outcomes[0].Intent
+		for name, entities := range message.Outcomes[0].Entities {
+			if len(entities) > 0 {
+				request.Entities[name] = entityValue(entities[0])
+			}
+		}
+	}
+	response, err := plugin.Call(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, &PluginError{Plugin: plugin.Command, Message: response.Error}
+	}
+	if session != nil {
+		for key, value := range response.Session {
+			if value == nil {
+				delete(session.Data, key)
+			} else {
+				session.Set(key, value)
+			}
+		}
+	}
+	return &Reply{Text: response.Text, Session: response.Session, Actions: response.Actions}, nil
+}
+
+// Call sends a raw request to a plugin process and reads its response
+//
+//		response, err := plugin.Call(ctx, &wit.PluginRequest{ID: "1", Message: message})
+func (plugin *Plugin) Call(ctx context.Context, request *PluginRequest) (*PluginResponse, error) {
+	plugin.init()
+	line, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	if atomic.LoadInt32(&plugin.closed) == 1 {
+		return nil, ErrPluginClosed
+	}
+
+	var process *pluginProcess
+	select {
+	case process = <-plugin.slots:
+	case <-plugin.done:
+		return nil, ErrPluginClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	// The slot is given back with its process, dead ones are replaced by
+	// the next request
+	defer func() { plugin.slots <- process }()
+	if atomic.LoadInt32(&plugin.closed) == 1 {
+		return nil, ErrPluginClosed
+	}
+	if process == nil || process.dead() {
+		started, err := plugin.start()
+		if err != nil {
+			return nil, err
+		}
+		if process != nil {
+			atomic.AddInt64(&plugin.restarts, 1)
+		}
+		process = started
+	}
+
+	if _, err := process.stdin.Write(append(line, '\n')); err != nil {
+		process.kill()
+		return nil, fmt.Errorf("plugin %s: %s", plugin.Command, err)
+	}
+	timeout := plugin.Timeout
+	if timeout <= 0 {
+		timeout = DefaultPluginTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case data, ok := <-process.lines:
+		if !ok {
+			return nil, fmt.Errorf("plugin %s exited", plugin.Command)
+		}
+		response := &PluginResponse{}
+		if err := json.Unmarshal(data, response); err != nil || response.ID != request.ID {
+			process.kill()
+			if err == nil {
+				err = fmt.Errorf("response id %q does not match request id %q", response.ID, request.ID)
+			}
+			return nil, fmt.Errorf("plugin %s: invalid response: %s", plugin.Command, err)
+		}
+		return response, nil
+	case <-timer.C:
+		process.kill()
+		return nil, fmt.Errorf("plugin %s timed out after %s", plugin.Command, timeout)
+	case <-ctx.Done():
+		process.kill()
+		return nil, ctx.Err()
+	}
+}
+
+// Starts a plugin process
+func (plugin *Plugin) start() (*pluginProcess, error) {
+	cmd := exec.Command(plugin.Command, plugin.Args...)
+	cmd.Dir = plugin.Dir
+	if len(plugin.Env) > 0 {
+		cmd.Env = append(os.Environ(), plugin.Env...)
+	}
+	cmd.Stderr = plugin.Stderr
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	process := &pluginProcess{
+		cmd:    cmd,
+		stdin:  stdin,
+		lines:  make(chan []byte),
+		exited: make(chan struct{}),
+		killed: make(chan struct{}),
+	}
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 64*1024), MaxPluginLineSize)
+		for scanner.Scan() {
+			line := append([]byte(nil), scanner.Bytes()...)
+			if len(line) == 0 {
+				continue
+			}
+			select {
+			case process.lines <- line:
+			case <-process.killed:
+			}
+		}
+		// Unblock the process if it still writes after a read error
+		io.Copy(ioutil.Discard, stdout)
+		cmd.Wait()
+		close(process.exited)
+		close(process.lines)
+	}()
+	return process, nil
+}
+
+// Restarts returns the number of processes started to replace processes
+// that exited, crashed or timed out
+//
+//		restarts := plugin.Restarts()
+func (plugin *Plugin) Restarts() int64 {
+	return atomic.LoadInt64(&plugin.restarts)
+}
+
+// Close closes the stdin of the plugin processes, waiting for in-flight
+// requests, and kills those that do not exit within the timeout. Requests
+// waiting for a process, and later ones, return ErrPluginClosed.
+//
+//		defer plugin.Close()
+func (plugin *Plugin) Close() error {
+	plugin.init()
+	if !atomic.CompareAndSwapInt32(&plugin.closed, 0, 1) {
+		return nil
+	}
+	close(plugin.done)
+	timeout := plugin.Timeout
+	if timeout <= 0 {
+		timeout = DefaultPluginTimeout
+	}
+	var err error
+	for i := 0; i < cap(plugin.slots); i++ {
+		process := <-plugin.slots
+		if process == nil || process.dead() {
+			continue
+		}
+		process.stdin.Close()
+		select {
+		case <-process.exited:
+		case <-time.After(timeout):
+			process.kill()
+			err = fmt.Errorf("plugin %s did not exit after its stdin was closed", plugin.Command)
+		}
+	}
+	return err
+}
+
+// Reports whether the process exited or was killed
+func (process *pluginProcess) dead() bool {
+	select {
+	case <-process.exited:
+		return true
+	case <-process.killed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (process *pluginProcess) kill() {
+	process.killing.Do(func() {
+		close(process.killed)
+		process.stdin.Close()
+		process.cmd.Process.Kill()
+	})
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2014 Jason Goecke
+// plugin_test.go
+
+package wit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestPlugin runs this test binary as a plugin, see TestPluginHelperProcess
+func newTestPlugin(mode string) *Plugin {
+	plugin := NewPlugin(os.Args[0], "-test.run=TestPluginHelperProcess")
+	plugin.Env = []string{"GO_WIT_PLUGIN_HELPER=" + mode}
+	plugin.Timeout = 2 * time.Second
+	plugin.Stderr = ioutil.Discard
+	return plugin
+}
+
+// TestPluginHelperProcess is not a real test, it is the plugin run by the
+// plugin tests. It echoes messages, crashes on "crash", hangs on "hang" and
+// with the "deaf" mode keeps running after its stdin is closed.
+func TestPluginHelperProcess(t *testing.T) {
+	mode := os.Getenv("GO_WIT_PLUGIN_HELPER")
+	if mode == "" {
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), MaxPluginLineSize)
+	for scanner.Scan() {
+		request := &PluginRequest{}
+		if err := json.Unmarshal(scanner.Bytes(), request); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		response := &PluginResponse{ID: request.ID}
+		switch request.Message.Text {
+		case "crash":
+			os.Exit(3)
+		case "hang":
+			time.Sleep(time.Minute)
+		case "fail":
+			response.Error = "cannot do that"
+		default:
+			response.Text = fmt.Sprintf("%s: %s (%d)", request.Intent, request.Entities["size"], os.Getpid())
+			response.Session = map[string]interface{}{"seen": true, "drop": nil}
+			response.Actions = []Action{{Type: "handover", Data: map[string]interface{}{"queue": "billing"}}}
+		}
+		data, _ := json.Marshal(response)
+		fmt.Println(string(data))
+	}
+	if mode == "deaf" {
+		time.Sleep(time.Minute)
+	}
+	os.Exit(0)
+}
+
+func pluginMessage(text string) *Message {
+	var size interface{} = "large"
+	return &Message{Text: text, Outcomes: []Outcome{{
+		Intent:   "order_pizza",
+		Entities: map[string][]MessageEntity{"size": {{Value: &size}}},
+	}}}
+}
+
+func TestPlugin(t *testing.T) {
+	plugin := newTestPlugin("echo")
+	defer plugin.Close()
+	session := NewSession("user-42")
+	session.Set("drop", "me")
+
+	reply, err := plugin.Handle(context.Background(), pluginMessage("one large pizza"), session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(reply.Text, "order_pizza: large") || len(reply.Actions) != 1 || reply.Actions[0].Type != "handover" {
+		t.Errorf("unexpected reply %+v", reply)
+	}
+	if _, ok := session.Get("drop"); ok || session.Data["seen"] != true {
+		t.Errorf("session not updated %+v", session.Data)
+	}
+	first := reply.Text
+
+	if _, err := plugin.Handle(context.Background(), pluginMessage("fail"), nil); err == nil {
+		t.Error("expected the plugin's error")
+	} else if _, ok := err.(*PluginError); !ok {
+		t.Errorf("expected a PluginError, got %v", err)
+	}
+	if reply, _ = plugin.Handle(context.Background(), pluginMessage("again"), nil); reply == nil || reply.Text != first {
+		t.Errorf("expected the same process to answer, got %+v", reply)
+	}
+
+	if _, err := plugin.Handle(context.Background(), pluginMessage("crash"), nil); err == nil {
+		t.Error("expected an error from a crashing plugin")
+	}
+	if reply, err = plugin.Handle(context.Background(), pluginMessage("after crash"), nil); err != nil || reply.Text == first {
+		t.Errorf("expected a restarted process to answer, got %+v %v", reply, err)
+	}
+	if plugin.Restarts() != 1 {
+		t.Errorf("expected 1 restart, got %d", plugin.Restarts())
+	}
+}
+
+func TestPluginTimeoutAndPool(t *testing.T) {
+	plugin := newTestPlugin("echo")
+	plugin.Timeout = 200 * time.Millisecond
+	plugin.PoolSize = 2
+	defer plugin.Close()
+
+	start := time.Now()
+	done := make(chan error)
+	go func() {
+		_, err := plugin.Handle(context.Background(), pluginMessage("hang"), nil)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := plugin.Handle(context.Background(), pluginMessage("hello"), nil); err != nil {
+		t.Errorf("expected the second process to answer, got %v", err)
+	}
+	if err := <-done; err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("timeout took %s", elapsed)
+	}
+
+	plugin.Close()
+	if _, err := plugin.Handle(context.Background(), pluginMessage("hello"), nil); err != ErrPluginClosed {
+		t.Errorf("expected ErrPluginClosed, got %v", err)
+	}
+}
+
+func TestPluginCloseReleasesWaitingCalls(t *testing.T) {
+	plugin := newTestPlugin("echo")
+	plugin.Timeout = time.Second
+
+	busy := make(chan error)
+	go func() {
+		_, err := plugin.Handle(context.Background(), pluginMessage("hang"), nil)
+		busy <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	waiting := make(chan error)
+	go func() {
+		_, err := plugin.Handle(context.Background(), pluginMessage("hello"), nil)
+		waiting <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	go plugin.Close()
+	select {
+	case err := <-waiting:
+		if err != ErrPluginClosed || time.Since(start) > 500*time.Millisecond {
+			t.Errorf("expected ErrPluginClosed right away, got %v after %s", err, time.Since(start))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the waiting call blocked after Close")
+	}
+	<-busy
+}
+
+func TestCheckPlugin(t *testing.T) {
+	conformance := CheckPlugin(context.Background(), newTestPlugin("echo"))
+	if !conformance.Passed {
+		t.Errorf("expected the echo plugin to conform %+v", conformance.Checks)
+	}
+
+	plugin := newTestPlugin("deaf")
+	plugin.Timeout = 200 * time.Millisecond
+	conformance = CheckPlugin(context.Background(), plugin)
+	last := conformance.Checks[len(conformance.Checks)-1]
+	if conformance.Passed || last.Passed {
+		t.Errorf("expected a plugin ignoring EOF to fail %+v", conformance.Checks)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2014 Jason Goecke
+// plugincheck.go
+
+package wit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PluginCheck represents the outcome of one conformance check
+type PluginCheck struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// PluginConformance represents the outcome of checking a plugin against
+// the protocol
+type PluginConformance struct {
+	Passed bool          `json:"passed"`
+	Checks []PluginCheck `json:"checks"`
+}
+
+// CheckPlugin runs a plugin through the protocol's conformance checks:
+// answering with the request id, handling non-ASCII text, large messages,
+// unknown intents and sessions, staying up across requests and exiting
+// once its stdin is closed. The plugin is closed when the checks are done.
+//
+//		conformance := wit.CheckPlugin(ctx, wit.NewPlugin("python3", "handlers/billing.py"))
+//		for _, check := range conformance.Checks {
+//			log.Printf("%s passed: %t %s", check.Name, check.Passed, check.Error)
+//		}
+func CheckPlugin(ctx context.Context, plugin *Plugin) *PluginConformance {
+	conformance := &PluginConformance{Passed: true}
+	check := func(name string, err error) {
+		result := PluginCheck{Name: name, Passed: err == nil}
+		if err != nil {
+			result.Error = err.Error()
+			conformance.Passed = false
+		}
+		conformance.Checks = append(conformance.Checks, result)
+	}
+	call := func(id string, message *Message, session *Session) error {
+		request := &PluginRequest{ID: id, Message: message, Session: session, Entities: map[string]interface{}{}}
+		if len(message.Outcomes) > 0 {
+			request.Intent = message.Outcomes[0].Intent
+		}
+		_, err := plugin.Call(ctx, request)
+		return err
+	}
+	message := func(text string, intent string) *Message {
+		return &Message{MsgID: "conformance", Text: text, Outcomes: []Outcome{{
+			Text: text, Intent: intent, Entities: map[string][]MessageEntity{}, Confidence: 1,
+		}}}
+	}
+
+	check("responds with the request id", call("conformance-1", message("hello", "greeting"), nil))
+	check("handles non-ASCII text", call("conformance-2", message("héllo wörld, 你好 👋", "greeting"), nil))
+	check("handles large messages", call("conformance-3", message(strings.Repeat("lorem ipsum ", 20000), "greeting"), nil))
+	check("handles unknown intents", call("conformance-4", message("qwerty", "conformance_unknown_intent"), nil))
+	session := NewSession("conformance")
+	session.Set("counter", 1)
+	session.Set("nested", map[string]interface{}{"list": []interface{}{"a", "b"}})
+	check("handles sessions", call("conformance-5", message("hello", "greeting"), session))
+	var err error
+	for i := 0; i < 20 && err == nil; i++ {
+		err = call("conformance-seq-"+strconv.Itoa(i), message("hello "+strconv.Itoa(i), "greeting"), nil)
+	}
+	check("answers sequential requests", err)
+	err = nil
+	if restarts := plugin.Restarts(); restarts > 0 {
+		err = fmt.Errorf("%d processes were restarted", restarts)
+	}
+	check("stays up across requests", err)
+	check("exits when its stdin is closed", plugin.Close())
+	return conformance
+}
@@ -0,0 +1,547 @@
+// Copyright (c) 2014 Jason Goecke
+// proxy.go
+
+package wit
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Values of the X-Wit-Proxy-Cache header set on proxied responses
+const (
+	ProxyCacheHit       = "hit"
+	ProxyCacheMiss      = "miss"
+	ProxyCacheCoalesced = "coalesced"
+)
+
+// DefaultProxyCacheSize is the number of /message responses cached when
+// the configuration sets a TTL but no size
+const DefaultProxyCacheSize = 10000
+
+// DefaultProxyMaxBodySize is the largest request body the proxy reads when
+// the configuration sets none, enough for the audio of /speech requests
+const DefaultProxyMaxBodySize = 20 << 20
+
+// DefaultProxyTimeout bounds the upstream /message requests shared by
+// several clients, which no single client's context cancels
+const DefaultProxyTimeout = 30 * time.Second
+
+// ProxyQuota represents the number of requests a client may make per window
+type ProxyQuota struct {
+	Requests int           `json:"requests"`
+	Window   time.Duration `json:"window"`
+}
+
+// ProxyToken represents a client of the proxy. Its requests are made
+// upstream with Token, the token in the TokenEnv environment variable, or
+// the proxy client's token when neither is set.
+type ProxyToken struct {
+	Name     string      `json:"name,omitempty"`
+	Token    string      `json:"token,omitempty"`
+	TokenEnv string      `json:"token_env,omitempty"`
+	Quota    *ProxyQuota `json:"quota,omitempty"`
+}
+
+// ProxyConfig represents the configuration of a proxy, e.g.
+//
+//		tokens:
+//		  client-token-for-billing:
+//		    name: billing
+//		    token_env: WIT_BILLING_TOKEN
+//		    quota:
+//		      requests: 1000
+//		      window: 1h
+//		quota:
+//		  requests: 100
+//		  window: 1m
+//		cache_ttl: 10m
+//		cache_size: 5000
+//		audit_log: /var/log/wit-proxy.log
+//
+// Without tokens, incoming tokens are forwarded upstream unchanged.
+type ProxyConfig struct {
+	Tokens map[string]*ProxyToken `json:"tokens,omitempty"`
+	// Quota applies to clients without a quota of their own
+	Quota     *ProxyQuota   `json:"quota,omitempty"`
+	CacheTTL  time.Duration `json:"cache_ttl,omitempty"`
+	CacheSize int           `json:"cache_size,omitempty"`
+	// MaxBodySize is the largest request body accepted, in bytes,
+	// DefaultProxyMaxBodySize when 0
+	MaxBodySize int64 `json:"max_body_size,omitempty"`
+	// AuditLog is the file audit entries are appended to, see LoadProxy
+	AuditLog string `json:"audit_log,omitempty"`
+}
+
+// ProxyAuditEntry represents a request made through the proxy. Query
+// strings are left out, as they hold what users said, except for the
+// msg_id and thread_id correlating requests.
+type ProxyAuditEntry struct {
+	Time       time.Time `json:"time"`
+	Client     string    `json:"client"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	MsgID      string    `json:"msg_id,omitempty"`
+	ThreadID   string    `json:"thread_id,omitempty"`
+	Status     int       `json:"status"`
+	Cache      string    `json:"cache,omitempty"`
+	DurationMS float64   `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// ProxyStats represents the counters of a proxy
+type ProxyStats struct {
+	Requests        int64 `json:"requests"`
+	Upstream        int64 `json:"upstream"`
+	CacheHits       int64 `json:"cache_hits"`
+	Coalesced       int64 `json:"coalesced"`
+	Unauthorized    int64 `json:"unauthorized"`
+	QuotaExceeded   int64 `json:"quota_exceeded"`
+	UpstreamErrors  int64 `json:"upstream_errors"`
+	CachedResponses int   `json:"cached_responses"`
+}
+
+// Proxy is an HTTP handler speaking the Wit API, forwarding requests with
+// Client's base URL, version, rate limiter and HTTP client. It maps client
+// tokens to upstream tokens, caches /message responses, coalesces
+// identical /message requests in flight, enforces quotas and writes an
+// audit log.
+type Proxy struct {
+	Client *Client
+	Config *ProxyConfig
+	// QuotaStore counts the requests of each client, share one between
+	// replicas so quotas apply across them
+	QuotaStore ThrottleStore
+	// AuditLog receives an audit entry per request, as a JSON line
+	AuditLog io.Writer
+
+	mutex    sync.Mutex
+	cache    *proxyCache
+	inflight map[string]*proxyCall
+	stats    ProxyStats
+}
+
+// A response to be written to a client of the proxy
+type proxyResponse struct {
+	status      int
+	contentType string
+	body        []byte
+	// retryAfter is set when the client's quota is exceeded, to the time
+	// left in its window
+	retryAfter time.Duration
+}
+
+// A /message request in flight, shared by identical requests
+type proxyCall struct {
+	done     chan struct{}
+	response *proxyResponse
+	err      string
+}
+
+// NewProxy creates a proxy forwarding requests with the client
+//
+//		proxy := wit.NewProxy(client, &wit.ProxyConfig{CacheTTL: 10 * time.Minute})
+//		http.ListenAndServe(":8080", proxy)
+func NewProxy(client *Client, config *ProxyConfig) *Proxy {
+	if config == nil {
+		config = &ProxyConfig{}
+	}
+	proxy := &Proxy{Client: client, Config: config, QuotaStore: NewMemoryThrottleStore(), inflight: map[string]*proxyCall{}}
+	if config.CacheTTL > 0 {
+		size := config.CacheSize
+		if size <= 0 {
+			size = DefaultProxyCacheSize
+		}
+		proxy.cache = newProxyCache(size, config.CacheTTL)
+	}
+	return proxy
+}
+
+// LoadProxy reads a YAML proxy configuration and creates the proxy,
+// appending its audit log to the configured file
+//
+//		proxy, err := wit.LoadProxy("./proxy.yaml", client)
+func LoadProxy(path string, client *Client) (*Proxy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &ProxyConfig{}
+	if err := unmarshalYAML(data, config); err != nil {
+		return nil, err
+	}
+	proxy := NewProxy(client, config)
+	if config.AuditLog != "" {
+		file, err := os.OpenFile(expandHome(config.AuditLog), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		proxy.AuditLog = file
+	}
+	return proxy, nil
+}
+
+// Stats returns the proxy's counters
+//
+//		stats := proxy.Stats()
+func (proxy *Proxy) Stats() ProxyStats {
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+	stats := proxy.stats
+	if proxy.cache != nil {
+		stats.CachedResponses = proxy.cache.items.Len()
+	}
+	return stats
+}
+
+// ServeHTTP authenticates and forwards a request to the Wit API
+func (proxy *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	query := r.URL.Query()
+	entry := &ProxyAuditEntry{Time: start.UTC(), Method: r.Method, Path: r.URL.Path, MsgID: query.Get("msg_id"), ThreadID: query.Get("thread_id")}
+	limit := proxy.Config.MaxBodySize
+	if limit <= 0 {
+		limit = DefaultProxyMaxBodySize
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	response := proxy.serve(r, entry)
+	entry.Status = response.status
+	entry.DurationMS = float64(time.Since(start)) / float64(time.Millisecond)
+	proxy.audit(entry)
+
+	if response.contentType != "" {
+		w.Header().Set("Content-Type", response.contentType)
+	}
+	if entry.Cache != "" {
+		w.Header().Set("X-Wit-Proxy-Cache", entry.Cache)
+	}
+	if response.retryAfter > 0 {
+		seconds := int((response.retryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+	w.WriteHeader(response.status)
+	w.Write(response.body)
+}
+
+func (proxy *Proxy) serve(r *http.Request, entry *ProxyAuditEntry) *proxyResponse {
+	proxy.count(func(stats *ProxyStats) { stats.Requests++ })
+	incoming := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer"))
+	upstream, name, token, ok := proxy.upstreamToken(incoming)
+	entry.Client = name
+	if !ok {
+		proxy.count(func(stats *ProxyStats) { stats.Unauthorized++ })
+		entry.Error = "unknown token"
+		return proxyError(http.StatusUnauthorized, entry.Error)
+	}
+
+	if quota := proxy.quota(token); quota != nil && quota.Requests > 0 && quota.Window > 0 {
+		key := "wit:proxy:" + name
+		count, err := proxy.QuotaStore.Increment(r.Context(), key, quota.Window)
+		if err == nil && count == 1 {
+			// Marks the end of the window, for the Retry-After of the
+			// requests over the quota
+			err = proxy.QuotaStore.Block(r.Context(), key+":window", quota.Window)
+		}
+		if err != nil {
+			entry.Error = err.Error()
+			return proxyError(http.StatusInternalServerError, entry.Error)
+		}
+		if count > int64(quota.Requests) {
+			proxy.count(func(stats *ProxyStats) { stats.QuotaExceeded++ })
+			entry.Error = "quota exceeded"
+			response := proxyError(http.StatusTooManyRequests, entry.Error)
+			response.retryAfter = quota.Window
+			if left, err := proxy.QuotaStore.Blocked(r.Context(), key+":window"); err == nil && left > 0 && left < quota.Window {
+				response.retryAfter = left
+			}
+			return response
+		}
+	}
+
+	query := r.URL.Query()
+	body, err := ioutil.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		entry.Error = "request body too large"
+		return proxyError(http.StatusRequestEntityTooLarge, entry.Error)
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		return proxyError(http.StatusBadRequest, entry.Error)
+	}
+	if r.Method != "GET" || r.URL.Path != "/message" {
+		return proxy.forward(r, upstream, body, entry)
+	}
+
+	// Responses depend on the upstream app, so they are cached per token.
+	// The ids correlating a request are left out of the key, clients send
+	// a new msg_id each time.
+	key := HashSubject(upstream) + " " + proxyCacheKey(r.URL)
+	proxy.mutex.Lock()
+	if proxy.cache != nil {
+		if response := proxy.cache.get(key); response != nil {
+			proxy.stats.CacheHits++
+			proxy.mutex.Unlock()
+			entry.Cache = ProxyCacheHit
+			return correlateResponse(response, query)
+		}
+	}
+	if call, ok := proxy.inflight[key]; ok {
+		proxy.stats.Coalesced++
+		proxy.mutex.Unlock()
+		entry.Cache = ProxyCacheCoalesced
+		select {
+		case <-call.done:
+			return correlateResponse(call.response, query)
+		case <-r.Context().Done():
+			entry.Error = r.Context().Err().Error()
+			return proxyError(http.StatusGatewayTimeout, entry.Error)
+		}
+	}
+	call := &proxyCall{done: make(chan struct{})}
+	proxy.inflight[key] = call
+	proxy.mutex.Unlock()
+
+	// The call is shared, so it runs under its own context and every
+	// client, this one included, only gives up on its own context
+	entry.Cache = ProxyCacheMiss
+	req, err := proxy.upstreamRequest(r, upstream, body)
+	go func() {
+		callEntry := &ProxyAuditEntry{}
+		if err != nil {
+			callEntry.Error = err.Error()
+			call.response = proxyError(http.StatusBadGateway, callEntry.Error)
+		} else {
+			ctx, cancel := context.WithTimeout(context.Background(), DefaultProxyTimeout)
+			call.response = proxy.send(req.WithContext(ctx), callEntry)
+			cancel()
+		}
+		call.err = callEntry.Error
+		proxy.mutex.Lock()
+		delete(proxy.inflight, key)
+		if proxy.cache != nil && call.response.status == http.StatusOK {
+			proxy.cache.add(key, call.response)
+		}
+		proxy.mutex.Unlock()
+		close(call.done)
+	}()
+	select {
+	case <-call.done:
+		entry.Error = call.err
+		return call.response
+	case <-r.Context().Done():
+		entry.Error = r.Context().Err().Error()
+		return proxyError(http.StatusGatewayTimeout, entry.Error)
+	}
+}
+
+// Returns the path and query of a request without its msg_id and thread_id
+func proxyCacheKey(u *url.URL) string {
+	query := u.Query()
+	query.Del("msg_id")
+	query.Del("thread_id")
+	return u.Path + "?" + query.Encode()
+}
+
+// Returns a shared response with the msg_id and thread_id Wit echoes set to
+// the request's
+func correlateResponse(response *proxyResponse, query url.Values) *proxyResponse {
+	fields := map[string]json.RawMessage{}
+	if json.Unmarshal(response.body, &fields) != nil {
+		return response
+	}
+	changed := false
+	for _, key := range []string{"msg_id", "thread_id"} {
+		if _, ok := fields[key]; !ok {
+			continue
+		}
+		if value := query.Get(key); value != "" {
+			fields[key], _ = json.Marshal(value)
+		} else {
+			delete(fields, key)
+		}
+		changed = true
+	}
+	if !changed {
+		return response
+	}
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return response
+	}
+	return &proxyResponse{status: response.status, contentType: response.contentType, body: body}
+}
+
+// Returns the upstream token, client name and configured token for an
+// incoming token, the configured token being nil without tokens
+func (proxy *Proxy) upstreamToken(incoming string) (string, string, *ProxyToken, bool) {
+	if len(proxy.Config.Tokens) == 0 {
+		if incoming == "" {
+			return "", "anonymous", nil, false
+		}
+		return incoming, HashSubject(incoming)[:12], nil, true
+	}
+	token, ok := proxy.Config.Tokens[incoming]
+	if !ok || incoming == "" || token == nil {
+		return "", "anonymous", nil, false
+	}
+	name := token.Name
+	if name == "" {
+		name = HashSubject(incoming)[:12]
+	}
+	upstream := token.Token
+	if token.TokenEnv != "" {
+		upstream = os.Getenv(token.TokenEnv)
+	}
+	if upstream == "" {
+		upstream = proxy.Client.APIKey
+	}
+	if upstream == "" {
+		upstream = APIKey
+	}
+	return upstream, name, token, true
+}
+
+// Returns the quota of a client, its token's or else the default
+func (proxy *Proxy) quota(token *ProxyToken) *ProxyQuota {
+	if token != nil && token.Quota != nil {
+		return token.Quota
+	}
+	return proxy.Config.Quota
+}
+
+// Makes the request upstream, keeping the path and query unchanged
+func (proxy *Proxy) forward(r *http.Request, token string, body []byte, entry *ProxyAuditEntry) *proxyResponse {
+	req, err := proxy.upstreamRequest(r, token, body)
+	if err != nil {
+		entry.Error = err.Error()
+		return proxyError(http.StatusBadGateway, entry.Error)
+	}
+	return proxy.send(req.WithContext(r.Context()), entry)
+}
+
+// Creates the upstream request of an incoming request
+func (proxy *Proxy) upstreamRequest(r *http.Request, token string, body []byte) (*http.Request, error) {
+	base := proxy.Client.APIBase
+	if base == "" {
+		base = "https://api.wit.ai"
+	}
+	req, err := http.NewRequest(r.Method, strings.TrimSuffix(base, "/")+r.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	for _, header := range []string{"Accept", "Content-Type"} {
+		if value := r.Header.Get(header); value != "" {
+			req.Header.Set(header, value)
+		}
+	}
+	return req, nil
+}
+
+// Sends a request upstream, until its context is done
+func (proxy *Proxy) send(req *http.Request, entry *ProxyAuditEntry) *proxyResponse {
+	proxy.count(func(stats *ProxyStats) { stats.Upstream++ })
+	if proxy.Client.RateLimiter != nil {
+		if err := proxy.Client.RateLimiter.Wait(req.Context()); err != nil {
+			entry.Error = err.Error()
+			return proxyError(http.StatusGatewayTimeout, entry.Error)
+		}
+	}
+	result, err := proxy.Client.do(req)
+	if err != nil {
+		proxy.count(func(stats *ProxyStats) { stats.UpstreamErrors++ })
+		entry.Error = err.Error()
+		return proxyError(http.StatusBadGateway, entry.Error)
+	}
+	defer result.Body.Close()
+	data, err := ioutil.ReadAll(result.Body)
+	if err != nil {
+		proxy.count(func(stats *ProxyStats) { stats.UpstreamErrors++ })
+		entry.Error = err.Error()
+		return proxyError(http.StatusBadGateway, entry.Error)
+	}
+	return &proxyResponse{status: result.StatusCode, contentType: result.Header.Get("Content-Type"), body: data}
+}
+
+func (proxy *Proxy) count(update func(stats *ProxyStats)) {
+	proxy.mutex.Lock()
+	update(&proxy.stats)
+	proxy.mutex.Unlock()
+}
+
+func (proxy *Proxy) audit(entry *ProxyAuditEntry) {
+	if proxy.AuditLog == nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+	proxy.AuditLog.Write(append(data, '\n'))
+}
+
+// Builds an error response in the Wit API's format
+func proxyError(status int, message string) *proxyResponse {
+	data, _ := json.Marshal(map[string]string{"error": message, "code": "proxy"})
+	return &proxyResponse{status: status, contentType: "application/json", body: data}
+}
+
+// A least recently used cache of responses, expiring them after ttl
+type proxyCache struct {
+	size  int
+	ttl   time.Duration
+	items *list.List
+	index map[string]*list.Element
+}
+
+type proxyCacheItem struct {
+	key      string
+	response *proxyResponse
+	expires  time.Time
+}
+
+func newProxyCache(size int, ttl time.Duration) *proxyCache {
+	return &proxyCache{size: size, ttl: ttl, items: list.New(), index: map[string]*list.Element{}}
+}
+
+func (cache *proxyCache) get(key string) *proxyResponse {
+	element, ok := cache.index[key]
+	if !ok {
+		return nil
+	}
+	item := element.Value.(*proxyCacheItem)
+	if time.Now().After(item.expires) {
+		cache.items.Remove(element)
+		delete(cache.index, key)
+		return nil
+	}
+	cache.items.MoveToFront(element)
+	return item.response
+}
+
+func (cache *proxyCache) add(key string, response *proxyResponse) {
+	if element, ok := cache.index[key]; ok {
+		cache.items.Remove(element)
+	}
+	cache.index[key] = cache.items.PushFront(&proxyCacheItem{key: key, response: response, expires: time.Now().Add(cache.ttl)})
+	for cache.items.Len() > cache.size {
+		oldest := cache.items.Back()
+		cache.items.Remove(oldest)
+		delete(cache.index, oldest.Value.(*proxyCacheItem).key)
+	}
+}
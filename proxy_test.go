@@ -0,0 +1,309 @@
+// Copyright (c) 2014 Jason Goecke
+// proxy_test.go
+
+package wit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProxy(t *testing.T) {
+	var upstreamRequests int64
+	var lastAuthorization atomic.Value
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&upstreamRequests, 1)
+		lastAuthorization.Store(r.Header.Get("Authorization"))
+		if r.URL.Query().Get("v") == "" {
+			http.Error(w, "no version", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"_text":"` + r.URL.Query().Get("q") + `"}`))
+	}))
+	defer upstream.Close()
+
+	client := NewClient("upstream-default")
+	client.APIBase = upstream.URL
+	var audit bytes.Buffer
+	proxy := NewProxy(client, &ProxyConfig{
+		Tokens: map[string]*ProxyToken{
+			"billing-token": {Name: "billing", Token: "upstream-billing", Quota: &ProxyQuota{Requests: 3, Window: time.Minute}},
+			"search-token":  {Name: "search"},
+		},
+		CacheTTL: time.Minute,
+	})
+	proxy.AuditLog = &audit
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	get := func(token string, path string) *http.Response {
+		req, _ := http.NewRequest("GET", server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := get("billing-token", "/message?v=20151127&q=hello"); resp.StatusCode != 200 || resp.Header.Get("X-Wit-Proxy-Cache") != ProxyCacheMiss {
+		t.Fatalf("expected a miss, got %d %v", resp.StatusCode, resp.Header)
+	}
+	if lastAuthorization.Load() != "Bearer upstream-billing" {
+		t.Errorf("token not mapped: %v", lastAuthorization.Load())
+	}
+	if resp := get("billing-token", "/message?v=20151127&q=hello"); resp.Header.Get("X-Wit-Proxy-Cache") != ProxyCacheHit {
+		t.Errorf("expected a hit, got %v", resp.Header)
+	}
+	if resp := get("search-token", "/message?v=20151127&q=hello"); resp.Header.Get("X-Wit-Proxy-Cache") != ProxyCacheMiss {
+		t.Errorf("responses should be cached per upstream token, got %v", resp.Header)
+	}
+	if lastAuthorization.Load() != "Bearer upstream-default" {
+		t.Errorf("expected the client's token, got %v", lastAuthorization.Load())
+	}
+	if resp := get("billing-token", "/entities?v=20151127"); resp.StatusCode != 200 || resp.Header.Get("X-Wit-Proxy-Cache") != "" {
+		t.Errorf("expected an uncached request, got %d %v", resp.StatusCode, resp.Header)
+	}
+	if resp := get("billing-token", "/message?v=20151127&q=hello"); resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "60" {
+		t.Errorf("expected the quota to be exceeded, got %d %v", resp.StatusCode, resp.Header)
+	}
+	if resp := get("unknown", "/message?v=20151127&q=hello"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected an unknown token to be refused, got %d", resp.StatusCode)
+	}
+
+	stats := proxy.Stats()
+	if stats.Requests != 6 || stats.Upstream != 3 || stats.CacheHits != 1 || stats.QuotaExceeded != 1 || stats.Unauthorized != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if upstreamRequests != 3 {
+		t.Errorf("expected 3 upstream requests, got %d", upstreamRequests)
+	}
+
+	lines := strings.Split(strings.TrimSpace(audit.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("expected 6 audit entries, got %d", len(lines))
+	}
+	entry := &ProxyAuditEntry{}
+	if err := json.Unmarshal([]byte(lines[0]), entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Client != "billing" || entry.Path != "/message" || entry.Status != 200 || entry.Cache != ProxyCacheMiss {
+		t.Errorf("unexpected audit entry %+v", entry)
+	}
+	if strings.Contains(audit.String(), "hello") || strings.Contains(audit.String(), "billing-token") {
+		t.Error("the audit log should not hold queries or tokens")
+	}
+}
+
+func TestProxyCoalescing(t *testing.T) {
+	var upstreamRequests int64
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&upstreamRequests, 1)
+		<-release
+		w.Write([]byte(`{"_text":"hi"}`))
+	}))
+	defer upstream.Close()
+
+	client := NewClient("token")
+	client.APIBase = upstream.URL
+	proxy := NewProxy(client, nil)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	statuses := make(chan int, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", server.URL+"/message?v=20151127&q=hi", nil)
+			req.Header.Set("Authorization", "Bearer token")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	for deadline := time.Now().Add(5 * time.Second); proxy.Stats().Coalesced < 4 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(statuses)
+	for status := range statuses {
+		if status != 200 {
+			t.Errorf("expected 200, got %d", status)
+		}
+	}
+	if upstreamRequests != 1 {
+		t.Errorf("expected one upstream request, got %d", upstreamRequests)
+	}
+}
+
+func TestProxyCacheIgnoresCorrelationIDs(t *testing.T) {
+	var upstreamRequests int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&upstreamRequests, 1)
+		query := r.URL.Query()
+		w.Write([]byte(`{"msg_id":"` + query.Get("msg_id") + `","thread_id":"` + query.Get("thread_id") + `","_text":"hi","outcomes":[]}`))
+	}))
+	defer upstream.Close()
+	upstreamClient := NewClient("token")
+	upstreamClient.APIBase = upstream.URL
+	proxy := NewProxy(upstreamClient, &ProxyConfig{CacheTTL: time.Minute})
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	client := NewClient("token")
+	client.APIBase = server.URL
+	first, err := client.MessageContext(WithCorrelationID(context.Background(), "req-1"), &MessageRequest{Query: "hi", ThreadID: "thread-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := client.MessageContext(WithCorrelationID(context.Background(), "req-2"), &MessageRequest{Query: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upstreamRequests != 1 || proxy.Stats().CacheHits != 1 {
+		t.Errorf("expected requests differing by ids to hit the cache, got %d upstream requests", upstreamRequests)
+	}
+	if first.MsgID != "req-1" || first.ThreadID != "thread-1" || second.MsgID != "req-2" || second.ThreadID != "" {
+		t.Errorf("expected each response to echo its own ids, got %+v %+v", first, second)
+	}
+}
+
+func TestProxyQuotaOfUnnamedToken(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer upstream.Close()
+	client := NewClient("token")
+	client.APIBase = upstream.URL
+	proxy := NewProxy(client, &ProxyConfig{
+		Tokens: map[string]*ProxyToken{"unnamed-token": {Quota: &ProxyQuota{Requests: 1, Window: time.Hour}}},
+		Quota:  &ProxyQuota{Requests: 100, Window: time.Minute},
+	})
+	statuses := []int{}
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/entities?v=20151127", nil)
+		req.Header.Set("Authorization", "Bearer unnamed-token")
+		recorder := httptest.NewRecorder()
+		proxy.ServeHTTP(recorder, req)
+		statuses = append(statuses, recorder.Code)
+		if i == 1 && recorder.Header().Get("Retry-After") != "3600" {
+			t.Errorf("expected the token's window, got %v", recorder.Header())
+		}
+	}
+	if statuses[0] != 200 || statuses[1] != http.StatusTooManyRequests {
+		t.Errorf("expected the token's own quota to apply, got %v", statuses)
+	}
+}
+
+func TestProxyRetryAfter(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer upstream.Close()
+	client := NewClient("token")
+	client.APIBase = upstream.URL
+	retryAfter := func(window time.Duration, wait time.Duration) string {
+		proxy := NewProxy(client, &ProxyConfig{Quota: &ProxyQuota{Requests: 1, Window: window}})
+		var header string
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest("GET", "/entities?v=20151127", nil)
+			req.Header.Set("Authorization", "Bearer token")
+			recorder := httptest.NewRecorder()
+			proxy.ServeHTTP(recorder, req)
+			header = recorder.Header().Get("Retry-After")
+			if i == 0 {
+				time.Sleep(wait)
+			}
+		}
+		return header
+	}
+	if header := retryAfter(300*time.Millisecond, 0); header != "1" {
+		t.Errorf("expected windows under a second to round up to 1, got %q", header)
+	}
+	if header := retryAfter(3*time.Second, 1100*time.Millisecond); header != "2" {
+		t.Errorf("expected the time left in the window, got %q", header)
+	}
+}
+
+func TestProxyCoalescingSurvivesLeaderCancel(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"_text":"hi"}`))
+	}))
+	defer upstream.Close()
+	client := NewClient("token")
+	client.APIBase = upstream.URL
+	proxy := NewProxy(client, nil)
+
+	serve := func(ctx context.Context) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/message?v=20151127&q=hi", nil).WithContext(ctx)
+		req.Header.Set("Authorization", "Bearer token")
+		recorder := httptest.NewRecorder()
+		proxy.ServeHTTP(recorder, req)
+		return recorder
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan int, 1)
+	go func() { leader <- serve(ctx).Code }()
+	for deadline := time.Now().Add(5 * time.Second); proxy.Stats().Upstream < 1 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	waiter := make(chan int, 1)
+	go func() { waiter <- serve(context.Background()).Code }()
+	for deadline := time.Now().Add(5 * time.Second); proxy.Stats().Coalesced < 1 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	if status := <-leader; status != http.StatusGatewayTimeout {
+		t.Errorf("expected the cancelled client to give up, got %d", status)
+	}
+	close(release)
+	if status := <-waiter; status != http.StatusOK {
+		t.Errorf("expected the waiting client to get the response, got %d", status)
+	}
+}
+
+func TestProxyMaxBodySize(t *testing.T) {
+	upstreamRequests := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests++
+		w.Write([]byte(`{}`))
+	}))
+	defer upstream.Close()
+	client := NewClient("token")
+	client.APIBase = upstream.URL
+	proxy := NewProxy(client, &ProxyConfig{MaxBodySize: 16})
+	post := func(body string) int {
+		req := httptest.NewRequest("POST", "/speech?v=20151127", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer token")
+		recorder := httptest.NewRecorder()
+		proxy.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+	if status := post(strings.Repeat("a", 16)); status != 200 {
+		t.Errorf("expected a body at the limit to pass, got %d", status)
+	}
+	if status := post(strings.Repeat("a", 17)); status != http.StatusRequestEntityTooLarge || upstreamRequests != 1 {
+		t.Errorf("expected 413 without an upstream request, got %d after %d requests", status, upstreamRequests)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2014 Jason Goecke
+// ratelimit.go
+
+package wit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting the rate of requests, allowing
+// bursts of up to Burst requests
+type RateLimiter struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a rate limiter allowing requestsPerSecond on
+// average, in bursts of up to burst requests (at least one)
+//
+//		client.RateLimiter = wit.NewRateLimiter(10, 20)
+func NewRateLimiter(requestsPerSecond float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{rate: requestsPerSecond, burst: float64(burst), tokens: float64(burst)}
+}
+
+// Wait blocks until a request is allowed or the context is done
+//
+//		err := limiter.Wait(ctx)
+func (limiter *RateLimiter) Wait(ctx context.Context) error {
+	delay := limiter.reserve()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		limiter.cancel()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Takes a token, returning how long to wait before it is available
+func (limiter *RateLimiter) reserve() time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if limiter.rate <= 0 {
+		return 0
+	}
+	now := time.Now()
+	if !limiter.last.IsZero() {
+		limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.rate
+		if limiter.tokens > limiter.burst {
+			limiter.tokens = limiter.burst
+		}
+	}
+	limiter.last = now
+	limiter.tokens--
+	if limiter.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-limiter.tokens / limiter.rate * float64(time.Second))
+}
+
+// Returns a token reserved by a wait that was cancelled
+func (limiter *RateLimiter) cancel() {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.tokens++
+}
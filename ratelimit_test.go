@@ -0,0 +1,32 @@
+// Copyright (c) 2014 Jason Goecke
+// ratelimit_test.go
+
+package wit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(50, 2)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Two requests in the burst, two more at 20ms intervals
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond || elapsed > time.Second {
+		t.Errorf("unexpected elapsed time %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	limiter = NewRateLimiter(0.001, 1)
+	limiter.Wait(ctx)
+	if err := limiter.Wait(ctx); err != context.Canceled {
+		t.Errorf("expected the wait to be cancelled, got %v", err)
+	}
+}
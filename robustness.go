@@ -0,0 +1,326 @@
+// Copyright (c) 2014 Jason Goecke
+// robustness.go
+
+package wit
+
+import (
+	"math/rand"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Perturbations applied to utterances to test robustness
+const (
+	PerturbTypo        = "typo"
+	PerturbPunctuation = "punctuation"
+	PerturbCasing      = "casing"
+	PerturbHomophone   = "homophone"
+	PerturbFiller      = "filler"
+)
+
+// Perturbations lists every perturbation type
+var Perturbations = []string{PerturbTypo, PerturbPunctuation, PerturbCasing, PerturbHomophone, PerturbFiller}
+
+// Keys next to each other on a QWERTY keyboard
+var keyboardNeighbors = map[rune]string{
+	'q': "wa", 'w': "qeas", 'e': "wrsd", 'r': "etdf", 't': "ryfg", 'y': "tugh", 'u': "yihj", 'i': "uojk",
+	'o': "ipkl", 'p': "ol", 'a': "qwsz", 's': "weadzx", 'd': "erfsxc", 'f': "rtgdcv", 'g': "tyhfvb",
+	'h': "yujgbn", 'j': "uikhnm", 'k': "iojlm", 'l': "opk", 'z': "asx", 'x': "zsdc", 'c': "xdfv",
+	'v': "cfgb", 'b': "vghn", 'n': "bhjm", 'm': "njk",
+}
+
+// Groups of words that speech recognition commonly confuses
+var homophoneGroups = [][]string{
+	{"to", "too", "two"}, {"for", "four"}, {"there", "their", "they're"}, {"right", "write"},
+	{"by", "buy", "bye"}, {"no", "know"}, {"hear", "here"}, {"eight", "ate"}, {"one", "won"},
+	{"see", "sea"}, {"weather", "whether"}, {"flower", "flour"}, {"wait", "weight"}, {"week", "weak"},
+	{"meet", "meat"}, {"hour", "our"}, {"its", "it's"}, {"your", "you're"}, {"whose", "who's"},
+	{"new", "knew"}, {"would", "wood"}, {"mail", "male"}, {"pair", "pear"}, {"sale", "sail"},
+	{"peace", "piece"}, {"break", "brake"}, {"cell", "sell"}, {"board", "bored"},
+}
+
+var homophones = map[string][]string{}
+
+func init() {
+	for _, group := range homophoneGroups {
+		for _, word := range group {
+			for _, other := range group {
+				if other != word {
+					homophones[word] = append(homophones[word], other)
+				}
+			}
+		}
+	}
+}
+
+// Filler words inserted into utterances
+var fillerWords = []string{"um", "uh", "like", "you know", "I mean", "so", "well"}
+
+// PerturbedUtterance represents a variant of a labeled utterance
+type PerturbedUtterance struct {
+	LabeledUtterance
+	Original     string `json:"original"`
+	Perturbation string `json:"perturbation"`
+}
+
+// Perturber generates perturbed variants of utterances
+type Perturber struct {
+	// Rand is the source of randomness, seed it for reproducible runs
+	Rand *rand.Rand
+	// Variants is the number of variants to try per utterance and perturbation
+	Variants int
+}
+
+// NewPerturber creates a perturber with a seeded source of randomness
+//
+//		perturber := wit.NewPerturber(42)
+func NewPerturber(seed int64) *Perturber {
+	return &Perturber{Rand: rand.New(rand.NewSource(seed)), Variants: 1}
+}
+
+// Perturb applies a perturbation to a text, reporting false when the
+// perturbation does not apply, e.g. there is no homophone to substitute
+//
+//		variant, ok := perturber.Perturb("book a flight to Paris", wit.PerturbHomophone)
+func (perturber *Perturber) Perturb(text string, perturbation string) (string, bool) {
+	var variant string
+	switch perturbation {
+	case PerturbTypo:
+		variant = perturber.typo(text)
+	case PerturbPunctuation:
+		variant = strings.Join(strings.Fields(strings.Map(func(r rune) rune {
+			if unicode.IsPunct(r) {
+				return -1
+			}
+			return r
+		}, text)), " ")
+	case PerturbCasing:
+		variant = perturber.casing(text)
+	case PerturbHomophone:
+		variant = perturber.homophone(text)
+	case PerturbFiller:
+		words := strings.Fields(text)
+		i := perturber.random().Intn(len(words) + 1)
+		filler := fillerWords[perturber.random().Intn(len(fillerWords))]
+		variant = strings.Join(append(words[:i:i], append([]string{filler}, words[i:]...)...), " ")
+	}
+	return variant, variant != "" && variant != text
+}
+
+// Generate creates the distinct variants of each utterance for each
+// perturbation, all perturbations when none are given
+//
+//		variants := perturber.Generate(utterances, wit.PerturbTypo, wit.PerturbFiller)
+func (perturber *Perturber) Generate(utterances []LabeledUtterance, perturbations ...string) []PerturbedUtterance {
+	if len(perturbations) == 0 {
+		perturbations = Perturbations
+	}
+	attempts := perturber.Variants
+	if attempts <= 0 {
+		attempts = 1
+	}
+	var variants []PerturbedUtterance
+	for _, utterance := range utterances {
+		for _, perturbation := range perturbations {
+			seen := map[string]bool{}
+			for n := 0; n < attempts; n++ {
+				text, ok := perturber.Perturb(utterance.Text, perturbation)
+				if !ok || seen[text] {
+					continue
+				}
+				seen[text] = true
+				variants = append(variants, PerturbedUtterance{
+					LabeledUtterance: LabeledUtterance{Text: text, Intent: utterance.Intent},
+					Original:         utterance.Text,
+					Perturbation:     perturbation,
+				})
+			}
+		}
+	}
+	return variants
+}
+
+func (perturber *Perturber) random() *rand.Rand {
+	if perturber.Rand == nil {
+		perturber.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return perturber.Rand
+}
+
+// Replaces a letter of one of the words with a neighboring key
+func (perturber *Perturber) typo(text string) string {
+	var candidates []int
+	for i, r := range text {
+		if _, ok := keyboardNeighbors[unicode.ToLower(r)]; ok {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	i := candidates[perturber.random().Intn(len(candidates))]
+	r, size := utf8.DecodeRuneInString(text[i:])
+	neighbors := keyboardNeighbors[unicode.ToLower(r)]
+	replacement := rune(neighbors[perturber.random().Intn(len(neighbors))])
+	if unicode.IsUpper(r) {
+		replacement = unicode.ToUpper(replacement)
+	}
+	return text[:i] + string(replacement) + text[i+size:]
+}
+
+// Changes the casing of the whole text to lower, upper or title case
+func (perturber *Perturber) casing(text string) string {
+	variants := []string{strings.ToLower(text), strings.ToUpper(text), titleCase(text)}
+	start := perturber.random().Intn(len(variants))
+	for n := range variants {
+		if variant := variants[(start+n)%len(variants)]; variant != text {
+			return variant
+		}
+	}
+	return ""
+}
+
+// Substitutes one word with a homophone
+func (perturber *Perturber) homophone(text string) string {
+	words := strings.Fields(text)
+	var candidates []int
+	for i, word := range words {
+		if _, ok := homophones[strings.ToLower(strings.TrimFunc(word, unicode.IsPunct))]; ok {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	i := candidates[perturber.random().Intn(len(candidates))]
+	word := strings.TrimFunc(words[i], unicode.IsPunct)
+	others := homophones[strings.ToLower(word)]
+	other := others[perturber.random().Intn(len(others))]
+	if r, _ := utf8.DecodeRuneInString(word); unicode.IsUpper(r) {
+		other = titleCase(other)
+	}
+	words[i] = strings.Replace(words[i], word, other, 1)
+	return strings.Join(words, " ")
+}
+
+// Upper-cases the first letter of each word
+func titleCase(text string) string {
+	words := strings.Fields(text)
+	for i, word := range words {
+		r, size := utf8.DecodeRuneInString(word)
+		words[i] = string(unicode.ToUpper(r)) + strings.ToLower(word[size:])
+	}
+	return strings.Join(words, " ")
+}
+
+// RobustnessStats represents how well intents hold up under a perturbation.
+// Drop is the clean accuracy of the original utterances minus the accuracy
+// of their variants, and flips are variants predicted differently from
+// their original. Variants that failed to classify, or whose original did,
+// are left out.
+type RobustnessStats struct {
+	Variants int     `json:"variants"`
+	Correct  int     `json:"correct"`
+	Accuracy float64 `json:"accuracy"`
+	Drop     float64 `json:"drop"`
+	Flipped  int     `json:"flipped"`
+	FlipRate float64 `json:"flip_rate"`
+
+	cleanCorrect int
+}
+
+// PerturbedResult represents the classification of a perturbed utterance
+type PerturbedResult struct {
+	IntentResult
+	Original     string `json:"original"`
+	Perturbation string `json:"perturbation"`
+	// Flipped reports whether the prediction differs from the original's
+	Flipped bool `json:"flipped"`
+}
+
+// RobustnessReport represents a robustness run, broken down per
+// perturbation and per intent and perturbation
+type RobustnessReport struct {
+	Clean           *IntentReport                          `json:"clean"`
+	Results         []PerturbedResult                      `json:"results"`
+	PerPerturbation map[string]*RobustnessStats            `json:"per_perturbation"`
+	PerIntent       map[string]map[string]*RobustnessStats `json:"per_intent"`
+}
+
+// RobustnessSuite evaluates labeled utterances and their perturbed variants
+type RobustnessSuite struct {
+	Evaluator     *IntentEvaluator
+	Perturber     *Perturber
+	Perturbations []string
+}
+
+// Run evaluates the clean utterances, then their variants, and compares them
+//
+//		suite := &wit.RobustnessSuite{Evaluator: evaluator, Perturber: wit.NewPerturber(42)}
+//		report := suite.Run(utterances)
+//		log.Printf("typos cost %.1f%% accuracy", 100*report.PerPerturbation[wit.PerturbTypo].Drop)
+func (suite *RobustnessSuite) Run(utterances []LabeledUtterance) *RobustnessReport {
+	clean := suite.Evaluator.Evaluate(utterances)
+	cleanResults := map[string]IntentResult{}
+	for _, result := range clean.Results {
+		cleanResults[result.Utterance.Text] = result
+	}
+
+	variants := suite.Perturber.Generate(utterances, suite.Perturbations...)
+	labeled := make([]LabeledUtterance, len(variants))
+	for i, variant := range variants {
+		labeled[i] = variant.LabeledUtterance
+	}
+	results := suite.Evaluator.classifyAll(labeled)
+
+	report := &RobustnessReport{
+		Clean:           clean,
+		PerPerturbation: map[string]*RobustnessStats{},
+		PerIntent:       map[string]map[string]*RobustnessStats{},
+	}
+	for i, result := range results {
+		original := cleanResults[variants[i].Original]
+		perturbed := PerturbedResult{
+			IntentResult: result,
+			Original:     variants[i].Original,
+			Perturbation: variants[i].Perturbation,
+			Flipped:      result.Err == nil && original.Err == nil && result.Predicted != original.Predicted,
+		}
+		report.Results = append(report.Results, perturbed)
+		if result.Err != nil || original.Err != nil {
+			continue
+		}
+
+		intent := result.Utterance.Intent
+		if report.PerIntent[intent] == nil {
+			report.PerIntent[intent] = map[string]*RobustnessStats{}
+		}
+		for _, stats := range []map[string]*RobustnessStats{report.PerPerturbation, report.PerIntent[intent]} {
+			if stats[perturbed.Perturbation] == nil {
+				stats[perturbed.Perturbation] = &RobustnessStats{}
+			}
+			stats[perturbed.Perturbation].add(perturbed, original.Correct)
+		}
+	}
+	return report
+}
+
+// Counts a perturbed result and updates the rates
+func (stats *RobustnessStats) add(result PerturbedResult, cleanCorrect bool) {
+	stats.Variants++
+	if result.Correct {
+		stats.Correct++
+	}
+	if cleanCorrect {
+		stats.cleanCorrect++
+	}
+	if result.Flipped {
+		stats.Flipped++
+	}
+	total := float64(stats.Variants)
+	stats.Accuracy = float64(stats.Correct) / total
+	stats.Drop = float64(stats.cleanCorrect-stats.Correct) / total
+	stats.FlipRate = float64(stats.Flipped) / total
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2014 Jason Goecke
+// robustness_test.go
+
+package wit
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPerturb(t *testing.T) {
+	perturber := NewPerturber(1)
+	text := "Hello, what's the weather for tomorrow?"
+
+	variant, ok := perturber.Perturb(text, PerturbTypo)
+	if !ok || len(variant) != len(text) || CharErrorRate(text, variant).Substitutions != 1 {
+		t.Errorf("expected a single typo, got %q", variant)
+	}
+	if variant, _ := perturber.Perturb(text, PerturbPunctuation); variant != "Hello whats the weather for tomorrow" {
+		t.Errorf("punctuation not dropped %q", variant)
+	}
+	if variant, ok := perturber.Perturb(text, PerturbCasing); !ok || !strings.EqualFold(variant, text) {
+		t.Errorf("casing not changed %q", variant)
+	}
+	if variant, ok := perturber.Perturb(text, PerturbHomophone); !ok || !strings.Contains(variant, "four") {
+		t.Errorf("homophone not substituted %q", variant)
+	}
+	if variant, ok := perturber.Perturb(text, PerturbFiller); !ok || len(strings.Fields(variant)) <= len(strings.Fields(text)) {
+		t.Errorf("filler not inserted %q", variant)
+	}
+	if _, ok := perturber.Perturb("Hello", PerturbHomophone); ok {
+		t.Error("homophone should not apply without a homophone")
+	}
+}
+
+func TestPerturberGenerate(t *testing.T) {
+	perturber := NewPerturber(1)
+	perturber.Variants = 5
+	utterances := []LabeledUtterance{{"hello there", "greeting"}}
+	variants := perturber.Generate(utterances, PerturbTypo, PerturbPunctuation)
+	typos := 0
+	for _, variant := range variants {
+		if variant.Intent != "greeting" || variant.Original != "hello there" {
+			t.Errorf("variant not labeled properly %+v", variant)
+		}
+		if variant.Perturbation == PerturbTypo {
+			typos++
+		}
+		if variant.Perturbation == PerturbPunctuation {
+			t.Error("punctuation should not apply without punctuation")
+		}
+	}
+	if typos < 2 || typos > 5 {
+		t.Errorf("expected several distinct typos, got %d", typos)
+	}
+}
+
+func TestIntentEvaluatorFailures(t *testing.T) {
+	classify := func(request *MessageRequest) (*Message, error) {
+		switch request.Query {
+		case "hello":
+			return &Message{Outcomes: []Outcome{{Intent: "greeting", Confidence: 0.9}}}, nil
+		case "goodbye":
+			return nil, errors.New("timeout")
+		}
+		return nil, nil
+	}
+	evaluator := &IntentEvaluator{Classify: classify, Concurrency: 2}
+	report := evaluator.Evaluate([]LabeledUtterance{
+		{"hello", "greeting"},
+		{"goodbye", "farewell"},
+		{"what is the weather", "weather"},
+	})
+	if report.Failures != 2 || report.Results[2].Err == nil {
+		t.Errorf("expected 2 failures, got %d %+v", report.Failures, report.Results)
+	}
+	if report.Overall.Total != 1 || report.Overall.Accuracy != 1 || len(report.PerIntent) != 1 {
+		t.Errorf("failures should be left out of the accuracy %+v %v", report.Overall, report.PerIntent)
+	}
+}
+
+func TestRobustnessSuite(t *testing.T) {
+	// A case sensitive keyword classifier, which casing and typos break
+	classify := func(request *MessageRequest) (*Message, error) {
+		intent := "unknown"
+		switch {
+		case strings.Contains(request.Query, "weather"):
+			intent = "weather"
+		case strings.Contains(request.Query, "hello"):
+			intent = "greeting"
+		}
+		return &Message{Outcomes: []Outcome{{Intent: intent, Confidence: 0.9}}}, nil
+	}
+	suite := &RobustnessSuite{
+		Evaluator:     &IntentEvaluator{Classify: classify, Concurrency: 2},
+		Perturber:     NewPerturber(7),
+		Perturbations: []string{PerturbCasing, PerturbFiller},
+	}
+	report := suite.Run([]LabeledUtterance{
+		{"what is the weather", "weather"},
+		{"hello there", "greeting"},
+		{"goodbye", "farewell"},
+	})
+
+	if report.Clean.Overall.Accuracy != 2.0/3.0 || report.Clean.PerIntent["farewell"].Correct != 0 {
+		t.Errorf("unexpected clean accuracy %+v", report.Clean.Overall)
+	}
+	filler := report.PerPerturbation[PerturbFiller]
+	if filler == nil || filler.Variants != 3 || filler.Drop != 0 || filler.Flipped != 0 {
+		t.Errorf("fillers should not change keyword matches %+v", filler)
+	}
+	casing := report.PerPerturbation[PerturbCasing]
+	if casing == nil || casing.Variants != 3 {
+		t.Fatalf("expected 3 casing variants %+v", casing)
+	}
+	weather := report.PerIntent["weather"][PerturbCasing]
+	if weather.Correct != 0 || weather.Drop != 1 || weather.FlipRate != 1 {
+		t.Errorf("casing should break the weather intent %+v", weather)
+	}
+}
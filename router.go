@@ -0,0 +1,103 @@
+// Copyright (c) 2014 Jason Goecke
+// router.go
+
+package wit
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrUnhandledIntent is returned when no handler handles a message's intent
+var ErrUnhandledIntent = errors.New("no handler for the intent")
+
+// Reply represents the answer to a message. Session holds the values
+// stored into the session, Actions what the bot should do besides replying.
+type Reply struct {
+	Text    string                 `json:"text,omitempty"`
+	Session map[string]interface{} `json:"session,omitempty"`
+	Actions []Action               `json:"actions,omitempty"`
+}
+
+// Action represents something a handler asks the bot to do, e.g. hand the
+// conversation over to a human
+type Action struct {
+	Type string                 `json:"type"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// Handler handles messages, typically those of an intent. The session may
+// be nil.
+type Handler interface {
+	Handle(ctx context.Context, message *Message, session *Session) (*Reply, error)
+}
+
+// HandlerFunc adapts a function to a Handler
+type HandlerFunc func(ctx context.Context, message *Message, session *Session) (*Reply, error)
+
+// Handle calls the function
+func (fn HandlerFunc) Handle(ctx context.Context, message *Message, session *Session) (*Reply, error) {
+	return fn(ctx, message, session)
+}
+
+// Router routes messages to the handler registered for the intent of their
+// best outcome, or to the Fallback handler
+type Router struct {
+	Fallback Handler
+
+	mutex    sync.RWMutex
+	handlers map[string]Handler
+}
+
+// NewRouter creates an empty router
+//
+//		router := wit.NewRouter()
+//		router.Register("order_pizza", wit.HandlerFunc(orderPizza))
+//		router.Fallback = dispatcher
+func NewRouter() *Router {
+	return &Router{handlers: map[string]Handler{}}
+}
+
+// Register sets the handler of an intent
+//
+//		router.Register("check_balance", plugin)
+func (router *Router) Register(intent string, handler Handler) {
+	router.mutex.Lock()
+	defer router.mutex.Unlock()
+	if router.handlers == nil {
+		router.handlers = map[string]Handler{}
+	}
+	router.handlers[intent] = handler
+}
+
+// Handler returns the handler of an intent, the fallback when there is none
+//
+//		handler := router.Handler("order_pizza")
+func (router *Router) Handler(intent string) Handler {
+	router.mutex.RLock()
+	defer router.mutex.RUnlock()
+	if handler, ok := router.handlers[intent]; ok {
+		return handler
+	}
+	return router.Fallback
+}
+
+// Handle passes the message to the handler of its intent. A nil message is
+// passed on as an empty message, without intent.
+//
+//		reply, err := router.Handle(ctx, message, session)
+func (router *Router) Handle(ctx context.Context, message *Message, session *Session) (*Reply, error) {
+	if message == nil {
+		message = &Message{}
+	}
+	intent := ""
+	if len(message.Outcomes) > 0 {
+		intent = message.Outcomes[0].Intent
+	}
+	handler := router.Handler(intent)
+	if handler == nil {
+		return nil, ErrUnhandledIntent
+	}
+	return handler.Handle(ctx, message, session)
+}
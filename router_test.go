@@ -0,0 +1,51 @@
+// Copyright (c) 2014 Jason Goecke
+// router_test.go
+
+package wit
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	router := NewRouter()
+	router.Register("greeting", HandlerFunc(func(ctx context.Context, message *Message, session *Session) (*Reply, error) {
+		return &Reply{Text: "hello " + session.ID}, nil
+	}))
+	message := &Message{Outcomes: []Outcome{{Intent: "greeting"}}}
+	reply, err := router.Handle(context.Background(), message, NewSession("bob"))
+	if err != nil || reply.Text != "hello bob" {
+		t.Errorf("unexpected reply %+v %v", reply, err)
+	}
+
+	message.Outcomes[0].Intent = "weather"
+	if _, err := router.Handle(context.Background(), message, nil); err != ErrUnhandledIntent {
+		t.Errorf("expected ErrUnhandledIntent, got %v", err)
+	}
+	router.Fallback = HandlerFunc(func(ctx context.Context, message *Message, session *Session) (*Reply, error) {
+		if message == nil {
+			t.Error("expected an empty message instead of nil")
+		}
+		return &Reply{Text: "sorry"}, nil
+	})
+	if reply, err = router.Handle(context.Background(), &Message{}, nil); err != nil || reply.Text != "sorry" {
+		t.Errorf("expected the fallback reply, got %+v %v", reply, err)
+	}
+	if reply, err = router.Handle(context.Background(), nil, nil); err != nil || reply.Text != "sorry" {
+		t.Errorf("expected a nil message to reach the fallback as an empty message, got %+v %v", reply, err)
+	}
+}
+
+func TestRouterNilMessageHandlers(t *testing.T) {
+	// Handlers of the package read the message, they get an empty one
+	dispatcher, err := NewDispatcher(&DispatchConfig{FallbackReply: "sorry"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := NewRouter()
+	router.Fallback = dispatcher
+	if reply, err := router.Handle(context.Background(), nil, nil); err != nil || reply.Text != "sorry" {
+		t.Errorf("expected the dispatcher's fallback reply, got %+v %v", reply, err)
+	}
+}
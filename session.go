@@ -0,0 +1,204 @@
+// Copyright (c) 2014 Jason Goecke
+// session.go
+
+package wit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Session represents the state kept for a conversation between messages
+type Session struct {
+	ID        string                 `json:"id"`
+	Data      map[string]interface{} `json:"data"`
+	UpdatedAt time.Time              `json:"updated_at"`
+}
+
+// SessionStore persists sessions. Load returns a new, empty session when
+// there is none for the id.
+type SessionStore interface {
+	Load(id string) (*Session, error)
+	Save(session *Session) error
+	Delete(id string) error
+}
+
+// NewSession creates an empty session
+//
+//		session := wit.NewSession("user-42")
+func NewSession(id string) *Session {
+	return &Session{ID: id, Data: map[string]interface{}{}}
+}
+
+// Get returns a value of the session's data
+//
+//		customer, ok := session.Get("customer_id")
+func (session *Session) Get(key string) (interface{}, bool) {
+	value, ok := session.Data[key]
+	return value, ok
+}
+
+// Set sets a value of the session's data
+//
+//		session.Set("customer_id", "c-1234")
+func (session *Session) Set(key string, value interface{}) {
+	if session.Data == nil {
+		session.Data = map[string]interface{}{}
+	}
+	session.Data[key] = value
+}
+
+// MemorySessionStore keeps sessions in memory, expiring them after TTL
+// when set
+type MemorySessionStore struct {
+	TTL time.Duration
+
+	mutex    sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewMemorySessionStore creates an in-memory session store
+//
+//		store := wit.NewMemorySessionStore(30 * time.Minute)
+func NewMemorySessionStore(ttl time.Duration) *MemorySessionStore {
+	return &MemorySessionStore{TTL: ttl, sessions: map[string]*Session{}}
+}
+
+// Load returns a copy of the session, or a new session when there is none
+// or it expired
+//
+//		session, err := store.Load("user-42")
+func (store *MemorySessionStore) Load(id string) (*Session, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	session, ok := store.sessions[id]
+	if !ok || (store.TTL > 0 && time.Since(session.UpdatedAt) > store.TTL) {
+		delete(store.sessions, id)
+		return NewSession(id), nil
+	}
+	return copySession(session), nil
+}
+
+// Save stores a copy of the session
+//
+//		err := store.Save(session)
+func (store *MemorySessionStore) Save(session *Session) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.sessions == nil {
+		store.sessions = map[string]*Session{}
+	}
+	session.UpdatedAt = time.Now()
+	store.sessions[session.ID] = copySession(session)
+	return nil
+}
+
+// Delete removes the session
+//
+//		err := store.Delete("user-42")
+func (store *MemorySessionStore) Delete(id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.sessions, id)
+	return nil
+}
+
+// Copies the session and the top level of its data
+func copySession(session *Session) *Session {
+	copied := &Session{ID: session.ID, Data: make(map[string]interface{}, len(session.Data)), UpdatedAt: session.UpdatedAt}
+	for key, value := range session.Data {
+		copied.Data[key] = value
+	}
+	return copied
+}
+
+// FileSessionStore keeps each session in a file of Dir, encrypted with
+// Keyring when set. File names are hashes of the session ids, so ids
+// such as phone numbers do not appear on disk either.
+type FileSessionStore struct {
+	Dir     string
+	Keyring *Keyring
+	TTL     time.Duration
+	// AllowPlaintext reads plaintext sessions while a store is moved to
+	// encryption with RewrapFiles, they are refused when Keyring is set
+	// otherwise
+	AllowPlaintext bool
+}
+
+// NewFileSessionStore creates a file session store
+//
+//		keyring, err := wit.KeyringFromEnv()
+//		store := wit.NewFileSessionStore("./sessions", keyring)
+func NewFileSessionStore(dir string, keyring *Keyring) *FileSessionStore {
+	return &FileSessionStore{Dir: dir, Keyring: keyring}
+}
+
+// Load reads the session, or returns a new session when there is none or
+// it expired. A file holding another session, such as one copied over the
+// session's file, is refused.
+//
+//		session, err := store.Load("user-42")
+func (store *FileSessionStore) Load(id string) (*Session, error) {
+	data, err := store.read(store.path(id))
+	if os.IsNotExist(err) {
+		return NewSession(id), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{}
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, err
+	}
+	// A file copied over another session's would otherwise be loaded, and
+	// saved back, as that session
+	if session.ID != id {
+		return nil, fmt.Errorf("%s holds another session", store.path(id))
+	}
+	if session.Data == nil {
+		session.Data = map[string]interface{}{}
+	}
+	if store.TTL > 0 && time.Since(session.UpdatedAt) > store.TTL {
+		return NewSession(id), nil
+	}
+	return session, nil
+}
+
+// Save writes the session
+//
+//		err := store.Save(session)
+func (store *FileSessionStore) Save(session *Session) error {
+	session.UpdatedAt = time.Now()
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return WriteEncryptedFile(store.path(session.ID), data, store.Keyring, 0600)
+}
+
+// Delete removes the session's file
+//
+//		err := store.Delete("user-42")
+func (store *FileSessionStore) Delete(id string) error {
+	err := os.Remove(store.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (store *FileSessionStore) path(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(store.Dir, hex.EncodeToString(sum[:])+".session")
+}
+
+// Reads a session file, plaintext ones only when AllowPlaintext is set
+func (store *FileSessionStore) read(path string) ([]byte, error) {
+	return readEncryptedFile(path, store.Keyring, store.AllowPlaintext)
+}
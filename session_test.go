@@ -0,0 +1,38 @@
+// Copyright (c) 2014 Jason Goecke
+// session_test.go
+
+package wit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemorySessionStore(t *testing.T) {
+	store := NewMemorySessionStore(0)
+	session, err := store.Load("user-42")
+	if err != nil || session.ID != "user-42" || len(session.Data) != 0 {
+		t.Fatalf("expected a new session, got %+v %v", session, err)
+	}
+	session.Set("customer_id", "c-1234")
+	if err := store.Save(session); err != nil {
+		t.Fatal(err)
+	}
+	session.Set("customer_id", "changed")
+
+	loaded, _ := store.Load("user-42")
+	if value, ok := loaded.Get("customer_id"); !ok || value != "c-1234" {
+		t.Errorf("session not saved as a copy, got %v", value)
+	}
+	store.Delete("user-42")
+	if loaded, _ = store.Load("user-42"); len(loaded.Data) != 0 {
+		t.Error("session not deleted")
+	}
+
+	store = NewMemorySessionStore(time.Millisecond)
+	store.Save(session)
+	time.Sleep(5 * time.Millisecond)
+	if loaded, _ = store.Load("user-42"); len(loaded.Data) != 0 {
+		t.Error("session not expired")
+	}
+}
@@ -0,0 +1,442 @@
+// Copyright (c) 2014 Jason Goecke
+// speecheval.go
+
+package wit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Alignment operations between a reference and a hypothesis
+const (
+	AlignEqual      = "equal"
+	AlignSubstitute = "substitute"
+	AlignInsert     = "insert"
+	AlignDelete     = "delete"
+)
+
+// DefaultEvaluationConcurrency is the number of requests an evaluator makes
+// at once when no concurrency is set
+const DefaultEvaluationConcurrency = 4
+
+// SpeechSample represents an audio file with its reference transcript. The
+// sample rate and duration are read from the file when it is a WAV file.
+type SpeechSample struct {
+	File        string            `json:"file"`
+	Reference   string            `json:"reference"`
+	ContentType string            `json:"content_type,omitempty"`
+	SampleRate  int               `json:"sample_rate,omitempty"`
+	Duration    float64           `json:"duration,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+}
+
+// AlignmentOp represents a single step of the alignment of two transcripts
+type AlignmentOp struct {
+	Op         string `json:"op"`
+	Reference  string `json:"reference,omitempty"`
+	Hypothesis string `json:"hypothesis,omitempty"`
+}
+
+// ErrorRate represents the edit counts between a reference and a hypothesis
+// and the resulting error rate
+type ErrorRate struct {
+	Hits          int     `json:"hits"`
+	Substitutions int     `json:"substitutions"`
+	Insertions    int     `json:"insertions"`
+	Deletions     int     `json:"deletions"`
+	Reference     int     `json:"reference"`
+	Rate          float64 `json:"rate"`
+}
+
+// SpeechResult represents the evaluation of a single sample
+type SpeechResult struct {
+	Sample     SpeechSample  `json:"sample"`
+	Hypothesis string        `json:"hypothesis"`
+	Words      ErrorRate     `json:"words"`
+	Characters ErrorRate     `json:"characters"`
+	Alignment  []AlignmentOp `json:"alignment"`
+	Err        error         `json:"-"`
+}
+
+// SpeechBreakdown represents the error rates of a group of samples
+type SpeechBreakdown struct {
+	Samples    int       `json:"samples"`
+	Words      ErrorRate `json:"words"`
+	Characters ErrorRate `json:"characters"`
+}
+
+// SpeechReport represents the evaluation of a set of samples. The breakdown
+// is keyed by attribute, "sample_rate", "duration" or any of the samples'
+// own attributes, and then by attribute value.
+type SpeechReport struct {
+	Results    []SpeechResult                         `json:"results"`
+	Words      ErrorRate                              `json:"words"`
+	Characters ErrorRate                              `json:"characters"`
+	Failures   int                                    `json:"failures"`
+	Breakdown  map[string]map[string]*SpeechBreakdown `json:"breakdown"`
+}
+
+// SpeechEvaluator runs samples through the speech API and scores the
+// transcripts against the references
+type SpeechEvaluator struct {
+	Client      *Client
+	Concurrency int
+	// Transcribe replaces Client.AudioMessage when set
+	Transcribe func(request *MessageRequest) (*Message, error)
+}
+
+// LoadSpeechManifest reads a manifest of samples, one JSON object per line.
+// Relative file paths are resolved against the manifest's directory.
+//
+//		samples, err := wit.LoadSpeechManifest("./audio/manifest.jsonl")
+func LoadSpeechManifest(path string) ([]SpeechSample, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var samples []SpeechSample
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		sample := SpeechSample{}
+		if err := json.Unmarshal([]byte(text), &sample); err != nil {
+			return nil, fmt.Errorf("manifest line %d: %s", line, err)
+		}
+		if !filepath.IsAbs(sample.File) {
+			sample.File = filepath.Join(filepath.Dir(path), sample.File)
+		}
+		samples = append(samples, sample)
+	}
+	return samples, scanner.Err()
+}
+
+// Evaluate transcribes the samples concurrently and scores them
+//
+//		evaluator := &wit.SpeechEvaluator{Client: client, Concurrency: 8}
+//		report := evaluator.Evaluate(samples)
+//		log.Printf("WER %.3f CER %.3f", report.Words.Rate, report.Characters.Rate)
+func (evaluator *SpeechEvaluator) Evaluate(samples []SpeechSample) *SpeechReport {
+	transcribe := evaluator.Transcribe
+	if transcribe == nil {
+		transcribe = evaluator.Client.AudioMessage
+	}
+	results := make([]SpeechResult, len(samples))
+	forEachConcurrently(len(samples), evaluator.Concurrency, func(i int) {
+		results[i] = evaluateSpeechSample(samples[i], transcribe)
+	})
+
+	report := &SpeechReport{Results: results, Breakdown: map[string]map[string]*SpeechBreakdown{}}
+	for _, result := range results {
+		if result.Err != nil {
+			report.Failures++
+			continue
+		}
+		report.Words = addErrorRates(report.Words, result.Words)
+		report.Characters = addErrorRates(report.Characters, result.Characters)
+		for attribute, value := range speechAttributes(result.Sample) {
+			buckets, ok := report.Breakdown[attribute]
+			if !ok {
+				buckets = map[string]*SpeechBreakdown{}
+				report.Breakdown[attribute] = buckets
+			}
+			bucket, ok := buckets[value]
+			if !ok {
+				bucket = &SpeechBreakdown{}
+				buckets[value] = bucket
+			}
+			bucket.Samples++
+			bucket.Words = addErrorRates(bucket.Words, result.Words)
+			bucket.Characters = addErrorRates(bucket.Characters, result.Characters)
+		}
+	}
+	return report
+}
+
+// Calls fn for each index below n, from at most concurrency goroutines
+func forEachConcurrently(n int, concurrency int, fn func(i int)) {
+	if concurrency <= 0 {
+		concurrency = DefaultEvaluationConcurrency
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// Transcribes and scores a single sample
+func evaluateSpeechSample(sample SpeechSample, transcribe func(*MessageRequest) (*Message, error)) SpeechResult {
+	result := SpeechResult{Sample: sample}
+	if sample.SampleRate == 0 || sample.Duration == 0 {
+		if info, err := readWAVInfo(sample.File); err == nil {
+			if sample.SampleRate == 0 {
+				result.Sample.SampleRate = info.sampleRate
+			}
+			if sample.Duration == 0 {
+				result.Sample.Duration = info.duration
+			}
+		}
+	}
+	contentType := sample.ContentType
+	if contentType == "" {
+		contentType = "audio/wav"
+	}
+	message, err := transcribe(&MessageRequest{File: sample.File, ContentType: contentType})
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if message == nil {
+		result.Err = errors.New("no message returned")
+		return result
+	}
+	result.Hypothesis = message.Text
+	reference, hypothesis := normalizeTranscript(sample.Reference), normalizeTranscript(message.Text)
+	result.Alignment, result.Words = Align(strings.Fields(reference), strings.Fields(hypothesis))
+	_, result.Characters = Align(splitRunes(reference), splitRunes(hypothesis))
+	return result
+}
+
+// Returns the attribute values a sample is broken down by
+func speechAttributes(sample SpeechSample) map[string]string {
+	attributes := map[string]string{}
+	for key, value := range sample.Attributes {
+		attributes[key] = value
+	}
+	if sample.SampleRate > 0 {
+		attributes["sample_rate"] = strconv.Itoa(sample.SampleRate)
+	}
+	if sample.Duration > 0 {
+		attributes["duration"] = durationBucket(sample.Duration)
+	}
+	return attributes
+}
+
+// Returns the duration bucket for a length of audio in seconds
+func durationBucket(seconds float64) string {
+	switch {
+	case seconds < 2:
+		return "<2s"
+	case seconds < 5:
+		return "2-5s"
+	case seconds < 10:
+		return "5-10s"
+	case seconds < 30:
+		return "10-30s"
+	}
+	return ">=30s"
+}
+
+// WordErrorRate returns the word error rate of a hypothesis against a
+// reference, ignoring case and punctuation
+//
+//		rate := wit.WordErrorRate("hello world", "hello word")
+func WordErrorRate(reference string, hypothesis string) ErrorRate {
+	_, rate := Align(strings.Fields(normalizeTranscript(reference)), strings.Fields(normalizeTranscript(hypothesis)))
+	return rate
+}
+
+// CharErrorRate returns the character error rate of a hypothesis against a
+// reference, ignoring case and punctuation
+//
+//		rate := wit.CharErrorRate("hello world", "hello word")
+func CharErrorRate(reference string, hypothesis string) ErrorRate {
+	_, rate := Align(splitRunes(normalizeTranscript(reference)), splitRunes(normalizeTranscript(hypothesis)))
+	return rate
+}
+
+// Align computes the minimum edit alignment of a hypothesis against a
+// reference and the resulting error rate
+//
+//		ops, rate := wit.Align(strings.Fields(reference), strings.Fields(hypothesis))
+func Align(reference []string, hypothesis []string) ([]AlignmentOp, ErrorRate) {
+	rows, cols := len(reference)+1, len(hypothesis)+1
+	costs := make([][]int, rows)
+	for i := range costs {
+		costs[i] = make([]int, cols)
+		costs[i][0] = i
+	}
+	for j := 0; j < cols; j++ {
+		costs[0][j] = j
+	}
+	for i := 1; i < rows; i++ {
+		for j := 1; j < cols; j++ {
+			substitution := costs[i-1][j-1]
+			if reference[i-1] != hypothesis[j-1] {
+				substitution++
+			}
+			costs[i][j] = minInt(substitution, minInt(costs[i-1][j]+1, costs[i][j-1]+1))
+		}
+	}
+
+	var ops []AlignmentOp
+	rate := ErrorRate{Reference: len(reference)}
+	for i, j := len(reference), len(hypothesis); i > 0 || j > 0; {
+		switch {
+		case i > 0 && j > 0 && reference[i-1] == hypothesis[j-1] && costs[i][j] == costs[i-1][j-1]:
+			ops = append(ops, AlignmentOp{AlignEqual, reference[i-1], hypothesis[j-1]})
+			rate.Hits++
+			i, j = i-1, j-1
+		case i > 0 && j > 0 && costs[i][j] == costs[i-1][j-1]+1:
+			ops = append(ops, AlignmentOp{AlignSubstitute, reference[i-1], hypothesis[j-1]})
+			rate.Substitutions++
+			i, j = i-1, j-1
+		case i > 0 && costs[i][j] == costs[i-1][j]+1:
+			ops = append(ops, AlignmentOp{Op: AlignDelete, Reference: reference[i-1]})
+			rate.Deletions++
+			i--
+		default:
+			ops = append(ops, AlignmentOp{Op: AlignInsert, Hypothesis: hypothesis[j-1]})
+			rate.Insertions++
+			j--
+		}
+	}
+	for l, r := 0, len(ops)-1; l < r; l, r = l+1, r-1 {
+		ops[l], ops[r] = ops[r], ops[l]
+	}
+	rate.Rate = errorRate(rate)
+	return ops, rate
+}
+
+// Sums two error rates, recomputing the rate from the summed counts
+func addErrorRates(a ErrorRate, b ErrorRate) ErrorRate {
+	sum := ErrorRate{
+		Hits:          a.Hits + b.Hits,
+		Substitutions: a.Substitutions + b.Substitutions,
+		Insertions:    a.Insertions + b.Insertions,
+		Deletions:     a.Deletions + b.Deletions,
+		Reference:     a.Reference + b.Reference,
+	}
+	sum.Rate = errorRate(sum)
+	return sum
+}
+
+// Computes (S + D + I) / N, an empty reference scores 1 when anything was
+// inserted
+func errorRate(rate ErrorRate) float64 {
+	edits := rate.Substitutions + rate.Deletions + rate.Insertions
+	if rate.Reference == 0 {
+		if edits > 0 {
+			return 1
+		}
+		return 0
+	}
+	return float64(edits) / float64(rate.Reference)
+}
+
+// Lower-cases a transcript, strips punctuation and collapses whitespace
+func normalizeTranscript(text string) string {
+	text = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\'':
+			return r
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			return ' '
+		}
+		return unicode.ToLower(r)
+	}, text)
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Splits a string into its characters
+func splitRunes(text string) []string {
+	var chars []string
+	for _, r := range text {
+		chars = append(chars, string(r))
+	}
+	return chars
+}
+
+// wavInfo represents the format of a WAV file
+type wavInfo struct {
+	sampleRate int
+	channels   int
+	bits       int
+	duration   float64
+}
+
+var errNotWAV = errors.New("not a WAV file")
+
+// Longest fmt chunk read, PCM's is 16 bytes and WAVE_FORMAT_EXTENSIBLE's 40
+const wavMaxFormatSize = 64
+
+// Reads the sample rate and duration from a WAV file's header
+func readWAVInfo(path string) (*wavInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:]) != "WAVE" {
+		return nil, errNotWAV
+	}
+	info := &wavInfo{}
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(file, chunk); err != nil {
+			return nil, errNotWAV
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		switch string(chunk[:4]) {
+		case "fmt ":
+			if size < 16 || size > wavMaxFormatSize {
+				return nil, errNotWAV
+			}
+			format := make([]byte, 16)
+			if _, err := io.ReadFull(file, format); err != nil {
+				return nil, err
+			}
+			if _, err := file.Seek(size-16+size%2, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+			info.channels = int(binary.LittleEndian.Uint16(format[2:]))
+			info.sampleRate = int(binary.LittleEndian.Uint32(format[4:]))
+			info.bits = int(binary.LittleEndian.Uint16(format[14:]))
+		case "data":
+			bytesPerSecond := info.sampleRate * info.channels * info.bits / 8
+			if bytesPerSecond == 0 {
+				return nil, errNotWAV
+			}
+			info.duration = float64(size) / float64(bytesPerSecond)
+			return info, nil
+		default:
+			if _, err := file.Seek(size+size%2, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
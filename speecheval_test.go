@@ -0,0 +1,178 @@
+// Copyright (c) 2014 Jason Goecke
+// speecheval_test.go
+
+package wit
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAlign(t *testing.T) {
+	ops, rate := Align(strings.Fields("the cat sat on the mat"), strings.Fields("the bat sat on mat"))
+	if rate.Substitutions != 1 || rate.Deletions != 1 || rate.Insertions != 0 || rate.Hits != 4 {
+		t.Errorf("unexpected counts %+v", rate)
+	}
+	if rate.Rate != 2.0/6.0 {
+		t.Errorf("not equal %v != %v", 2.0/6.0, rate.Rate)
+	}
+	expected := []AlignmentOp{
+		{AlignEqual, "the", "the"},
+		{AlignSubstitute, "cat", "bat"},
+		{AlignEqual, "sat", "sat"},
+		{AlignEqual, "on", "on"},
+		{Op: AlignDelete, Reference: "the"},
+		{AlignEqual, "mat", "mat"},
+	}
+	if len(ops) != len(expected) {
+		t.Fatalf("unexpected alignment %+v", ops)
+	}
+	for i := range ops {
+		if ops[i] != expected[i] {
+			t.Errorf("op %d: not equal %+v != %+v", i, expected[i], ops[i])
+		}
+	}
+
+	ops, rate = Align(strings.Fields("hello world"), strings.Fields("hello big world"))
+	if rate.Insertions != 1 || rate.Rate != 0.5 || ops[1] != (AlignmentOp{Op: AlignInsert, Hypothesis: "big"}) {
+		t.Errorf("unexpected insertion alignment %+v %+v", ops, rate)
+	}
+}
+
+func TestErrorRates(t *testing.T) {
+	if rate := WordErrorRate("Hello, world!", "hello world"); rate.Rate != 0 {
+		t.Errorf("punctuation and case should be ignored, got %+v", rate)
+	}
+	if rate := CharErrorRate("hello world", "hello word"); rate.Deletions != 1 || rate.Reference != 11 {
+		t.Errorf("unexpected character error rate %+v", rate)
+	}
+	if rate := WordErrorRate("", "noise"); rate.Rate != 1 {
+		t.Errorf("insertions against an empty reference should score 1, got %+v", rate)
+	}
+}
+
+func TestReadWAVInfo(t *testing.T) {
+	info, err := readWAVInfo("./audio_sample/helloWorld.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.sampleRate != 8000 || info.channels != 1 || info.bits != 16 {
+		t.Errorf("unexpected format %+v", info)
+	}
+	if info.duration < 1.8 || info.duration > 1.9 {
+		t.Errorf("unexpected duration %v", info.duration)
+	}
+
+	// A fmt chunk claiming 4GB, then a LIST chunk and an 18 byte fmt chunk
+	dir, err := ioutil.TempDir("", "speecheval")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pcm := []byte{1, 0, 1, 0, 0x40, 0x1f, 0, 0, 0x80, 0x3e, 0, 0, 2, 0, 16, 0}
+	tests := []struct {
+		chunks []byte
+		valid  bool
+	}{
+		{append([]byte("fmt \xff\xff\xff\xff"), pcm...), false},
+		{append(append([]byte("LIST\x03\x00\x00\x00abc\x00fmt \x12\x00\x00\x00"), pcm...), 0, 0, 'd', 'a', 't', 'a', 0x80, 0x3e, 0, 0), true},
+	}
+	for i, test := range tests {
+		path := filepath.Join(dir, "sample.wav")
+		data := append([]byte("RIFF\x00\x00\x00\x00WAVE"), test.chunks...)
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		info, err := readWAVInfo(path)
+		if !test.valid {
+			if err != errNotWAV {
+				t.Errorf("%d: expected errNotWAV, got %+v %v", i, info, err)
+			}
+			continue
+		}
+		if err != nil || info.sampleRate != 8000 || info.duration != 1 {
+			t.Errorf("%d: unexpected info %+v %v", i, info, err)
+		}
+	}
+}
+
+func TestSpeechEvaluator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "speecheval")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	manifest := `{"file": "` + filepath.Join(mustAbs(t, "./audio_sample"), "helloWorld.wav") + `", "reference": "Hello world", "attributes": {"speaker": "a"}}
+{"file": "missing.wav", "reference": "good bye", "sample_rate": 16000, "duration": 3.5, "attributes": {"speaker": "b"}}
+{"file": "broken.wav", "reference": "never transcribed"}
+`
+	path := filepath.Join(dir, "manifest.jsonl")
+	if err := ioutil.WriteFile(path, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	samples, err := LoadSpeechManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if samples[1].File != filepath.Join(dir, "missing.wav") {
+		t.Errorf("relative path not resolved %s", samples[1].File)
+	}
+
+	transcripts := map[string]string{"helloWorld.wav": "hello world", "missing.wav": "good by"}
+	evaluator := &SpeechEvaluator{
+		Concurrency: 2,
+		Transcribe: func(request *MessageRequest) (*Message, error) {
+			text, ok := transcripts[filepath.Base(request.File)]
+			if !ok {
+				return nil, errors.New("Bad Request")
+			}
+			return &Message{Text: text}, nil
+		},
+	}
+	report := evaluator.Evaluate(samples)
+	if report.Failures != 1 || report.Results[2].Err == nil {
+		t.Errorf("expected the broken sample to fail")
+	}
+	if report.Words.Reference != 4 || report.Words.Substitutions != 1 || report.Words.Rate != 0.25 {
+		t.Errorf("unexpected word error rate %+v", report.Words)
+	}
+	if report.Results[0].Sample.SampleRate != 8000 {
+		t.Errorf("sample rate not read from the WAV header")
+	}
+	if bucket := report.Breakdown["sample_rate"]["8000"]; bucket == nil || bucket.Words.Rate != 0 {
+		t.Errorf("unexpected 8000Hz breakdown %+v", bucket)
+	}
+	if bucket := report.Breakdown["sample_rate"]["16000"]; bucket == nil || bucket.Words.Rate != 0.5 {
+		t.Errorf("unexpected 16000Hz breakdown %+v", bucket)
+	}
+	if bucket := report.Breakdown["duration"]["2-5s"]; bucket == nil || bucket.Samples != 1 {
+		t.Errorf("unexpected duration breakdown %+v", report.Breakdown["duration"])
+	}
+	if bucket := report.Breakdown["speaker"]["a"]; bucket == nil || bucket.Samples != 1 {
+		t.Errorf("unexpected speaker breakdown %+v", report.Breakdown["speaker"])
+	}
+}
+
+func TestSpeechEvaluatorWithoutMessage(t *testing.T) {
+	evaluator := &SpeechEvaluator{
+		Transcribe: func(request *MessageRequest) (*Message, error) {
+			return nil, nil
+		},
+	}
+	report := evaluator.Evaluate([]SpeechSample{{File: "missing.wav", Reference: "hello", SampleRate: 8000, Duration: 1}})
+	if report.Failures != 1 || report.Results[0].Err == nil {
+		t.Errorf("expected a sample without message to fail %+v", report.Results)
+	}
+}
+
+func mustAbs(t *testing.T, path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return abs
+}
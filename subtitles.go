@@ -0,0 +1,183 @@
+// Copyright (c) 2014 Jason Goecke
+// subtitles.go
+
+package wit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxSubtitleLineLength is the length subtitle lines are wrapped at
+const MaxSubtitleLineLength = 42
+
+// Segment represents the result of processing a slice of a longer recording,
+// with its offsets from the start of the recording
+type Segment struct {
+	Start   time.Duration
+	End     time.Duration
+	Message *Message
+}
+
+// TranscriptSegment represents a segment within a JSON transcript
+type TranscriptSegment struct {
+	Index      int                        `json:"index"`
+	Start      float64                    `json:"start"`
+	End        float64                    `json:"end"`
+	Text       string                     `json:"text"`
+	MsgID      string                     `json:"msg_id,omitempty"`
+	Intent     string                     `json:"intent,omitempty"`
+	Confidence float32                    `json:"confidence,omitempty"`
+	Entities   map[string][]MessageEntity `json:"entities,omitempty"`
+}
+
+// Transcript represents a full transcript of a segmented recording
+type Transcript struct {
+	Source      string              `json:"source,omitempty"`
+	GeneratedAt string              `json:"generated_at"`
+	Duration    float64             `json:"duration"`
+	Text        string              `json:"text"`
+	Segments    []TranscriptSegment `json:"segments"`
+}
+
+// WriteWebVTT writes the segments as WebVTT subtitles (https://www.w3.org/TR/webvtt1/)
+//
+//		err := wit.WriteWebVTT(file, segments)
+func WriteWebVTT(w io.Writer, segments []Segment) error {
+	buf := bufio.NewWriter(w)
+	buf.WriteString("WEBVTT\n")
+	for n, segment := range subtitleSegments(segments) {
+		text := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(wrapSubtitle(segmentText(segment)))
+		fmt.Fprintf(buf, "\n%d\n%s --> %s\n%s\n", n+1,
+			subtitleTimestamp(segment.Start, "."), subtitleTimestamp(segment.End, "."), text)
+	}
+	return buf.Flush()
+}
+
+// WriteSRT writes the segments as SubRip subtitles
+//
+//		err := wit.WriteSRT(file, segments)
+func WriteSRT(w io.Writer, segments []Segment) error {
+	buf := bufio.NewWriter(w)
+	for n, segment := range subtitleSegments(segments) {
+		if n > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(buf, "%d\n%s --> %s\n%s\n", n+1,
+			subtitleTimestamp(segment.Start, ","), subtitleTimestamp(segment.End, ","), wrapSubtitle(segmentText(segment)))
+	}
+	return buf.Flush()
+}
+
+// NewTranscript builds a transcript of the segments, annotated with the
+// intent and entities of each segment's best outcome
+//
+//		transcript := wit.NewTranscript("call-1234.wav", segments)
+func NewTranscript(source string, segments []Segment) *Transcript {
+	transcript := &Transcript{
+		Source:      source,
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Segments:    []TranscriptSegment{},
+	}
+	var texts []string
+	for n, segment := range sortedSegments(segments) {
+		entry := TranscriptSegment{
+			Index: n,
+			Start: segment.Start.Seconds(),
+			End:   segment.End.Seconds(),
+			Text:  segmentText(segment),
+		}
+		if segment.Message != nil {
+			entry.MsgID = segment.Message.MsgID
+			if len(segment.Message.Outcomes) > 0 {
+				outcome := segment.Message.Outcomes[0]
+				entry.Intent = outcome.Intent
+				entry.Confidence = outcome.Confidence
+				entry.Entities = outcome.Entities
+			}
+		}
+		if entry.Text != "" {
+			texts = append(texts, entry.Text)
+		}
+		if entry.End > transcript.Duration {
+			transcript.Duration = entry.End
+		}
+		transcript.Segments = append(transcript.Segments, entry)
+	}
+	transcript.Text = strings.Join(texts, " ")
+	return transcript
+}
+
+// WriteTranscript writes the segments as an annotated JSON transcript
+//
+//		err := wit.WriteTranscript(file, "call-1234.wav", segments)
+func WriteTranscript(w io.Writer, source string, segments []Segment) error {
+	data, err := json.MarshalIndent(NewTranscript(source, segments), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Returns the segments sorted by start time
+func sortedSegments(segments []Segment) []Segment {
+	sorted := append([]Segment(nil), segments...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+	return sorted
+}
+
+// Returns the segments that have text, sorted by start time
+func subtitleSegments(segments []Segment) []Segment {
+	var cues []Segment
+	for _, segment := range sortedSegments(segments) {
+		if segmentText(segment) != "" {
+			cues = append(cues, segment)
+		}
+	}
+	return cues
+}
+
+// Returns the transcribed text of a segment
+func segmentText(segment Segment) string {
+	if segment.Message == nil {
+		return ""
+	}
+	text := segment.Message.Text
+	if text == "" && len(segment.Message.Outcomes) > 0 {
+		text = segment.Message.Outcomes[0].Text
+	}
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Formats an offset as HH:MM:SS followed by the separator and milliseconds
+func subtitleTimestamp(offset time.Duration, separator string) string {
+	if offset < 0 {
+		offset = 0
+	}
+	ms := offset.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, separator, ms%1000)
+}
+
+// Wraps subtitle text into lines no longer than MaxSubtitleLineLength,
+// unless a single word is longer
+func wrapSubtitle(text string) string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && len([]rune(line))+1+len([]rune(word)) > MaxSubtitleLineLength {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	return strings.Join(append(lines, line), "\n")
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2014 Jason Goecke
+// subtitles_test.go
+
+package wit
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+var subtitleSegmentsFixture = []Segment{
+	{
+		Start: 4 * time.Second,
+		End:   3723*time.Second + 45*time.Millisecond,
+		Message: &Message{
+			MsgID: "b",
+			Text:  "I would like to transfer <one hundred> dollars from checking to savings & close it",
+			Outcomes: []Outcome{{
+				Intent:     "transfer",
+				Confidence: 0.8,
+				Entities:   map[string][]MessageEntity{"amount_of_money": {{Body: stringPtr("one hundred dollars")}}},
+			}},
+		},
+	},
+	{Start: 2500 * time.Millisecond, End: 3 * time.Second, Message: &Message{MsgID: "silence"}},
+	{Start: 0, End: 1500 * time.Millisecond, Message: &Message{MsgID: "a", Text: "hello world", Outcomes: []Outcome{{Intent: "hello", Confidence: 0.9}}}},
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestWriteWebVTT(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteWebVTT(buf, subtitleSegmentsFixture); err != nil {
+		t.Fatal(err)
+	}
+	expected := `WEBVTT
+
+1
+00:00:00.000 --> 00:00:01.500
+hello world
+
+2
+00:00:04.000 --> 01:02:03.045
+I would like to transfer &lt;one hundred&gt;
+dollars from checking to savings &amp; close
+it
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected WebVTT\n%s", buf.String())
+	}
+}
+
+func TestWriteSRT(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteSRT(buf, subtitleSegmentsFixture); err != nil {
+		t.Fatal(err)
+	}
+	expected := `1
+00:00:00,000 --> 00:00:01,500
+hello world
+
+2
+00:00:04,000 --> 01:02:03,045
+I would like to transfer <one hundred>
+dollars from checking to savings & close
+it
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected SRT\n%s", buf.String())
+	}
+}
+
+func TestWriteTranscript(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteTranscript(buf, "call.wav", subtitleSegmentsFixture); err != nil {
+		t.Fatal(err)
+	}
+	transcript := &Transcript{}
+	if err := json.Unmarshal(buf.Bytes(), transcript); err != nil {
+		t.Fatal(err)
+	}
+	if transcript.Source != "call.wav" || len(transcript.Segments) != 3 || transcript.Duration != 3723.045 {
+		t.Errorf("unexpected transcript %+v", transcript)
+	}
+	if transcript.Text != "hello world I would like to transfer <one hundred> dollars from checking to savings & close it" {
+		t.Errorf("unexpected transcript text %q", transcript.Text)
+	}
+	segment := transcript.Segments[2]
+	if segment.Index != 2 || segment.Intent != "transfer" || segment.MsgID != "b" || segment.Start != 4 ||
+		*segment.Entities["amount_of_money"][0].Body != "one hundred dollars" {
+		t.Errorf("unexpected segment %+v", segment)
+	}
+	if transcript.Segments[1].Text != "" || transcript.Segments[1].MsgID != "silence" {
+		t.Errorf("segments without text should be kept in the transcript %+v", transcript.Segments[1])
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright (c) 2014 Jason Goecke
+// throttle.go
+
+package wit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reasons a message is throttled
+const (
+	ThrottleBurst   = "burst"
+	ThrottleRate    = "rate"
+	ThrottleRepeat  = "repeat"
+	ThrottleBlocked = "blocked"
+)
+
+// MetadataThrottle holds the *ThrottleDecision of a throttled message in
+// the pipeline's result metadata
+const MetadataThrottle = "throttle"
+
+// DefaultThrottledReply is the reply to throttled messages when none is set
+const DefaultThrottledReply = "You're sending messages too quickly, please wait a moment."
+
+// ThrottleStore holds the counters of a throttler. Share one store, e.g.
+// backed by Redis, between replicas so limits apply across them.
+type ThrottleStore interface {
+	// Increment adds one to the counter of a key and returns its value. The
+	// counter starts over once the window has passed since its first increment.
+	Increment(ctx context.Context, key string, window time.Duration) (int64, error)
+	// Block blocks a key for a duration
+	Block(ctx context.Context, key string, duration time.Duration) error
+	// Blocked returns the time left on a key's block, zero when it is not blocked
+	Blocked(ctx context.Context, key string) (time.Duration, error)
+}
+
+// ThrottlePolicy represents the limits applied to each user of a channel.
+// A user may send Burst messages per BurstWindow, Rate messages per
+// RateWindow and Repeats identical messages per RepeatWindow; zero values
+// disable a limit. A user going over a limit is blocked for Penalty.
+type ThrottlePolicy struct {
+	Burst        int           `json:"burst,omitempty"`
+	BurstWindow  time.Duration `json:"burst_window,omitempty"`
+	Rate         int           `json:"rate,omitempty"`
+	RateWindow   time.Duration `json:"rate_window,omitempty"`
+	Repeats      int           `json:"repeats,omitempty"`
+	RepeatWindow time.Duration `json:"repeat_window,omitempty"`
+	Penalty      time.Duration `json:"penalty,omitempty"`
+}
+
+// ThrottleConfig represents the throttle policies, e.g.
+//
+//		default:
+//		  burst: 5
+//		  burst_window: 10s
+//		  rate: 30
+//		  rate_window: 1m
+//		  repeats: 3
+//		  repeat_window: 1m
+//		  penalty: 5m
+//		channels:
+//		  sms:
+//		    rate: 10
+//		    rate_window: 1m
+type ThrottleConfig struct {
+	Default  ThrottlePolicy             `json:"default"`
+	Channels map[string]*ThrottlePolicy `json:"channels,omitempty"`
+}
+
+// ThrottleDecision represents whether a message may go through
+type ThrottleDecision struct {
+	Allowed    bool          `json:"allowed"`
+	Reason     string        `json:"reason,omitempty"`
+	RetryAfter time.Duration `json:"retry_after,omitempty"`
+}
+
+// Throttler limits the messages of each user to protect the Wit quota
+type Throttler struct {
+	Store  ThrottleStore
+	Config *ThrottleConfig
+}
+
+// NewThrottler creates a throttler with an in-memory store
+//
+//		throttler := wit.NewThrottler(&wit.ThrottleConfig{Default: wit.ThrottlePolicy{Burst: 5, BurstWindow: 10 * time.Second}})
+func NewThrottler(config *ThrottleConfig) *Throttler {
+	return &Throttler{Store: NewMemoryThrottleStore(), Config: config}
+}
+
+// LoadThrottler reads a YAML throttle configuration, using an in-memory store
+//
+//		throttler, err := wit.LoadThrottler("./throttle.yaml")
+func LoadThrottler(path string) (*Throttler, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &ThrottleConfig{}
+	if err := unmarshalYAML(data, config); err != nil {
+		return nil, err
+	}
+	return NewThrottler(config), nil
+}
+
+// Policy returns the policy of a channel: the default policy, overridden
+// by the channel's non-zero settings
+//
+//		policy := throttler.Policy("sms")
+func (throttler *Throttler) Policy(channel string) ThrottlePolicy {
+	policy := throttler.Config.Default
+	override := throttler.Config.Channels[channel]
+	if override == nil {
+		return policy
+	}
+	if override.Burst != 0 {
+		policy.Burst = override.Burst
+	}
+	if override.BurstWindow != 0 {
+		policy.BurstWindow = override.BurstWindow
+	}
+	if override.Rate != 0 {
+		policy.Rate = override.Rate
+	}
+	if override.RateWindow != 0 {
+		policy.RateWindow = override.RateWindow
+	}
+	if override.Repeats != 0 {
+		policy.Repeats = override.Repeats
+	}
+	if override.RepeatWindow != 0 {
+		policy.RepeatWindow = override.RepeatWindow
+	}
+	if override.Penalty != 0 {
+		policy.Penalty = override.Penalty
+	}
+	return policy
+}
+
+// Check counts a message of a user on a channel and decides whether it may
+// go through
+//
+//		decision, err := throttler.Check(ctx, "sms", "+14155550123", text)
+//		if !decision.Allowed { ... }
+func (throttler *Throttler) Check(ctx context.Context, channel string, user string, text string) (*ThrottleDecision, error) {
+	policy := throttler.Policy(channel)
+	key := "wit:throttle:" + channel + ":" + user
+	if left, err := throttler.Store.Blocked(ctx, key); err != nil || left > 0 {
+		return &ThrottleDecision{Reason: ThrottleBlocked, RetryAfter: left}, err
+	}
+
+	checks := []struct {
+		reason string
+		limit  int
+		window time.Duration
+		key    string
+	}{
+		{ThrottleBurst, policy.Burst, policy.BurstWindow, key + ":burst"},
+		{ThrottleRate, policy.Rate, policy.RateWindow, key + ":rate"},
+		{ThrottleRepeat, policy.Repeats, policy.RepeatWindow, key + ":repeat:" + hashThrottleText(text)},
+	}
+	for _, check := range checks {
+		if check.limit <= 0 || check.window <= 0 {
+			continue
+		}
+		count, err := throttler.Store.Increment(ctx, check.key, check.window)
+		if err != nil {
+			return nil, err
+		}
+		if count <= int64(check.limit) {
+			continue
+		}
+		decision := &ThrottleDecision{Reason: check.reason, RetryAfter: check.window}
+		if policy.Penalty > 0 {
+			decision.RetryAfter = policy.Penalty
+			if err := throttler.Store.Block(ctx, key, policy.Penalty); err != nil {
+				return nil, err
+			}
+		}
+		return decision, nil
+	}
+	return &ThrottleDecision{Allowed: true}, nil
+}
+
+// Identifies a message regardless of case and spacing
+func hashThrottleText(text string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.Join(strings.Fields(text), " "))))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ThrottleHandler is HTTP middleware throttling requests to a handler per
+// user, answering 429 Too Many Requests with a Retry-After header
+type ThrottleHandler struct {
+	Throttler *Throttler
+	Channel   string
+	// User identifies the user of a request, requests without a user are
+	// not throttled, nor are any requests when User is nil
+	User func(r *http.Request) string
+	// Text returns the message of a request, the q parameter when nil
+	Text    func(r *http.Request) string
+	Handler http.Handler
+}
+
+// ServeHTTP throttles the request, then passes it to the handler
+func (handler *ThrottleHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user := ""
+	if handler.User != nil {
+		user = handler.User(r)
+	}
+	if user == "" {
+		handler.Handler.ServeHTTP(w, r)
+		return
+	}
+	text := r.URL.Query().Get("q")
+	if handler.Text != nil {
+		text = handler.Text(r)
+	}
+	decision, err := handler.Throttler.Check(r.Context(), handler.Channel, user, text)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !decision.Allowed {
+		seconds := int((decision.RetryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		http.Error(w, "too many requests: "+decision.Reason, http.StatusTooManyRequests)
+		return
+	}
+	handler.Handler.ServeHTTP(w, r)
+}
+
+// MemoryThrottleStore keeps throttle counters in memory, for a single replica
+type MemoryThrottleStore struct {
+	mutex    sync.Mutex
+	counters map[string]*throttleCounter
+	blocks   map[string]time.Time
+	sweeps   int
+}
+
+type throttleCounter struct {
+	count   int64
+	expires time.Time
+}
+
+// NewMemoryThrottleStore creates an in-memory throttle store
+//
+//		store := wit.NewMemoryThrottleStore()
+func NewMemoryThrottleStore() *MemoryThrottleStore {
+	return &MemoryThrottleStore{counters: map[string]*throttleCounter{}, blocks: map[string]time.Time{}}
+}
+
+// Increment adds one to a counter
+func (store *MemoryThrottleStore) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	now := time.Now()
+	store.sweep(now)
+	counter, ok := store.counters[key]
+	if !ok || now.After(counter.expires) {
+		counter = &throttleCounter{expires: now.Add(window)}
+		store.counters[key] = counter
+	}
+	counter.count++
+	return counter.count, nil
+}
+
+// Block blocks a key
+func (store *MemoryThrottleStore) Block(ctx context.Context, key string, duration time.Duration) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.blocks[key] = time.Now().Add(duration)
+	return nil
+}
+
+// Blocked returns the time left on a key's block
+func (store *MemoryThrottleStore) Blocked(ctx context.Context, key string) (time.Duration, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	until, ok := store.blocks[key]
+	if !ok {
+		return 0, nil
+	}
+	left := time.Until(until)
+	if left <= 0 {
+		delete(store.blocks, key)
+		return 0, nil
+	}
+	return left, nil
+}
+
+// Drops expired counters and blocks every thousand increments
+func (store *MemoryThrottleStore) sweep(now time.Time) {
+	store.sweeps++
+	if store.sweeps%1000 != 0 {
+		return
+	}
+	for key, counter := range store.counters {
+		if now.After(counter.expires) {
+			delete(store.counters, key)
+		}
+	}
+	for key, until := range store.blocks {
+		if now.After(until) {
+			delete(store.blocks, key)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2014 Jason Goecke
+// throttle_test.go
+
+package wit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestThrottler(t *testing.T) {
+	throttler := NewThrottler(&ThrottleConfig{
+		Default: ThrottlePolicy{Burst: 3, BurstWindow: time.Minute, Repeats: 2, RepeatWindow: time.Minute},
+		Channels: map[string]*ThrottlePolicy{
+			"sms":   {Burst: 1, BurstWindow: time.Minute, Penalty: time.Hour},
+			"voice": {Repeats: 5, RepeatWindow: time.Hour, Burst: 10},
+		},
+	})
+	ctx := context.Background()
+	check := func(channel, user, text string) *ThrottleDecision {
+		decision, err := throttler.Check(ctx, channel, user, text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return decision
+	}
+
+	if !check("web", "alice", "hello").Allowed || !check("web", "alice", "Hello ").Allowed {
+		t.Error("expected the first messages to go through")
+	}
+	if decision := check("web", "alice", "HELLO"); decision.Allowed || decision.Reason != ThrottleRepeat {
+		t.Errorf("expected a repeat to be throttled, got %+v", decision)
+	}
+	if decision := check("web", "alice", "something else"); decision.Allowed || decision.Reason != ThrottleBurst {
+		t.Errorf("expected a burst to be throttled, got %+v", decision)
+	}
+	if !check("web", "bob", "hello").Allowed {
+		t.Error("users should be throttled separately")
+	}
+
+	if policy := throttler.Policy("sms"); policy.Burst != 1 || policy.Repeats != 2 || policy.Penalty != time.Hour {
+		t.Errorf("channel policy not merged %+v", policy)
+	}
+	if policy := throttler.Policy("voice"); policy.Burst != 10 || policy.BurstWindow != time.Minute || policy.RepeatWindow != time.Hour {
+		t.Errorf("expected a limit without window to keep the default window %+v", policy)
+	}
+	check("sms", "carol", "one")
+	if decision := check("sms", "carol", "two"); decision.Allowed || decision.RetryAfter != time.Hour {
+		t.Errorf("expected a penalty, got %+v", decision)
+	}
+	if decision := check("sms", "carol", "three"); decision.Reason != ThrottleBlocked || decision.RetryAfter <= 0 {
+		t.Errorf("expected the user to be blocked, got %+v", decision)
+	}
+}
+
+func TestThrottleHandler(t *testing.T) {
+	handler := &ThrottleHandler{
+		Throttler: NewThrottler(&ThrottleConfig{Default: ThrottlePolicy{Rate: 1, RateWindow: 30 * time.Second}}),
+		Channel:   "api",
+		User:      func(r *http.Request) string { return r.Header.Get("X-User") },
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}),
+	}
+	request := func(user string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/message?q=hi", nil)
+		r.Header.Set("X-User", user)
+		handler.ServeHTTP(recorder, r)
+		return recorder
+	}
+	if recorder := request("alice"); recorder.Code != http.StatusOK {
+		t.Errorf("expected the first request to pass, got %d", recorder.Code)
+	}
+	if recorder := request("alice"); recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") != "30" {
+		t.Errorf("expected a 429, got %d %v", recorder.Code, recorder.Header())
+	}
+	if recorder := request(""); recorder.Code != http.StatusOK {
+		t.Errorf("anonymous requests should not be throttled, got %d", recorder.Code)
+	}
+	handler.User = nil
+	if recorder := request("alice"); recorder.Code != http.StatusOK {
+		t.Errorf("expected no throttling without User, got %d", recorder.Code)
+	}
+}
+
+func TestPipelineThrottling(t *testing.T) {
+	calls := 0
+	pipeline := &Pipeline{
+		Understand: func(ctx context.Context, request *MessageRequest) (*Message, error) {
+			calls++
+			return &Message{Text: request.Query}, nil
+		},
+		Throttler: NewThrottler(&ThrottleConfig{Default: ThrottlePolicy{Repeats: 1, RepeatWindow: time.Minute}}),
+	}
+	pipeline.Process(context.Background(), "user-42", "spam")
+	result, err := pipeline.Process(context.Background(), "user-42", "spam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decision, _ := result.Metadata[MetadataThrottle].(*ThrottleDecision)
+	if !result.Throttled || calls != 1 || decision == nil || decision.Reason != ThrottleRepeat ||
+		result.Reply.Text != DefaultThrottledReply {
+		t.Errorf("expected the repeat to be throttled before Wit %+v, %d calls", result, calls)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2014 Jason Goecke
+// tls.go
+
+package wit
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// PinPrefix prefixes SPKI pins, which are the base64 SHA-256 hash of a
+// certificate's public key, as in HPKP
+const PinPrefix = "sha256/"
+
+// TLSOptions represents the TLS settings of a client. When pins are set,
+// a connection is only accepted when a key of its verified chain matches
+// one of the pins or backup pins. Backup pins are the keys the server will
+// move to, at least one is required so rotating keys does not lock
+// clients out.
+type TLSOptions struct {
+	Pins       []string `json:"pins,omitempty"`
+	BackupPins []string `json:"backup_pins,omitempty"`
+	// RootCAs replaces the system roots when set
+	RootCAs *x509.CertPool `json:"-"`
+	// RootCAFile is a PEM file of root certificates replacing the system
+	// roots, such as the CA of a TLS-intercepting proxy
+	RootCAFile string `json:"root_ca_file,omitempty"`
+}
+
+// PinError is returned when no key of a server's certificate chain matches
+// the pins
+type PinError struct {
+	// Host is the server name, empty when connecting to an IP address
+	Host string
+	// Pins are the pins of the keys the server presented
+	Pins []string
+}
+
+// Error returns the error message
+func (err *PinError) Error() string {
+	host := err.Host
+	if host == "" {
+		host = "the server"
+	}
+	return fmt.Sprintf("no certificate of %s matches the pinned keys (got %s)", host, strings.Join(err.Pins, ", "))
+}
+
+// SPKIPin returns the pin of a certificate's public key
+//
+//		pin := wit.SPKIPin(cert) // sha256/...
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return PinPrefix + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// TLSConfig creates the tls.Config enforcing the options
+//
+//		config, err := options.TLSConfig()
+func (options *TLSOptions) TLSConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: options.RootCAs}
+	if options.RootCAFile != "" {
+		data, err := ioutil.ReadFile(expandHome(options.RootCAFile))
+		if err != nil {
+			return nil, err
+		}
+		if config.RootCAs == nil {
+			config.RootCAs = x509.NewCertPool()
+		}
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in %s", options.RootCAFile)
+		}
+	}
+
+	if len(options.Pins) == 0 && len(options.BackupPins) == 0 {
+		return config, nil
+	}
+	if len(options.BackupPins) == 0 {
+		return nil, errors.New("a backup pin is required with pins")
+	}
+	pins := map[string]bool{}
+	for _, pin := range append(append([]string(nil), options.Pins...), options.BackupPins...) {
+		normalized, err := normalizePin(pin)
+		if err != nil {
+			return nil, err
+		}
+		pins[normalized] = true
+	}
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		var presented []string
+		seen := map[string]bool{}
+		for _, chain := range state.VerifiedChains {
+			for _, cert := range chain {
+				pin := SPKIPin(cert)
+				if pins[pin] {
+					return nil
+				}
+				if !seen[pin] {
+					seen[pin] = true
+					presented = append(presented, pin)
+				}
+			}
+		}
+		return &PinError{Host: state.ServerName, Pins: presented}
+	}
+	return config, nil
+}
+
+// HTTPClient creates an HTTP client enforcing the options
+//
+//		client.HTTPClient, err = (&wit.TLSOptions{Pins: pins, BackupPins: backups}).HTTPClient()
+func (options *TLSOptions) HTTPClient() (*http.Client, error) {
+	config, err := options.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}, nil
+}
+
+// Returns a client like httpClient without the pins of its TLS settings,
+// keeping its roots, for the hosts other than the API's such as the
+// storage serving exported archives
+func unpinnedClient(httpClient *http.Client) *http.Client {
+	transport, ok := httpClient.Transport.(*http.Transport)
+	if !ok || transport.TLSClientConfig == nil || transport.TLSClientConfig.VerifyConnection == nil {
+		return httpClient
+	}
+	transport = transport.Clone()
+	transport.TLSClientConfig.VerifyConnection = nil
+	unpinned := *httpClient
+	unpinned.Transport = transport
+	return &unpinned
+}
+
+// Checks a pin is a base64 SHA-256 hash, adding the prefix when missing
+func normalizePin(pin string) (string, error) {
+	pin = strings.TrimSpace(pin)
+	encoded := strings.TrimPrefix(pin, PinPrefix)
+	sum, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sum) != sha256.Size {
+		return "", fmt.Errorf("invalid pin %q, expected sha256/<base64 SHA-256 of the public key>", pin)
+	}
+	return PinPrefix + encoded, nil
+}
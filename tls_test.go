@@ -0,0 +1,103 @@
+// Copyright (c) 2014 Jason Goecke
+// tls_test.go
+
+package wit
+
+import (
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testPin = "sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+
+func TestPinnedClient(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	}))
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	roots := server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	serverPin := SPKIPin(server.Certificate())
+
+	request := func(options *TLSOptions) error {
+		httpClient, err := options.HTTPClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := NewClient("token")
+		client.APIBase = server.URL
+		client.HTTPClient = httpClient
+		_, err = client.get(client.APIBase + "/intents")
+		return err
+	}
+
+	if err := request(&TLSOptions{RootCAs: roots, Pins: []string{testPin}, BackupPins: []string{serverPin}}); err != nil {
+		t.Errorf("expected the backup pin to match, got %v", err)
+	}
+	if err := request(&TLSOptions{RootCAs: roots, Pins: []string{strings.TrimPrefix(serverPin, PinPrefix)}, BackupPins: []string{testPin}}); err != nil {
+		t.Errorf("expected a pin without prefix to match, got %v", err)
+	}
+
+	err := request(&TLSOptions{RootCAs: roots, Pins: []string{testPin}, BackupPins: []string{testPin}})
+	pinErr := &PinError{}
+	if !errors.As(err, &pinErr) {
+		t.Fatalf("expected a pin error, got %v", err)
+	}
+	if len(pinErr.Pins) != 1 || pinErr.Pins[0] != serverPin || !strings.Contains(err.Error(), serverPin) {
+		t.Errorf("unexpected pin error %+v", pinErr)
+	}
+
+	if err := request(&TLSOptions{Pins: []string{serverPin}, BackupPins: []string{testPin}}); err == nil || errors.As(err, &pinErr) {
+		t.Errorf("expected an unknown authority error, got %v", err)
+	}
+}
+
+func TestRootCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ParseConfig([]byte("profiles:\n  proxy:\n    token: token\n    base_url: " + server.URL + "\n    tls:\n      root_ca_file: " + path + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, _ := config.Profile("proxy")
+	client, err := profile.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.get(client.APIBase + "/intents"); err != nil {
+		t.Errorf("expected the custom root to be trusted, got %v", err)
+	}
+
+	if _, err := (&TLSOptions{RootCAFile: filepath.Join(dir, "missing.pem")}).TLSConfig(); err == nil {
+		t.Error("expected a missing CA file to fail")
+	}
+}
+
+func TestPinValidation(t *testing.T) {
+	if _, err := (&TLSOptions{Pins: []string{testPin}}).TLSConfig(); err == nil {
+		t.Error("expected pins without a backup pin to fail")
+	}
+	if _, err := (&TLSOptions{Pins: []string{"sha256/not-a-hash"}, BackupPins: []string{testPin}}).TLSConfig(); err == nil {
+		t.Error("expected an invalid pin to fail")
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright (c) 2014 Jason Goecke
+// versions.go
+
+package wit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Shapes of /message responses
+const (
+	// MessageShapeOutcome has a single outcome, with msg_body
+	MessageShapeOutcome = "outcome"
+	// MessageShapeOutcomes has a list of outcomes, each with an intent,
+	// which is what Message decodes
+	MessageShapeOutcomes = "outcomes"
+	// MessageShapeEntities has top-level entities, the intent among them
+	MessageShapeEntities = "entities"
+	// MessageShapeIntents has a list of intents, entities named
+	// entity:role and traits
+	MessageShapeIntents = "intents"
+)
+
+// Shapes of /entities responses
+const (
+	// EntityListIDs is a list of entity ids
+	EntityListIDs = "ids"
+	// EntityListObjects is a list of objects with an id and a name
+	EntityListObjects = "objects"
+)
+
+// APIVersionSpec describes the behavior of the Wit API for the versions
+// from Version, a v= date, up to the next spec. Endpoints are "METHOD
+// /path" patterns, where :name matches a path segment.
+type APIVersionSpec struct {
+	Version         string   `json:"version"`
+	MessageShape    string   `json:"message_shape"`
+	EntityListShape string   `json:"entity_list_shape"`
+	Endpoints       []string `json:"endpoints"`
+}
+
+// APIVersions is the registry of API versions, oldest first
+var APIVersions = []*APIVersionSpec{
+	{
+		Version:         "20141022",
+		MessageShape:    MessageShapeOutcome,
+		EntityListShape: EntityListIDs,
+		Endpoints:       legacyEndpoints,
+	},
+	{
+		Version:         "20151127",
+		MessageShape:    MessageShapeOutcomes,
+		EntityListShape: EntityListIDs,
+		Endpoints:       legacyEndpoints,
+	},
+	{
+		Version:         "20160516",
+		MessageShape:    MessageShapeEntities,
+		EntityListShape: EntityListIDs,
+		Endpoints:       append([]string{"POST /converse"}, legacyEndpoints...),
+	},
+	{
+		Version:         "20170307",
+		MessageShape:    MessageShapeEntities,
+		EntityListShape: EntityListIDs,
+		Endpoints: append([]string{
+			"POST /converse",
+			"GET /samples", "POST /samples", "DELETE /samples",
+		}, legacyEndpoints...),
+	},
+	{
+		Version:         "20200513",
+		MessageShape:    MessageShapeIntents,
+		EntityListShape: EntityListObjects,
+		Endpoints: []string{
+			"GET /message", "POST /speech",
+			"GET /intents", "POST /intents", "GET /intents/:intent", "DELETE /intents/:intent",
+			"GET /entities", "POST /entities", "GET /entities/:entity", "PUT /entities/:entity", "DELETE /entities/:entity",
+			"POST /entities/:entity/keywords", "DELETE /entities/:entity/keywords/:keyword",
+			"POST /entities/:entity/keywords/:keyword/synonyms", "DELETE /entities/:entity/keywords/:keyword/synonyms/:synonym",
+			"GET /traits", "POST /traits", "GET /traits/:trait", "DELETE /traits/:trait",
+			"GET /utterances", "POST /utterances", "DELETE /utterances",
+			"GET /apps", "GET /export", "POST /import",
+		},
+	},
+}
+
+var legacyEndpoints = []string{
+	"GET /message", "POST /speech", "GET /messages/:id", "GET /intents",
+	"GET /entities", "POST /entities", "GET /entities/:id", "PUT /entities/:id", "DELETE /entities/:id",
+	"POST /entities/:id/values", "DELETE /entities/:id/values/:value",
+	"POST /entities/:id/values/:value/expressions", "DELETE /entities/:id/values/:value/expressions/:expression",
+	"GET /export", "POST /import",
+}
+
+// SunsetEndpoints are the endpoints Wit has retired, with what to use instead
+var SunsetEndpoints = map[string]string{
+	"POST /converse":    "the converse endpoint was sunset, use /message and keep the dialogue in your app",
+	"GET /messages/:id": "listing past messages was removed",
+	"GET /samples":      "samples were replaced by utterances, use /utterances",
+	"POST /samples":     "samples were replaced by utterances, use /utterances",
+	"DELETE /samples":   "samples were replaced by utterances, use /utterances",
+	"POST /entities/:id/values/:value/expressions":               "expressions were replaced by keyword synonyms, use /entities/:entity/keywords/:keyword/synonyms",
+	"DELETE /entities/:id/values/:value/expressions/:expression": "expressions were replaced by keyword synonyms, use /entities/:entity/keywords/:keyword/synonyms",
+}
+
+// LookupAPIVersion returns the spec of a v= date, with or without the v=
+// prefix: the latest spec at or before the date, as Wit answers with the
+// behavior of the version current at the date
+//
+//		spec, ok := wit.LookupAPIVersion("20160801")
+func LookupAPIVersion(version string) (*APIVersionSpec, bool) {
+	version = strings.TrimPrefix(version, "v=")
+	if len(version) != 8 {
+		return nil, false
+	}
+	i := sort.Search(len(APIVersions), func(i int) bool { return APIVersions[i].Version > version })
+	if i == 0 {
+		return nil, false
+	}
+	return APIVersions[i-1], true
+}
+
+// Supports reports whether an endpoint is part of the version
+//
+//		ok := spec.Supports("POST", "/converse")
+func (spec *APIVersionSpec) Supports(method string, path string) bool {
+	_, ok := matchEndpoint(spec.Endpoints, method, path)
+	return ok
+}
+
+// Deprecation returns why an endpoint was sunset, or an empty string
+//
+//		if reason := wit.Deprecation("POST", "/converse"); reason != "" { ... }
+func Deprecation(method string, path string) string {
+	patterns := make([]string, 0, len(SunsetEndpoints))
+	for pattern := range SunsetEndpoints {
+		patterns = append(patterns, pattern)
+	}
+	if pattern, ok := matchEndpoint(patterns, method, path); ok {
+		return SunsetEndpoints[pattern]
+	}
+	return ""
+}
+
+// Returns the pattern matching a request, if any
+func matchEndpoint(patterns []string, method string, path string) (string, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, pattern := range patterns {
+		parts := strings.SplitN(pattern, " ", 2)
+		if len(parts) != 2 || parts[0] != method {
+			continue
+		}
+		expected := strings.Split(strings.Trim(parts[1], "/"), "/")
+		if len(expected) != len(segments) {
+			continue
+		}
+		matched := true
+		for i, segment := range expected {
+			if !strings.HasPrefix(segment, ":") && segment != segments[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// Warnings already logged, by logger, version and endpoint
+var versionWarnings sync.Map
+
+// Logs, once per logger, a warning for requests to sunset endpoints or to
+// endpoints the client's version does not have
+func (client *Client) warnDeprecated(method string, resource string) {
+	if client.Logger == nil {
+		return
+	}
+	parsed, err := url.Parse(resource)
+	if err != nil {
+		return
+	}
+	path := parsed.Path
+	if base, err := url.Parse(client.APIBase); err == nil {
+		path = strings.TrimPrefix(path, strings.TrimSuffix(base.Path, "/"))
+	}
+	version := client.apiVersion()
+
+	var warning string
+	if reason := Deprecation(method, path); reason != "" {
+		warning = fmt.Sprintf("wit deprecation: %s %s: %s", method, path, reason)
+	} else if spec, ok := LookupAPIVersion(version); !ok {
+		warning = fmt.Sprintf("wit deprecation: version %s is not in the version registry", version)
+		path = ""
+	} else if !spec.Supports(method, path) {
+		warning = fmt.Sprintf("wit deprecation: %s %s is not an endpoint of version %s", method, path, version)
+	}
+	if warning == "" {
+		return
+	}
+	key := fmt.Sprintf("%p %s %s %s", client.Logger, version, method, path)
+	if _, warned := versionWarnings.LoadOrStore(key, true); !warned {
+		client.Logger.Print(warning)
+	}
+}
+
+// Returns the client's version date, without v=
+func (client *Client) apiVersion() string {
+	if client.Version != "" {
+		return strings.TrimPrefix(client.Version, "v=")
+	}
+	return strings.TrimPrefix(APIVersion, "v=")
+}
+
+// VersionProbe represents the behavior of the server for the client's
+// version compared with the registry
+type VersionProbe struct {
+	Version string          `json:"version"`
+	Spec    *APIVersionSpec `json:"spec,omitempty"`
+	// MessageShape and EntityListShape are the shapes the server answered with
+	MessageShape    string `json:"message_shape"`
+	EntityListShape string `json:"entity_list_shape"`
+	// Mismatches lists the differences with the registry
+	Mismatches []string `json:"mismatches,omitempty"`
+	// Compatible reports whether Message can decode the server's messages
+	Compatible bool `json:"compatible"`
+}
+
+// ProbeAPIVersion checks how the server behaves for the client's version,
+// sending one message and listing the entities
+//
+//		probe, err := client.ProbeAPIVersion(ctx)
+//		if len(probe.Mismatches) > 0 { ... }
+func (client *Client) ProbeAPIVersion(ctx context.Context) (*VersionProbe, error) {
+	probe := &VersionProbe{Version: client.apiVersion()}
+	spec, ok := LookupAPIVersion(probe.Version)
+	if ok {
+		probe.Spec = spec
+	} else {
+		probe.Mismatches = append(probe.Mismatches, "version "+probe.Version+" is not in the registry")
+	}
+
+	result, err := client.processRequestContext(ctx, &HTTPParams{Verb: "GET", Resource: client.APIBase + "/message?q=hello&msg_id=" + NewCorrelationID()})
+	if err != nil {
+		return nil, fmt.Errorf("probing /message: %s", err)
+	}
+	if probe.MessageShape, err = messageShape(result); err != nil {
+		return nil, fmt.Errorf("probing /message: %s", err)
+	}
+	result, err = client.processRequestContext(ctx, &HTTPParams{Verb: "GET", Resource: client.APIBase + "/entities"})
+	if err != nil {
+		return nil, fmt.Errorf("probing /entities: %s", err)
+	}
+	if probe.EntityListShape, err = entityListShape(result); err != nil {
+		return nil, fmt.Errorf("probing /entities: %s", err)
+	}
+
+	if spec != nil && probe.MessageShape != spec.MessageShape {
+		probe.Mismatches = append(probe.Mismatches, fmt.Sprintf("/message answered with %s instead of %s", probe.MessageShape, spec.MessageShape))
+	}
+	if spec != nil && probe.EntityListShape != "" && probe.EntityListShape != spec.EntityListShape {
+		probe.Mismatches = append(probe.Mismatches, fmt.Sprintf("/entities answered with %s instead of %s", probe.EntityListShape, spec.EntityListShape))
+	}
+	probe.Compatible = probe.MessageShape == MessageShapeOutcomes
+	return probe, nil
+}
+
+// Returns the shape of a /message response
+func messageShape(data []byte) (string, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", err
+	}
+	switch {
+	case fields["outcomes"] != nil:
+		return MessageShapeOutcomes, nil
+	case fields["outcome"] != nil:
+		return MessageShapeOutcome, nil
+	case fields["intents"] != nil:
+		return MessageShapeIntents, nil
+	case fields["entities"] != nil:
+		return MessageShapeEntities, nil
+	}
+	return "", fmt.Errorf("unknown response shape %s", data)
+}
+
+// Returns the shape of an /entities response, empty when there are no
+// entities to tell
+func entityListShape(data []byte) (string, error) {
+	items := []json.RawMessage{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return "", err
+	}
+	if len(items) == 0 {
+		return "", nil
+	}
+	if strings.HasPrefix(strings.TrimSpace(string(items[0])), `"`) {
+		return EntityListIDs, nil
+	}
+	return EntityListObjects, nil
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2014 Jason Goecke
+// versions_test.go
+
+package wit
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLookupAPIVersion(t *testing.T) {
+	spec, ok := LookupAPIVersion(APIVersion)
+	if !ok || spec.Version != "20151127" || spec.MessageShape != MessageShapeOutcomes {
+		t.Errorf("unexpected spec %+v for %s", spec, APIVersion)
+	}
+	spec, ok = LookupAPIVersion("20160801")
+	if !ok || spec.Version != "20160516" || !spec.Supports("POST", "/converse") {
+		t.Errorf("unexpected spec %+v for 20160801", spec)
+	}
+	spec, _ = LookupAPIVersion("20240101")
+	if spec.Supports("POST", "/converse") || !spec.Supports("DELETE", "/entities/city/keywords/Paris/synonyms/Lutece") {
+		t.Errorf("unexpected endpoints for %s", spec.Version)
+	}
+	if _, ok := LookupAPIVersion("20100101"); ok {
+		t.Error("expected a version older than the registry not to be found")
+	}
+	if _, ok := LookupAPIVersion("latest"); ok {
+		t.Error("expected an invalid version not to be found")
+	}
+
+	if Deprecation("POST", "/entities/city/values/Paris/expressions") == "" || Deprecation("POST", "/converse") == "" {
+		t.Error("expected sunset endpoints to be deprecated")
+	}
+	if Deprecation("GET", "/message") != "" || Deprecation("POST", "/entities/city/values") != "" {
+		t.Error("expected current endpoints not to be deprecated")
+	}
+}
+
+func TestDeprecationWarnings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	var logs bytes.Buffer
+	client := NewClient("token")
+	client.APIBase = server.URL + "/wit"
+	client.Logger = log.New(&logs, "", 0)
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.CreateEntityValueExp("city", "Paris", "City of Light"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := client.get(client.APIBase + "/entities"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], "POST /entities/city/values/Paris/expressions") || !strings.Contains(lines[0], "synonyms") {
+		t.Errorf("expected one deprecation warning, got %q", logs.String())
+	}
+
+	logs.Reset()
+	client.Version = "20200513"
+	if _, err := client.get(client.APIBase + "/messages/abc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.post(client.APIBase+"/entities/city/values", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), "listing past messages was removed") || !strings.Contains(logs.String(), "POST /entities/city/values is not an endpoint of version 20200513") {
+		t.Errorf("unexpected warnings %q", logs.String())
+	}
+}
+
+func TestProbeAPIVersion(t *testing.T) {
+	responses := map[string]map[string]string{
+		"20151127": {"/message": `{"msg_id":"1","_text":"hello","outcomes":[{"intent":"greet"}]}`, "/entities": `["city","wit$datetime"]`},
+		"20200513": {"/message": `{"text":"hello","intents":[],"entities":{},"traits":{}}`, "/entities": `[]`},
+		"20160516": {"/message": `{"msg_id":"1","_text":"hello","entities":{"intent":[{"value":"greet"}]}}`, "/entities": `[{"id":"1","name":"city"}]`},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(responses[r.URL.Query().Get("v")][r.URL.Path]))
+	}))
+	defer server.Close()
+	client := NewClient("token")
+	client.APIBase = server.URL
+
+	probe, err := client.ProbeAPIVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !probe.Compatible || len(probe.Mismatches) != 0 || probe.EntityListShape != EntityListIDs {
+		t.Errorf("unexpected probe %+v", probe)
+	}
+
+	client.Version = "20200513"
+	if probe, err = client.ProbeAPIVersion(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if probe.Compatible || probe.MessageShape != MessageShapeIntents || len(probe.Mismatches) != 0 {
+		t.Errorf("unexpected probe %+v", probe)
+	}
+
+	client.Version = "20160516"
+	if probe, err = client.ProbeAPIVersion(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(probe.Mismatches) != 1 || !strings.Contains(probe.Mismatches[0], "/entities answered with objects") {
+		t.Errorf("unexpected mismatches %v", probe.Mismatches)
+	}
+
+	client.Version = "20190101"
+	if _, err := client.ProbeAPIVersion(context.Background()); err == nil {
+		t.Error("expected an empty response to fail the probe")
+	}
+}
@@ -0,0 +1,395 @@
+// Copyright (c) 2014 Jason Goecke
+// webhook.go
+
+package wit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultWebhookTimeout is the timeout of a webhook call when none is configured
+const DefaultWebhookTimeout = 10 * time.Second
+
+// DispatchConfig represents the intents to webhooks mapping of a Dispatcher,
+// e.g.
+//
+//		min_confidence: 0.6
+//		fallback_reply: Sorry, I didn't get that
+//		intents:
+//		  order_pizza:
+//		    method: POST
+//		    url: https://orders.internal/api/pizzas
+//		    headers:
+//		      Authorization: Bearer {{ env "ORDERS_TOKEN" }}
+//		    body: |
+//		      {"size": {{ json .Entities.size }}, "customer": {{ json .Session.customer_id }}}
+//		    timeout: 2s
+//		    retries: 2
+//		    reply: Your pizza will arrive at {{ .Response.eta }}
+//		    error_reply: Sorry, ordering is down right now
+//		    session:
+//		      last_order: "{{ .Response.id }}"
+type DispatchConfig struct {
+	// MinConfidence is the confidence below which the fallback reply is used
+	MinConfidence float32             `json:"min_confidence,omitempty"`
+	FallbackReply string              `json:"fallback_reply,omitempty"`
+	Intents       map[string]*Webhook `json:"intents"`
+}
+
+// Webhook represents the HTTP call made for an intent. The URL, headers,
+// body, replies and session values are text/template templates executed
+// with a WebhookData. Retries are made on network errors, 429 and 5xx
+// responses, waiting RetryDelay and then twice as long each time. Calls
+// send the message's msg_id and thread_id as X-Request-ID and X-Thread-ID
+// headers, unless Headers sets them.
+type Webhook struct {
+	Method        string            `json:"method,omitempty"`
+	URL           string            `json:"url"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	Body          string            `json:"body,omitempty"`
+	Timeout       time.Duration     `json:"timeout,omitempty"`
+	Retries       int               `json:"retries,omitempty"`
+	RetryDelay    time.Duration     `json:"retry_delay,omitempty"`
+	MinConfidence float32           `json:"min_confidence,omitempty"`
+	Reply         string            `json:"reply,omitempty"`
+	ErrorReply    string            `json:"error_reply,omitempty"`
+	// Session maps session keys to templates of the values to store
+	Session map[string]string `json:"session,omitempty"`
+
+	templates map[string]*template.Template
+}
+
+// WebhookData represents the data webhook templates are executed with.
+// Entities holds the value of the first entity of each name, Response the
+// decoded JSON response, or its text when it is not JSON.
+type WebhookData struct {
+	Message     *Message
+	Outcome     *Outcome
+	Intent      string
+	Text        string
+	Entities    map[string]interface{}
+	AllEntities map[string][]MessageEntity
+	Session     map[string]interface{}
+	Status      int
+	Response    interface{}
+	Error       string
+}
+
+// WebhookError represents a webhook call that failed after its retries
+type WebhookError struct {
+	Intent   string
+	Status   int
+	Attempts int
+	Err      error
+}
+
+func (err *WebhookError) Error() string {
+	if err.Err != nil {
+		return fmt.Sprintf("webhook for %s failed after %d attempts: %s", err.Intent, err.Attempts, err.Err)
+	}
+	return fmt.Sprintf("webhook for %s failed after %d attempts: status %d", err.Intent, err.Attempts, err.Status)
+}
+
+// Dispatcher calls the webhook configured for the intent of a message
+type Dispatcher struct {
+	Config *DispatchConfig
+	// HTTPClient is used to call webhooks, http.DefaultClient when nil
+	HTTPClient *http.Client
+
+	fallback *template.Template
+}
+
+// Template functions available to webhooks: json encodes a value, env reads
+// an environment variable and default returns its first argument when the
+// second is empty
+var webhookFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+	"env": os.Getenv,
+	"default": func(fallback interface{}, value interface{}) interface{} {
+		if value == nil || value == "" {
+			return fallback
+		}
+		return value
+	},
+}
+
+// LoadDispatcher reads a YAML dispatch configuration
+//
+//		dispatcher, err := wit.LoadDispatcher("./webhooks.yaml")
+func LoadDispatcher(path string) (*Dispatcher, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &DispatchConfig{}
+	if err := unmarshalYAML(data, config); err != nil {
+		return nil, err
+	}
+	return NewDispatcher(config)
+}
+
+// NewDispatcher creates a dispatcher, parsing the configuration's templates
+//
+//		dispatcher, err := wit.NewDispatcher(config)
+func NewDispatcher(config *DispatchConfig) (*Dispatcher, error) {
+	dispatcher := &Dispatcher{Config: config}
+	var err error
+	if dispatcher.fallback, err = parseWebhookTemplate("fallback_reply", config.FallbackReply); err != nil {
+		return nil, err
+	}
+	for intent, webhook := range config.Intents {
+		if webhook == nil || webhook.URL == "" {
+			return nil, fmt.Errorf("webhook for %s has no url", intent)
+		}
+		webhook.templates = map[string]*template.Template{}
+		sources := map[string]string{"url": webhook.URL, "body": webhook.Body, "reply": webhook.Reply, "error_reply": webhook.ErrorReply}
+		for name, value := range webhook.Headers {
+			sources["headers."+name] = value
+		}
+		for key, value := range webhook.Session {
+			sources["session."+key] = value
+		}
+		for name, source := range sources {
+			if webhook.templates[name], err = parseWebhookTemplate(intent+"."+name, source); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return dispatcher, nil
+}
+
+func parseWebhookTemplate(name string, source string) (*template.Template, error) {
+	if source == "" {
+		return nil, nil
+	}
+	return template.New(name).Funcs(webhookFuncs).Parse(source)
+}
+
+// Handle calls the webhook for the intent of the message's best outcome and
+// renders its reply. Values mapped from the response are stored into the
+// session, which may be nil. Below the minimum confidence, or when no
+// webhook handles the intent, the fallback reply is returned, or
+// ErrUnhandledIntent when there is none.
+//
+//		reply, err := dispatcher.Handle(ctx, message, session)
+func (dispatcher *Dispatcher) Handle(ctx context.Context, message *Message, session *Session) (*Reply, error) {
+	data := newWebhookData(message, session)
+	var webhook *Webhook
+	if data.Outcome != nil {
+		webhook = dispatcher.Config.Intents[data.Intent]
+	}
+	if webhook != nil {
+		minConfidence := dispatcher.Config.MinConfidence
+		if webhook.MinConfidence > 0 {
+			minConfidence = webhook.MinConfidence
+		}
+		if data.Outcome.Confidence < minConfidence {
+			webhook = nil
+		}
+	}
+	if webhook == nil {
+		if dispatcher.fallback == nil {
+			return nil, ErrUnhandledIntent
+		}
+		text, err := renderWebhookTemplate(dispatcher.fallback, data)
+		if err != nil {
+			return nil, err
+		}
+		return &Reply{Text: text}, nil
+	}
+
+	if err := dispatcher.call(ctx, webhook, data); err != nil {
+		if webhook.templates["error_reply"] == nil {
+			return nil, err
+		}
+		data.Error = err.Error()
+		text, renderErr := renderWebhookTemplate(webhook.templates["error_reply"], data)
+		if renderErr != nil {
+			return nil, renderErr
+		}
+		return &Reply{Text: text}, nil
+	}
+
+	reply := &Reply{}
+	var err error
+	if reply.Text, err = renderWebhookTemplate(webhook.templates["reply"], data); err != nil {
+		return nil, err
+	}
+	for key := range webhook.Session {
+		value, err := renderWebhookTemplate(webhook.templates["session."+key], data)
+		if err != nil {
+			return nil, err
+		}
+		if reply.Session == nil {
+			reply.Session = map[string]interface{}{}
+		}
+		reply.Session[key] = value
+		if session != nil {
+			session.Set(key, value)
+		}
+	}
+	return reply, nil
+}
+
+// Calls the webhook with retries, storing the response into the data
+func (dispatcher *Dispatcher) call(ctx context.Context, webhook *Webhook, data *WebhookData) error {
+	url, err := renderWebhookTemplate(webhook.templates["url"], data)
+	if err != nil {
+		return err
+	}
+	body, err := renderWebhookTemplate(webhook.templates["body"], data)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{}
+	if id := data.Message.MsgID; id != "" {
+		headers["X-Request-Id"] = id
+	} else if id, ok := CorrelationID(ctx); ok {
+		headers["X-Request-Id"] = id
+	}
+	if id := data.Message.ThreadID; id != "" {
+		headers["X-Thread-Id"] = id
+	} else if id, ok := ThreadID(ctx); ok {
+		headers["X-Thread-Id"] = id
+	}
+	for name := range webhook.Headers {
+		if headers[http.CanonicalHeaderKey(name)], err = renderWebhookTemplate(webhook.templates["headers."+name], data); err != nil {
+			return err
+		}
+	}
+	method := strings.ToUpper(webhook.Method)
+	if method == "" {
+		method = "POST"
+	}
+	timeout := webhook.Timeout
+	if timeout <= 0 {
+		timeout = DefaultWebhookTimeout
+	}
+	delay := webhook.RetryDelay
+	if delay <= 0 {
+		delay = 100 * time.Millisecond
+	}
+
+	webhookErr := &WebhookError{Intent: data.Intent}
+	for attempt := 0; attempt <= webhook.Retries; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(delay << uint(attempt-1))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				webhookErr.Err = ctx.Err()
+				return webhookErr
+			case <-timer.C:
+			}
+		}
+		webhookErr.Attempts++
+		status, response, err := dispatcher.send(ctx, method, url, headers, body, timeout)
+		webhookErr.Status, webhookErr.Err = status, err
+		if err == nil && status < 300 {
+			data.Status, data.Response = status, response
+			return nil
+		}
+		if err == nil && status != http.StatusTooManyRequests && status < 500 {
+			break
+		}
+	}
+	return webhookErr
+}
+
+// Sends a single webhook request, decoding its response
+func (dispatcher *Dispatcher) send(ctx context.Context, method, url string, headers map[string]string, body string, timeout time.Duration) (int, interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req = req.WithContext(ctx)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	httpClient := dispatcher.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return res.StatusCode, nil, err
+	}
+	var response interface{}
+	if err := json.Unmarshal(data, &response); err != nil {
+		response = string(data)
+	}
+	return res.StatusCode, response, nil
+}
+
+// Builds the template data of a message and session
+func newWebhookData(message *Message, session *Session) *WebhookData {
+	data := &WebhookData{
+		Message:     message,
+		Text:        message.Text,
+		Entities:    map[string]interface{}{},
+		AllEntities: map[string][]MessageEntity{},
+		Session:     map[string]interface{}{},
+	}
+	if len(message.Outcomes) > 0 {
+		data.Outcome = &message.Outcomes[0]
+		data.Intent = data.Outcome.Intent
+		if data.Outcome.Entities != nil {
+			data.AllEntities = data.Outcome.Entities
+		}
+		for name, entities := range data.AllEntities {
+			if len(entities) > 0 {
+				data.Entities[name] = entityValue(entities[0])
+			}
+		}
+	}
+	if session != nil {
+		for key, value := range session.Data {
+			data.Session[key] = value
+		}
+	}
+	return data
+}
+
+// Returns the value of an entity, else its body
+func entityValue(entity MessageEntity) interface{} {
+	if entity.Value != nil {
+		return *entity.Value
+	}
+	if entity.Body != nil {
+		return *entity.Body
+	}
+	return nil
+}
+
+func renderWebhookTemplate(tmpl *template.Template, data *WebhookData) (string, error) {
+	if tmpl == nil {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2014 Jason Goecke
+// webhook_test.go
+
+package wit
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const testDispatchConfig = `
+min_confidence: 0.5
+fallback_reply: Sorry, I didn't get "{{ .Text }}"
+intents:
+  order_pizza:
+    method: post
+    url: "{{ .Session.base }}/pizzas"
+    headers:
+      X-Customer: "{{ .Session.customer_id }}"
+    body: |
+      {"size": {{ json .Entities.size }}, "count": {{ default 1 .Entities.number }}}
+    retries: 2
+    retry_delay: 1ms
+    reply: Your {{ .Entities.size }} pizza arrives at {{ .Response.eta }}
+    session:
+      last_order: "{{ .Response.id }}"
+  cancel_order:
+    url: "{{ .Session.base }}/broken"
+    retries: 1
+    retry_delay: 1ms
+    error_reply: Cancelling is down ({{ .Error }})
+  check_status:
+    url: "{{ .Session.base }}/missing"
+`
+
+func testWebhookMessage(intent string, confidence float32) *Message {
+	var size interface{} = "large"
+	return &Message{
+		Text: "one large pizza please",
+		Outcomes: []Outcome{{
+			Intent:     intent,
+			Confidence: confidence,
+			Entities:   map[string][]MessageEntity{"size": {{Value: &size}}},
+		}},
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	var pizzaCalls, brokenCalls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/pizzas":
+			if atomic.AddInt32(&pizzaCalls, 1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			var body map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["size"] != "large" ||
+				body["count"] != 1.0 || r.Method != "POST" || r.Header.Get("X-Customer") != "c-1234" {
+				t.Errorf("unexpected webhook request %s %v %v", r.Method, body, err)
+			}
+			if r.Header.Get("X-Request-ID") != "m-1" || r.Header.Get("X-Thread-ID") != "t-1" {
+				t.Errorf("expected the correlation ids in the headers %v", r.Header)
+			}
+			w.Write([]byte(`{"id": "o-1", "eta": "7pm"}`))
+		case "/broken":
+			atomic.AddInt32(&brokenCalls, 1)
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "webhooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "webhooks.yaml")
+	if err := ioutil.WriteFile(path, []byte(testDispatchConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dispatcher, err := LoadDispatcher(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := NewSession("user-42")
+	session.Set("base", server.URL)
+	session.Set("customer_id", "c-1234")
+
+	message := testWebhookMessage("order_pizza", 0.9)
+	message.MsgID = "m-1"
+	ctx := WithThreadID(context.Background(), "t-1")
+	reply, err := dispatcher.Handle(ctx, message, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Text != "Your large pizza arrives at 7pm" || reply.Session["last_order"] != "o-1" {
+		t.Errorf("unexpected reply %+v", reply)
+	}
+	if value, _ := session.Get("last_order"); value != "o-1" || pizzaCalls != 2 {
+		t.Errorf("session not updated or call not retried: %v, %d calls", value, pizzaCalls)
+	}
+
+	reply, err = dispatcher.Handle(context.Background(), testWebhookMessage("cancel_order", 0.9), session)
+	if err != nil || !strings.HasPrefix(reply.Text, "Cancelling is down (webhook for cancel_order failed after 2 attempts") ||
+		brokenCalls != 2 {
+		t.Errorf("unexpected error reply %+v %v after %d calls", reply, err, brokenCalls)
+	}
+
+	_, err = dispatcher.Handle(context.Background(), testWebhookMessage("check_status", 0.9), session)
+	if webhookErr, ok := err.(*WebhookError); !ok || webhookErr.Status != http.StatusNotFound || webhookErr.Attempts != 1 {
+		t.Errorf("expected a webhook error without retries, got %v", err)
+	}
+
+	reply, err = dispatcher.Handle(context.Background(), testWebhookMessage("order_pizza", 0.2), session)
+	if err != nil || reply.Text != `Sorry, I didn't get "one large pizza please"` {
+		t.Errorf("expected the fallback reply, got %+v %v", reply, err)
+	}
+
+	dispatcher.fallback = nil
+	if _, err := dispatcher.Handle(context.Background(), testWebhookMessage("weather", 0.9), nil); err != ErrUnhandledIntent {
+		t.Errorf("expected ErrUnhandledIntent, got %v", err)
+	}
+}
+
+func TestNewDispatcherErrors(t *testing.T) {
+	if _, err := NewDispatcher(&DispatchConfig{Intents: map[string]*Webhook{"a": {}}}); err == nil {
+		t.Error("expected an error for a webhook without url")
+	}
+	if _, err := NewDispatcher(&DispatchConfig{Intents: map[string]*Webhook{"a": {URL: "{{ .Nope"}}}); err == nil {
+		t.Error("expected an error for an invalid template")
+	}
+}
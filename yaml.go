@@ -0,0 +1,602 @@
+// Copyright (c) 2014 Jason Goecke
+// yaml.go
+
+package wit
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The configuration files of this package are written in a subset of YAML:
+// block mappings and sequences, flow sequences and mappings on a single line,
+// plain, quoted and block (| and >) scalars, and comments. Anchors, tags and
+// multiple documents are not supported. Documents are decoded into structs
+// using their json tags, so the same types read JSON and YAML.
+
+// yamlPlain is an unquoted scalar, which may resolve to a number, a boolean
+// or null when decoded into an interface{}
+type yamlPlain string
+
+// unmarshalYAML parses a YAML document and decodes it into v, which must
+// be a pointer
+//
+//		err := unmarshalYAML(data, &config)
+func unmarshalYAML(data []byte, v interface{}) error {
+	node, err := parseYAML(data)
+	if err != nil {
+		return err
+	}
+	out := reflect.ValueOf(v)
+	if out.Kind() != reflect.Ptr || out.IsNil() {
+		return errors.New("yaml: decoding requires a non-nil pointer")
+	}
+	return decodeYAML(node, out.Elem(), "")
+}
+
+// parseYAML parses a YAML document into maps, slices and scalars
+func parseYAML(data []byte) (interface{}, error) {
+	text := strings.Replace(string(data), "\r\n", "\n", -1)
+	parser := &yamlParser{lines: strings.Split(text, "\n")}
+	for n, line := range parser.lines {
+		if strings.TrimSpace(line) != "" && strings.Contains(line[:len(line)-len(strings.TrimLeft(line, " \t"))], "\t") {
+			return nil, fmt.Errorf("yaml: line %d: tabs are not allowed in indentation", n+1)
+		}
+	}
+	indent, _, ok := parser.peek()
+	if !ok {
+		return nil, nil
+	}
+	node, err := parser.parseNode(indent)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, ok := parser.peek(); ok {
+		return nil, parser.errorf("unexpected content")
+	}
+	return node, nil
+}
+
+type yamlParser struct {
+	lines []string
+	pos   int
+}
+
+func (parser *yamlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("yaml: line %d: %s", parser.pos+1, fmt.Sprintf(format, args...))
+}
+
+// Skips blank lines, comments and document markers, returning the indent
+// and content of the next line
+func (parser *yamlParser) peek() (int, string, bool) {
+	for parser.pos < len(parser.lines) {
+		line := parser.lines[parser.pos]
+		text := strings.TrimSpace(stripYAMLComment(line))
+		if text == "" || text == "---" {
+			parser.pos++
+			continue
+		}
+		return len(line) - len(strings.TrimLeft(line, " ")), text, true
+	}
+	return 0, "", false
+}
+
+// Parses the block starting on the next line, indented at indent
+func (parser *yamlParser) parseNode(indent int) (interface{}, error) {
+	_, text, ok := parser.peek()
+	if !ok {
+		return nil, nil
+	}
+	if isYAMLSequenceItem(text) {
+		return parser.parseSequence(indent)
+	}
+	if _, _, ok := splitYAMLKey(text); ok {
+		return parser.parseMapping(indent)
+	}
+	parser.pos++
+	return parser.parseInline(text)
+}
+
+func (parser *yamlParser) parseMapping(indent int) (interface{}, error) {
+	mapping := map[string]interface{}{}
+	for {
+		lineIndent, text, ok := parser.peek()
+		if !ok || lineIndent < indent {
+			break
+		}
+		if lineIndent > indent {
+			return nil, parser.errorf("unexpected indentation")
+		}
+		if isYAMLSequenceItem(text) {
+			return nil, parser.errorf("unexpected sequence item in a mapping")
+		}
+		key, rest, ok := splitYAMLKey(text)
+		if !ok {
+			return nil, parser.errorf("expected a key")
+		}
+		if _, exists := mapping[key]; exists {
+			return nil, parser.errorf("duplicate key %q", key)
+		}
+		parser.pos++
+		value, err := parser.parseValue(indent, rest, true)
+		if err != nil {
+			return nil, err
+		}
+		mapping[key] = value
+	}
+	return mapping, nil
+}
+
+func (parser *yamlParser) parseSequence(indent int) (interface{}, error) {
+	sequence := []interface{}{}
+	for {
+		lineIndent, text, ok := parser.peek()
+		if !ok || lineIndent < indent || !isYAMLSequenceItem(text) {
+			break
+		}
+		if lineIndent > indent {
+			return nil, parser.errorf("unexpected indentation")
+		}
+		rest := strings.TrimLeft(text[1:], " ")
+		var item interface{}
+		var err error
+		switch {
+		case rest == "" || strings.HasPrefix(rest, "|") || strings.HasPrefix(rest, ">"):
+			parser.pos++
+			item, err = parser.parseValue(indent, rest, false)
+		default:
+			// Parse the rest of the line as a block indented at its column
+			line := parser.lines[parser.pos]
+			dash := strings.Index(line, "-")
+			parser.lines[parser.pos] = line[:dash] + " " + line[dash+1:]
+			item, err = parser.parseNode(indent + len(text) - len(rest))
+		}
+		if err != nil {
+			return nil, err
+		}
+		sequence = append(sequence, item)
+	}
+	return sequence, nil
+}
+
+// Parses the value following a key or a sequence dash. A nested block must
+// be indented further, except a sequence directly under a mapping key.
+func (parser *yamlParser) parseValue(indent int, rest string, key bool) (interface{}, error) {
+	if strings.HasPrefix(rest, "|") || strings.HasPrefix(rest, ">") {
+		return parser.parseBlockScalar(indent, rest)
+	}
+	if rest != "" {
+		return parser.parseInline(rest)
+	}
+	lineIndent, text, ok := parser.peek()
+	if !ok {
+		return nil, nil
+	}
+	if lineIndent > indent || (key && lineIndent == indent && isYAMLSequenceItem(text)) {
+		return parser.parseNode(lineIndent)
+	}
+	return nil, nil
+}
+
+// Parses a literal (|) or folded (>) block scalar, with an optional
+// chomping indicator: - strips the final line break, + keeps trailing
+// blank lines
+func (parser *yamlParser) parseBlockScalar(indent int, header string) (interface{}, error) {
+	style, chomp := header[0], strings.TrimSpace(header[1:])
+	if chomp != "" && chomp != "-" && chomp != "+" {
+		return nil, parser.errorf("unsupported block scalar header %q", header)
+	}
+	var lines []string
+	contentIndent := -1
+	for ; parser.pos < len(parser.lines); parser.pos++ {
+		line := parser.lines[parser.pos]
+		if strings.TrimSpace(line) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		lineIndent := len(line) - len(strings.TrimLeft(line, " "))
+		if lineIndent <= indent {
+			break
+		}
+		if contentIndent < 0 {
+			contentIndent = lineIndent
+		}
+		if lineIndent < contentIndent {
+			return nil, parser.errorf("block scalar line is less indented than its first line")
+		}
+		lines = append(lines, line[contentIndent:])
+	}
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+
+	var text string
+	if style == '|' {
+		text = strings.Join(lines, "\n")
+	} else {
+		for i, line := range lines {
+			switch {
+			case i == 0:
+			case line == "" || lines[i-1] == "":
+				if line == "" {
+					text += "\n"
+				}
+			default:
+				text += " "
+			}
+			text += line
+		}
+	}
+	switch chomp {
+	case "-":
+	case "+":
+		text += "\n" + strings.Repeat("\n", trailing)
+	default:
+		text += "\n"
+	}
+	return text, nil
+}
+
+// Parses a flow collection or a scalar on a single line
+func (parser *yamlParser) parseInline(text string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, parser.errorf("unterminated flow sequence")
+		}
+		sequence := []interface{}{}
+		for _, item := range splitYAMLFlow(text[1 : len(text)-1]) {
+			value, err := parser.parseInline(item)
+			if err != nil {
+				return nil, err
+			}
+			sequence = append(sequence, value)
+		}
+		return sequence, nil
+	case strings.HasPrefix(text, "{"):
+		if !strings.HasSuffix(text, "}") {
+			return nil, parser.errorf("unterminated flow mapping")
+		}
+		mapping := map[string]interface{}{}
+		for _, item := range splitYAMLFlow(text[1 : len(text)-1]) {
+			key, rest, ok := splitYAMLKey(item)
+			if !ok {
+				return nil, parser.errorf("expected a key in flow mapping")
+			}
+			value, err := parser.parseInline(rest)
+			if err != nil {
+				return nil, err
+			}
+			mapping[key] = value
+		}
+		return mapping, nil
+	case strings.HasPrefix(text, `"`):
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, parser.errorf("invalid double-quoted scalar %s", text)
+		}
+		return value, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, parser.errorf("invalid single-quoted scalar %s", text)
+		}
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	case text == "":
+		return nil, nil
+	}
+	return yamlPlain(text), nil
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// Splits "key: value" into its key and value, the key may be quoted
+func splitYAMLKey(text string) (string, string, bool) {
+	if text == "" || strings.ContainsAny(text[:1], "[{") {
+		return "", "", false
+	}
+	end := -1
+	if quote := text[0]; quote == '"' || quote == '\'' {
+		closing := yamlQuoteEnd(text, 0)
+		if closing < 0 || !strings.HasPrefix(text[closing+1:], ":") {
+			return "", "", false
+		}
+		end = closing + 1
+	} else if i := strings.Index(text, ": "); i >= 0 {
+		end = i
+	} else if strings.HasSuffix(text, ":") {
+		end = len(text) - 1
+	}
+	if end < 0 || (end+1 < len(text) && text[end+1] != ' ') {
+		return "", "", false
+	}
+	key := strings.TrimSpace(text[:end])
+	if unquoted, ok := unquoteYAMLKey(key); ok {
+		key = unquoted
+	}
+	return key, strings.TrimSpace(text[end+1:]), true
+}
+
+func unquoteYAMLKey(key string) (string, bool) {
+	if strings.HasPrefix(key, `"`) {
+		value, err := strconv.Unquote(key)
+		return value, err == nil
+	}
+	if strings.HasPrefix(key, "'") && len(key) >= 2 {
+		return strings.Replace(key[1:len(key)-1], "''", "'", -1), true
+	}
+	return "", false
+}
+
+// Returns the index of the quote closing the quoted scalar at start, or -1
+func yamlQuoteEnd(text string, start int) int {
+	quote := text[start]
+	for i := start + 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case text[i] == quote && quote == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// Removes a trailing comment, a # starting a line or following whitespace
+// outside of quotes
+func stripYAMLComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"', '\'':
+			if i == 0 || strings.ContainsRune(" \t[{,:", rune(line[i-1])) {
+				if end := yamlQuoteEnd(line, i); end > 0 {
+					i = end
+				}
+			}
+		case '#':
+			if i == 0 || line[i-1] == ' ' || line[i-1] == '\t' {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// Splits the inside of a flow collection on commas outside of quotes and
+// nested collections
+func splitYAMLFlow(text string) []string {
+	var items []string
+	depth, start := 0, 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '"', '\'':
+			if end := yamlQuoteEnd(text, i); end > 0 {
+				i = end
+			}
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, strings.TrimSpace(text[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if last := strings.TrimSpace(text[start:]); last != "" || len(items) > 0 {
+		items = append(items, last)
+	}
+	return items
+}
+
+// Resolves a plain scalar to null, a boolean, an integer, a float or a string
+func resolveYAMLPlain(plain yamlPlain) interface{} {
+	text := string(plain)
+	switch text {
+	case "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil {
+		return f
+	}
+	return text
+}
+
+// Converts a parsed node to plain Go values
+func yamlValue(node interface{}) interface{} {
+	switch node := node.(type) {
+	case yamlPlain:
+		return resolveYAMLPlain(node)
+	case []interface{}:
+		values := make([]interface{}, len(node))
+		for i, item := range node {
+			values[i] = yamlValue(item)
+		}
+		return values
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(node))
+		for key, item := range node {
+			values[key] = yamlValue(item)
+		}
+		return values
+	}
+	return node
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Decodes a parsed node into out, matching mapping keys with json tags
+func decodeYAML(node interface{}, out reflect.Value, path string) error {
+	if plain, ok := node.(yamlPlain); node == nil || ok && resolveYAMLPlain(plain) == nil {
+		return nil
+	}
+	if out.Kind() == reflect.Ptr {
+		if out.IsNil() {
+			out.Set(reflect.New(out.Type().Elem()))
+		}
+		return decodeYAML(node, out.Elem(), path)
+	}
+	if out.Kind() == reflect.Interface && out.NumMethod() == 0 {
+		out.Set(reflect.ValueOf(yamlValue(node)))
+		return nil
+	}
+
+	mismatch := func() error {
+		if path == "" {
+			path = "document"
+		}
+		return fmt.Errorf("yaml: %s: cannot decode %v into %s", path, yamlValue(node), out.Type())
+	}
+	switch node := node.(type) {
+	case map[string]interface{}:
+		switch out.Kind() {
+		case reflect.Map:
+			if out.Type().Key().Kind() != reflect.String {
+				return mismatch()
+			}
+			if out.IsNil() {
+				out.Set(reflect.MakeMap(out.Type()))
+			}
+			for key, item := range node {
+				value := reflect.New(out.Type().Elem()).Elem()
+				if err := decodeYAML(item, value, yamlPath(path, key)); err != nil {
+					return err
+				}
+				out.SetMapIndex(reflect.ValueOf(key).Convert(out.Type().Key()), value)
+			}
+			return nil
+		case reflect.Struct:
+			fields := yamlFields(out)
+			for key, item := range node {
+				field, ok := fields[key]
+				if !ok {
+					return fmt.Errorf("yaml: %s: unknown field", yamlPath(path, key))
+				}
+				if err := decodeYAML(item, field, yamlPath(path, key)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return mismatch()
+	case []interface{}:
+		if out.Kind() != reflect.Slice {
+			return mismatch()
+		}
+		slice := reflect.MakeSlice(out.Type(), len(node), len(node))
+		for i, item := range node {
+			if err := decodeYAML(item, slice.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		out.Set(slice)
+		return nil
+	}
+
+	var text string
+	switch node := node.(type) {
+	case yamlPlain:
+		text = string(node)
+	case string:
+		text = node
+	}
+	if out.Type() == durationType {
+		duration, err := time.ParseDuration(text)
+		if err != nil {
+			return mismatch()
+		}
+		out.SetInt(int64(duration))
+		return nil
+	}
+	switch out.Kind() {
+	case reflect.String:
+		out.SetString(text)
+	case reflect.Bool:
+		switch strings.ToLower(text) {
+		case "true", "yes", "on":
+			out.SetBool(true)
+		case "false", "no", "off":
+			out.SetBool(false)
+		default:
+			return mismatch()
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(text, 0, out.Type().Bits())
+		if err != nil {
+			return mismatch()
+		}
+		out.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(text, 0, out.Type().Bits())
+		if err != nil {
+			return mismatch()
+		}
+		out.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(text, out.Type().Bits())
+		if err != nil {
+			return mismatch()
+		}
+		out.SetFloat(f)
+	default:
+		return mismatch()
+	}
+	return nil
+}
+
+// Returns the settable fields of a struct by json name, including those of
+// embedded structs
+func yamlFields(out reflect.Value) map[string]reflect.Value {
+	fields := map[string]reflect.Value{}
+	for i := 0; i < out.NumField(); i++ {
+		field := out.Type().Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		name := strings.TrimSpace(strings.Split(tag, ",")[0])
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for embedded, value := range yamlFields(out.Field(i)) {
+				if _, ok := fields[embedded]; !ok {
+					fields[embedded] = value
+				}
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = out.Field(i)
+	}
+	return fields
+}
+
+func yamlPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
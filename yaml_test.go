@@ -0,0 +1,132 @@
+// Copyright (c) 2014 Jason Goecke
+// yaml_test.go
+
+package wit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseYAML(t *testing.T) {
+	document := `
+# a comment
+name: wit   # trailing comment
+quoted: "a # not a comment"
+single: 'it''s'
+url: http://localhost:8080/path
+count: 3
+ratio: 0.5
+enabled: true
+empty:
+list:
+  - one
+  - two: 2
+    three: 3
+  - [a, "b, c"]
+inline: {a: 1, b: x}
+same_indent:
+- x
+- y
+literal: |
+  line one
+    indented
+  line three
+folded: >-
+  folded
+  text
+
+  paragraph
+after: done
+`
+	node, err := parseYAML([]byte(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"name":    "wit",
+		"quoted":  "a # not a comment",
+		"single":  "it's",
+		"url":     "http://localhost:8080/path",
+		"count":   int64(3),
+		"ratio":   0.5,
+		"enabled": true,
+		"empty":   nil,
+		"list": []interface{}{
+			"one",
+			map[string]interface{}{"two": int64(2), "three": int64(3)},
+			[]interface{}{"a", "b, c"},
+		},
+		"inline":      map[string]interface{}{"a": int64(1), "b": "x"},
+		"same_indent": []interface{}{"x", "y"},
+		"literal":     "line one\n  indented\nline three\n",
+		"folded":      "folded text\nparagraph",
+		"after":       "done",
+	}
+	if value := yamlValue(node); !reflect.DeepEqual(value, expected) {
+		t.Errorf("document not parsed properly\n got %#v\nwant %#v", value, expected)
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	for _, document := range []string{
+		"a: 1\na: 2",
+		"a: 1\n   b: 2",
+		"a:\n\t- b",
+		"a: [1, 2",
+		`a: "unterminated`,
+		"- a\nb: c",
+	} {
+		if _, err := parseYAML([]byte(document)); err == nil {
+			t.Errorf("expected an error parsing %q", document)
+		}
+	}
+}
+
+func TestUnmarshalYAML(t *testing.T) {
+	type inner struct {
+		Timeout time.Duration `json:"timeout"`
+		Codes   []int         `json:"codes"`
+	}
+	type document struct {
+		Name    string            `json:"name"`
+		Version string            `json:"version"`
+		Rate    float64           `json:"rate"`
+		Enabled bool              `json:"enabled"`
+		Inner   *inner            `json:"inner"`
+		Headers map[string]string `json:"headers"`
+		Extra   interface{}       `json:"extra"`
+	}
+	data := `
+name: test
+version: 20160516
+rate: 2.5
+enabled: yes
+inner:
+  timeout: 1.5s
+  codes: [500, 502]
+headers:
+  X-Id: "42"
+extra:
+  - 1
+  - two
+`
+	var decoded document
+	if err := unmarshalYAML([]byte(data), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Name != "test" || decoded.Version != "20160516" || decoded.Rate != 2.5 || !decoded.Enabled ||
+		decoded.Inner == nil || decoded.Inner.Timeout != 1500*time.Millisecond ||
+		!reflect.DeepEqual(decoded.Inner.Codes, []int{500, 502}) || decoded.Headers["X-Id"] != "42" ||
+		!reflect.DeepEqual(decoded.Extra, []interface{}{int64(1), "two"}) {
+		t.Errorf("document not decoded properly %+v", decoded)
+	}
+
+	if err := unmarshalYAML([]byte("unknown: 1"), &decoded); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+	if err := unmarshalYAML([]byte("rate: fast"), &decoded); err == nil {
+		t.Error("expected an error for a mistyped field")
+	}
+}